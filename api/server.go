@@ -0,0 +1,300 @@
+package api
+
+import (
+	"cryptoCrawl/analysis"
+	"cryptoCrawl/crawler"
+	"cryptoCrawl/storage"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRange    = 24 * time.Hour
+	defaultInterval = time.Minute
+	defaultPeriod   = 14
+	// bounds of a single request, so that it can not read an unbounded series
+	maxRange   = 31 * 24 * time.Hour
+	maxCandles = 10000
+)
+
+// indicators computed on the closes of the candles, by path
+var indicators = map[string]func(s analysis.Series, r *http.Request) (analysis.Series, error){
+	"rsi": periodic(func(s analysis.Series, p int) analysis.Series { return s.RSI(p) }),
+	"sma": periodic(func(s analysis.Series, p int) analysis.Series { return s.SMA(p) }),
+	"ema": periodic(func(s analysis.Series, p int) analysis.Series { return s.EMA(p) }),
+	"macd": func(s analysis.Series, r *http.Request) (analysis.Series, error) {
+		short, err := intParam(r, "short", 12)
+		if err != nil {
+			return nil, err
+		}
+		long, err := intParam(r, "long", 26)
+		if err != nil {
+			return nil, err
+		}
+		if short >= long {
+			return nil, fmt.Errorf("short should be less than long")
+		}
+		return s.MACD(short, long), nil
+	},
+	"roc": func(s analysis.Series, r *http.Request) (analysis.Series, error) {
+		return s.ROC(), nil
+	},
+}
+
+// periodic reads the period of an indicator from the request
+func periodic(f func(s analysis.Series, period int) analysis.Series) func(analysis.Series, *http.Request) (analysis.Series, error) {
+	return func(s analysis.Series, r *http.Request) (analysis.Series, error) {
+		p, err := intParam(r, "period", defaultPeriod)
+		if err != nil {
+			return nil, err
+		}
+		return f(s, p), nil
+	}
+}
+
+// Server answers queries on the trades of a reader
+type Server struct {
+	reader storage.Reader
+	mux    *http.ServeMux
+}
+
+func NewServer(r storage.Reader) *Server {
+	s := &Server{reader: r, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/trades", s.trades)
+	s.mux.HandleFunc("/candles", s.candles)
+	s.mux.HandleFunc("/indicators/", s.indicator)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("only GET is supported"))
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// candle is an OHLC with the volume traded during it
+type candle struct {
+	analysis.OHLC
+	Volume float64
+}
+
+func (s *Server) trades(w http.ResponseWriter, r *http.Request) {
+	q, err := parseQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	trades, err := storage.ReadTrades(s.reader, q)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	rows := make([][]interface{}, len(trades))
+	for i, t := range trades {
+		rows[i] = []interface{}{crawler.FromMillis(t.Timestamp), t.Price, t.Amount, t.TransactionType, t.TradeID}
+	}
+	write(w, r, []string{"time", "price", "amount", "type", "trade_id"}, rows)
+}
+
+func (s *Server) candles(w http.ResponseWriter, r *http.Request) {
+	candles, ok := s.readCandles(w, r)
+	if !ok {
+		return
+	}
+	rows := make([][]interface{}, len(candles))
+	for i, c := range candles {
+		rows[i] = []interface{}{c.Time, c.Open, c.High, c.Low, c.Close, c.Volume}
+	}
+	write(w, r, []string{"time", "open", "high", "low", "close", "volume"}, rows)
+}
+
+func (s *Server) indicator(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/indicators/")
+	compute, ok := indicators[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown indicator %s", name))
+		return
+	}
+	candles, ok := s.readCandles(w, r)
+	if !ok {
+		return
+	}
+	closes := make(analysis.Series, len(candles))
+	for i, c := range candles {
+		closes[i] = analysis.Sample{Time: c.Time, Value: c.Close, Volume: c.Volume}
+	}
+	values, err := compute(closes, r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	rows := make([][]interface{}, len(values))
+	for i, v := range values {
+		// a roc after a zero close is infinite, json has no value for it
+		var value interface{} = v.Value
+		if math.IsNaN(v.Value) || math.IsInf(v.Value, 0) {
+			value = nil
+		}
+		rows[i] = []interface{}{v.Time, value}
+	}
+	write(w, r, []string{"time", name}, rows)
+}
+
+// readCandles groups the trades of the request by its interval, it answers
+// the request itself on errors
+func (s *Server) readCandles(w http.ResponseWriter, r *http.Request) ([]candle, bool) {
+	q, err := parseQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+	interval, err := durationParam(r, "interval", defaultInterval)
+	if err == nil && interval > maxRange {
+		err = fmt.Errorf("interval should be at most %s", maxRange)
+	}
+	if err == nil && q.To.Sub(q.From)/interval > maxCandles {
+		err = fmt.Errorf("interval %s gives more than %d candles from %s to %s, use a larger one", interval, maxCandles, q.From, q.To)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+	series, err := storage.ReadSeries(s.reader, q)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return nil, false
+	}
+	grouped := series.GroupByTime(interval)
+	var res []candle
+	for i, o := range grouped.OHLC() {
+		c := candle{OHLC: o}
+		for _, sample := range grouped[i] {
+			c.Volume += sample.Volume
+		}
+		res = append(res, c)
+	}
+	return res, true
+}
+
+func parseQuery(r *http.Request) (storage.Query, error) {
+	v := r.URL.Query()
+	q := storage.Query{Platform: v.Get("platform"), Pair: v.Get("pair"), To: time.Now()}
+	if q.Platform == "" || q.Pair == "" {
+		return q, fmt.Errorf("platform and pair should be present")
+	}
+	var err error
+	if to := v.Get("to"); to != "" {
+		if q.To, err = parseTime(to); err != nil {
+			return q, err
+		}
+	}
+	q.From = q.To.Add(-defaultRange)
+	if from := v.Get("from"); from != "" {
+		if q.From, err = parseTime(from); err != nil {
+			return q, err
+		}
+	}
+	if !q.From.Before(q.To) {
+		return q, fmt.Errorf("from should be before to")
+	}
+	if q.To.Sub(q.From) > maxRange {
+		return q, fmt.Errorf("from and to should be at most %s apart", maxRange)
+	}
+	return q, nil
+}
+
+// parseTime reads an RFC3339 time or milliseconds since the epoch
+func parseTime(v string) (time.Time, error) {
+	if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return crawler.FromMillis(ms), nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return t, fmt.Errorf("invalid time %q, use RFC3339 or milliseconds", v)
+	}
+	return t, nil
+}
+
+func durationParam(r *http.Request, name string, def time.Duration) (time.Duration, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return d, nil
+}
+
+func intParam(r *http.Request, name string, def int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil || i <= 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return i, nil
+}
+
+// write answers with the rows as csv when asked, as a json array of
+// objects otherwise
+func write(w http.ResponseWriter, r *http.Request, columns []string, rows [][]interface{}) {
+	if r.URL.Query().Get("format") == "csv" || strings.Contains(r.Header.Get("Accept"), "text/csv") {
+		w.Header().Set("Content-Type", "text/csv")
+		cw := csv.NewWriter(w)
+		cw.Write(columns)
+		for _, row := range rows {
+			record := make([]string, len(row))
+			for i, v := range row {
+				record[i] = csvValue(v)
+			}
+			cw.Write(record)
+		}
+		cw.Flush()
+		return
+	}
+	res := make([]map[string]interface{}, len(rows))
+	for i, row := range rows {
+		res[i] = make(map[string]interface{}, len(columns))
+		for j, c := range columns {
+			if t, ok := row[j].(time.Time); ok {
+				row[j] = t.UTC()
+			}
+			res[i][c] = row[j]
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.Errorf("error writing response: %s", err)
+	}
+}
+
+func csvValue(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		return t.UTC().Format(time.RFC3339Nano)
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
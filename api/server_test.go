@@ -0,0 +1,110 @@
+package api
+
+import (
+	"cryptoCrawl/crawler"
+	"cryptoCrawl/storage"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeReader holds a trade every 10s from 2018-02-01 on
+type fakeReader struct {
+	queries []storage.Query
+	prices  []float64
+}
+
+func (f *fakeReader) Trades(q storage.Query, page func([]crawler.TradeMeasurement) error) error {
+	f.queries = append(f.queries, q)
+	prices := f.prices
+	if prices == nil {
+		prices = []float64{1, 3, 2, 4, 5, 4, 6, 7}
+	}
+	var trades []crawler.TradeMeasurement
+	for i, p := range prices {
+		trades = append(trades, crawler.TradeMeasurement{Meta: "trade", Platform: q.Platform, Pair: q.Pair, Price: p, Amount: 1, Timestamp: 1517443200000 + int64(i)*10000})
+	}
+	return page(trades)
+}
+
+func get(t *testing.T, s *Server, url string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+	return rec
+}
+
+func TestCandles(t *testing.T) {
+	reader := &fakeReader{}
+	s := NewServer(reader)
+	rec := get(t, s, "/candles?platform=binance&pair=BTCUSD&interval=30s&from=2018-02-01T00:00:00Z&to=1517529600000")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", rec.Code, rec.Body)
+	}
+	var candles []map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &candles); err != nil {
+		t.Fatal(err)
+	}
+	if len(candles) != 3 || candles[0]["open"] != 1. || candles[0]["high"] != 3. || candles[0]["close"] != 2. || candles[0]["volume"] != 3. {
+		t.Errorf("unexpected candles %v", candles)
+	}
+	if candles[0]["time"] != "2018-02-01T00:00:00Z" || reader.queries[0].To.Unix() != 1517529600 {
+		t.Errorf("unexpected time %v or query %+v", candles[0]["time"], reader.queries[0])
+	}
+
+	rec = get(t, s, "/candles?platform=binance&pair=BTCUSD&interval=30s&format=csv")
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	if rec.Header().Get("Content-Type") != "text/csv" || len(lines) != 4 || lines[1] != "2018-02-01T00:00:00Z,1,3,1,2,3" {
+		t.Errorf("unexpected csv %q", rec.Body)
+	}
+}
+
+func TestIndicators(t *testing.T) {
+	s := NewServer(&fakeReader{})
+	rec := get(t, s, "/indicators/sma?platform=binance&pair=BTCUSD&interval=10s&period=4")
+	var values []map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &values)
+	if len(values) != 5 || values[0]["sma"] != 2.5 {
+		t.Errorf("unexpected sma %s", rec.Body)
+	}
+	for url, code := range map[string]int{
+		"/indicators/nope?platform=binance&pair=BTCUSD":             http.StatusNotFound,
+		"/indicators/rsi?pair=BTCUSD":                               http.StatusBadRequest,
+		"/indicators/rsi?platform=binance&pair=BTCUSD&period=-1":    http.StatusBadRequest,
+		"/indicators/macd?platform=binance&pair=BTCUSD&short=30":    http.StatusBadRequest,
+		"/indicators/rsi?platform=binance&pair=BTCUSD&interval=10s": http.StatusOK,
+	} {
+		if rec := get(t, s, url); rec.Code != code {
+			t.Errorf("expected %d for %s, got %d %s", code, url, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestROCAfterZeroClose(t *testing.T) {
+	s := NewServer(&fakeReader{prices: []float64{1, 0, 2, 4}})
+	rec := get(t, s, "/indicators/roc?platform=binance&pair=BTCUSD&interval=10s")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", rec.Code, rec.Body)
+	}
+	var values []map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &values); err != nil {
+		t.Fatalf("invalid body %s: %s", rec.Body, err)
+	}
+	if len(values) != 3 || values[0]["roc"] != -100. || values[1]["roc"] != nil || values[2]["roc"] != 100. {
+		t.Errorf("expected null after the zero close, got %s", rec.Body)
+	}
+}
+
+func TestRequestBounds(t *testing.T) {
+	s := NewServer(&fakeReader{})
+	for _, url := range []string{
+		"/trades?platform=binance&pair=BTCUSD&from=2018-01-01T00:00:00Z&to=2018-03-01T00:00:00Z",
+		"/candles?platform=binance&pair=BTCUSD&interval=1s",
+		"/indicators/rsi?platform=binance&pair=BTCUSD&interval=800h",
+	} {
+		if rec := get(t, s, url); rec.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 for %s, got %d %s", url, rec.Code, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+// Package config loads the crawler configuration from json, yaml or toml
+// files, expanding ${ENV} references and applying environment overrides.
+package config
+
+import (
+	"bytes"
+	"cryptoCrawl/crawler"
+	"cryptoCrawl/storage"
+	"encoding/json"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	JSON = "json"
+	YAML = "yaml"
+	TOML = "toml"
+)
+
+var (
+	formats = map[string]string{
+		".json": JSON,
+		".yaml": YAML,
+		".yml":  YAML,
+		".toml": TOML,
+	}
+	// ${NAME} or ${NAME:-default}
+	envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+)
+
+type Config struct {
+	CrawlerCFGS []crawler.CrawlerConfig `json:"crawlers"`
+	WriterCFGS  []storage.WriterConfig  `json:"writers"`
+}
+
+// Errors collects every problem found in a config
+type Errors []error
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e Errors) orNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Env holds environment variables by name
+type Env map[string]string
+
+func Environ() Env {
+	env := Env{}
+	for _, kv := range os.Environ() {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 2 {
+			env[parts[0]] = parts[1]
+		}
+	}
+	return env
+}
+
+// Load reads a config file, the format is picked from its extension
+func Load(path string) (Config, error) {
+	format, ok := formats[strings.ToLower(filepath.Ext(path))]
+	if !ok {
+		return Config{}, fmt.Errorf("unsupported config format %q, use .json, .yaml, .yml or .toml", filepath.Ext(path))
+	}
+	bits, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	return Parse(bits, format, Environ())
+}
+
+func Parse(bits []byte, format string, env Env) (Config, error) {
+	cfg := Config{}
+	var tree interface{}
+	var err error
+	switch format {
+	case JSON:
+		dec := json.NewDecoder(bytes.NewReader(bits))
+		dec.UseNumber()
+		err = dec.Decode(&tree)
+	case YAML:
+		err = yaml.Unmarshal(bits, &tree)
+	case TOML:
+		m := map[string]interface{}{}
+		_, err = toml.Decode(string(bits), &m)
+		tree = m
+	default:
+		return cfg, fmt.Errorf("unsupported config format %s", format)
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("invalid %s: %s", format, err)
+	}
+	var errs Errors
+	tree = interpolate("", tree, env, &errs)
+	if len(errs) > 0 {
+		return cfg, errs
+	}
+	decode("", tree, reflect.ValueOf(&cfg).Elem(), &errs)
+	if len(errs) > 0 {
+		return cfg, errs
+	}
+	return cfg, applyOverrides(&cfg, env)
+}
+
+// interpolate replaces ${ENV} references in every string of the tree, it also
+// brings the yaml and toml specific containers down to the ones json uses
+func interpolate(path string, v interface{}, env Env, errs *Errors) interface{} {
+	switch t := v.(type) {
+	case []map[string]interface{}:
+		l := make([]interface{}, len(t))
+		for i, e := range t {
+			l[i] = e
+		}
+		return interpolate(path, l, env, errs)
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			m[fmt.Sprint(k)] = e
+		}
+		return interpolate(path, m, env, errs)
+	case string:
+		return envRef.ReplaceAllStringFunc(t, func(ref string) string {
+			m := envRef.FindStringSubmatch(ref)
+			if val, ok := env[m[1]]; ok {
+				return val
+			}
+			if m[2] != "" {
+				return m[3]
+			}
+			*errs = append(*errs, fieldError(path, "environment variable %s is not set", m[1]))
+			return ref
+		})
+	case map[string]interface{}:
+		for _, k := range sortedKeys(t) {
+			t[k] = interpolate(join(path, k), t[k], env, errs)
+		}
+	case []interface{}:
+		for i, e := range t {
+			t[i] = interpolate(fmt.Sprintf("%s[%d]", path, i), e, env, errs)
+		}
+	}
+	return v
+}
+
+var unmarshaler = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// decode fills out from the generic tree field by field so every error
+// carries the path of the offending value
+func decode(path string, v interface{}, out reflect.Value, errs *Errors) {
+	if v == nil {
+		return
+	}
+	if out.Addr().Type().Implements(unmarshaler) {
+		decodeJSON(path, v, out, errs)
+		return
+	}
+	switch out.Kind() {
+	case reflect.Struct:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			*errs = append(*errs, fieldError(path, "expected an object, got %s", describe(v)))
+			return
+		}
+		fields := jsonFields(out.Type())
+		for _, k := range sortedKeys(m) {
+			e := m[k]
+			i, ok := fields[k]
+			if !ok {
+				*errs = append(*errs, fieldError(join(path, k), "unknown field"))
+				continue
+			}
+			decode(join(path, k), e, out.Field(i), errs)
+		}
+	case reflect.Slice:
+		l, ok := v.([]interface{})
+		if !ok {
+			*errs = append(*errs, fieldError(path, "expected a list, got %s", describe(v)))
+			return
+		}
+		s := reflect.MakeSlice(out.Type(), len(l), len(l))
+		for i, e := range l {
+			decode(fmt.Sprintf("%s[%d]", path, i), e, s.Index(i), errs)
+		}
+		out.Set(s)
+	case reflect.Map:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			*errs = append(*errs, fieldError(path, "expected an object, got %s", describe(v)))
+			return
+		}
+		res := reflect.MakeMap(out.Type())
+		for _, k := range sortedKeys(m) {
+			e := m[k]
+			elem := reflect.New(out.Type().Elem()).Elem()
+			decode(join(path, k), e, elem, errs)
+			res.SetMapIndex(reflect.ValueOf(k), elem)
+		}
+		out.Set(res)
+	case reflect.String:
+		// writer params are strings but yaml and toml users will write numbers
+		switch t := v.(type) {
+		case string:
+			out.SetString(t)
+		case map[string]interface{}, []interface{}:
+			*errs = append(*errs, fieldError(path, "expected a string, got %s", describe(v)))
+		default:
+			out.SetString(fmt.Sprint(t))
+		}
+	default:
+		decodeJSON(path, v, out, errs)
+	}
+}
+
+func decodeJSON(path string, v interface{}, out reflect.Value, errs *Errors) {
+	bits, err := json.Marshal(v)
+	if err == nil {
+		err = json.Unmarshal(bits, out.Addr().Interface())
+	}
+	if te, ok := err.(*json.UnmarshalTypeError); ok {
+		*errs = append(*errs, fieldError(path, "expected %s, got %s", te.Type, te.Value))
+	} else if err != nil {
+		*errs = append(*errs, fieldError(path, "%s", err))
+	}
+}
+
+func jsonFields(t reflect.Type) map[string]int {
+	fields := map[string]int{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" || f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = i
+	}
+	return fields
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func describe(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "a list"
+	case string:
+		return "a string"
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func fieldError(path, format string, args ...interface{}) error {
+	if path == "" {
+		path = "config"
+	}
+	return fmt.Errorf("%s: %s", path, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,146 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const yamlConfig = `
+crawlers:
+  - name: kraken
+    pairs: [XXBTZUSD, XETHZUSD]
+    options:
+      poll_interval: 1s
+writers:
+  - name: influxdb
+    params:
+      host: ${INFLUX_HOST}
+      period: ${INFLUX_PERIOD:-5s}
+`
+
+const tomlConfig = `
+[[crawlers]]
+name = "kraken"
+pairs = ["XXBTZUSD", "XETHZUSD"]
+[crawlers.options]
+poll_interval = "1s"
+
+[[writers]]
+name = "influxdb"
+[writers.params]
+host = "${INFLUX_HOST}"
+period = "${INFLUX_PERIOD:-5s}"
+`
+
+const jsonConfig = `{
+  "crawlers": [{"name": "kraken", "pairs": ["XXBTZUSD", "XETHZUSD"], "options": {"poll_interval": "1s"}}],
+  "writers": [{"name": "influxdb", "params": {"host": "${INFLUX_HOST}", "period": "${INFLUX_PERIOD:-5s}"}}]
+}`
+
+func TestParseFormats(t *testing.T) {
+	env := Env{"INFLUX_HOST": "http://influx:8086"}
+	for format, src := range map[string]string{YAML: yamlConfig, TOML: tomlConfig, JSON: jsonConfig} {
+		cfg, err := Parse([]byte(src), format, env)
+		if err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+		if err := Validate(cfg); err != nil {
+			t.Errorf("%s: %s", format, err)
+		}
+		c := cfg.CrawlerCFGS[0]
+		if c.Name != "kraken" || len(c.Pairs) != 2 || c.Options.PollInterval.Duration != time.Second {
+			t.Errorf("%s: unexpected crawler config %+v", format, c)
+		}
+		params := cfg.WriterCFGS[0].Params
+		if params["host"] != "http://influx:8086" || params["period"] != "5s" {
+			t.Errorf("%s: unexpected writer params %+v", format, params)
+		}
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	env := Env{
+		"INFLUX_HOST":                                 "http://influx:8086",
+		"CRYPTOCRAWL_CRAWLER_KRAKEN_PAIRS":            "XXBTZEUR, XETHZEUR",
+		"CRYPTOCRAWL_CRAWLER_KRAKEN_OPTIONS_CHANNELS": "trades",
+		"CRYPTOCRAWL_WRITER_INFLUXDB_PERIOD":          "1m",
+	}
+	cfg, err := Parse([]byte(yamlConfig), YAML, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cfg.CrawlerCFGS[0]
+	if strings.Join(c.Pairs, ",") != "XXBTZEUR,XETHZEUR" || strings.Join(c.Options.Channels, ",") != "trades" {
+		t.Errorf("overrides not applied: %+v", c)
+	}
+	if p := cfg.WriterCFGS[0].Params["period"]; p != "1m" {
+		t.Errorf("expected period override, got %s", p)
+	}
+	env["CRYPTOCRAWL_CRAWLER_KRAKEN_OPTIONS_BOOK_DEPTH"] = "lots"
+	env["CRYPTOCRAWL_CRAWLER_BINANCE_PAIRS"] = "BTCUSDT"
+	_, err = Parse([]byte(yamlConfig), YAML, env)
+	expectErrors(t, err,
+		"CRYPTOCRAWL_CRAWLER_BINANCE_PAIRS: no crawler in the config matches",
+		`CRYPTOCRAWL_CRAWLER_KRAKEN_OPTIONS_BOOK_DEPTH: expected an integer, got "lots"`)
+}
+
+func TestErrorPaths(t *testing.T) {
+	src := `
+crawlers:
+  - name: kraken
+    pairs: [XXBTZUSD]
+    options:
+      book_depth: lots
+      pool_interval: 1s
+writers:
+  - name: influxdb
+    params:
+      host: ${INFLUX_HOST}
+`
+	_, err := Parse([]byte(src), YAML, Env{})
+	expectErrors(t, err, "writers[0].params.host: environment variable INFLUX_HOST is not set")
+	_, err = Parse([]byte(src), YAML, Env{"INFLUX_HOST": "http://influx:8086"})
+	expectErrors(t, err,
+		"crawlers[0].options.book_depth: expected int, got string",
+		"crawlers[0].options.pool_interval: unknown field")
+
+	cfg, err := Parse([]byte(`
+crawlers:
+  - name: kraken
+    pairs: [XXBTZUSD, DOGEUSD]
+  - name: binanse
+    pairs: [BTCUSDT]
+writers:
+  - name: influxdb
+    params:
+      host: influx
+      period: often
+  - name: mongo
+`), YAML, Env{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectErrors(t, Validate(cfg),
+		"crawlers[0].pairs[1]: kraken does not support DOGEUSD",
+		`crawlers[1].name: unknown crawler "binanse"`,
+		`writers[0].params.host: "influx" should be an absolute url`,
+		"writers[0].params.period: ",
+		"writers[1].name: unknown writer mongo")
+}
+
+func expectErrors(t *testing.T, err error, prefixes ...string) {
+	t.Helper()
+	errs, ok := err.(Errors)
+	if !ok {
+		t.Fatalf("expected config errors, got %v", err)
+	}
+	if len(errs) != len(prefixes) {
+		t.Fatalf("expected %d errors, got %d: %s", len(prefixes), len(errs), errs)
+	}
+	for i, p := range prefixes {
+		if !strings.HasPrefix(errs[i].Error(), p) {
+			t.Errorf("expected error starting with %q, got %q", p, errs[i])
+		}
+	}
+}
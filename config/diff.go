@@ -0,0 +1,233 @@
+package config
+
+import (
+	"cryptoCrawl/crawler"
+	"cryptoCrawl/storage"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Diff lists what changed between two configs, crawlers and writers are
+// matched by name and position among the entries sharing that name
+type Diff struct {
+	Crawlers []CrawlerDiff
+	Writers  []WriterDiff
+}
+
+func (d Diff) Empty() bool {
+	return len(d.Crawlers) == 0 && len(d.Writers) == 0
+}
+
+// CrawlerDiff has a nil Old for an added crawler and a nil New for a removed one
+type CrawlerDiff struct {
+	Key          string
+	Old, New     *crawler.CrawlerConfig
+	AddedPairs   []string
+	RemovedPairs []string
+	// fields other than pairs that changed, the crawler has to be restarted
+	Changed []string
+}
+
+func (d CrawlerDiff) String() string {
+	switch {
+	case d.Old == nil:
+		return fmt.Sprintf("crawler %s added with pairs %v", d.Key, d.New.Pairs)
+	case d.New == nil:
+		return fmt.Sprintf("crawler %s removed", d.Key)
+	}
+	var parts []string
+	if len(d.AddedPairs) > 0 {
+		parts = append(parts, fmt.Sprintf("pairs added %v", d.AddedPairs))
+	}
+	if len(d.RemovedPairs) > 0 {
+		parts = append(parts, fmt.Sprintf("pairs removed %v", d.RemovedPairs))
+	}
+	if len(d.Changed) > 0 {
+		parts = append(parts, "changed "+strings.Join(d.Changed, ", "))
+	}
+	return fmt.Sprintf("crawler %s: %s", d.Key, strings.Join(parts, ", "))
+}
+
+// WriterDiff has a nil Old for an added writer and a nil New for a removed one
+type WriterDiff struct {
+	Key      string
+	Old, New *storage.WriterConfig
+	// description of every param that changed
+	Changed []string
+}
+
+func (d WriterDiff) String() string {
+	switch {
+	case d.Old == nil:
+		return fmt.Sprintf("writer %s added", d.Key)
+	case d.New == nil:
+		return fmt.Sprintf("writer %s removed", d.Key)
+	}
+	return fmt.Sprintf("writer %s: %s", d.Key, strings.Join(d.Changed, ", "))
+}
+
+// Key identifies the i-th entry of a list of names, the first entry with a
+// name is keyed by the name alone
+func Key(names []string, i int) string {
+	n := 0
+	for _, name := range names[:i] {
+		if name == names[i] {
+			n++
+		}
+	}
+	if n == 0 {
+		return names[i]
+	}
+	return fmt.Sprintf("%s#%d", names[i], n+1)
+}
+
+func CrawlerKeys(cfg Config) []string {
+	names := make([]string, len(cfg.CrawlerCFGS))
+	for i, c := range cfg.CrawlerCFGS {
+		names[i] = c.Name
+	}
+	return keys(names)
+}
+
+func WriterKeys(cfg Config) []string {
+	names := make([]string, len(cfg.WriterCFGS))
+	for i, w := range cfg.WriterCFGS {
+		names[i] = w.Name
+	}
+	return keys(names)
+}
+
+func keys(names []string) []string {
+	res := make([]string, len(names))
+	for i := range names {
+		res[i] = Key(names, i)
+	}
+	return res
+}
+
+func Compare(old, new Config) Diff {
+	d := Diff{}
+	oldCrawlers := map[string]*crawler.CrawlerConfig{}
+	for i, k := range CrawlerKeys(old) {
+		oldCrawlers[k] = &old.CrawlerCFGS[i]
+	}
+	for i, k := range CrawlerKeys(new) {
+		n := &new.CrawlerCFGS[i]
+		o, ok := oldCrawlers[k]
+		delete(oldCrawlers, k)
+		if !ok {
+			d.Crawlers = append(d.Crawlers, CrawlerDiff{Key: k, New: n})
+			continue
+		}
+		cd := CrawlerDiff{Key: k, Old: o, New: n}
+		cd.AddedPairs = missing(n.Pairs, o.Pairs)
+		cd.RemovedPairs = missing(o.Pairs, n.Pairs)
+		if !reflect.DeepEqual(o.Endpoints, n.Endpoints) {
+			cd.Changed = append(cd.Changed, "endpoints")
+		}
+		if o.Capture != n.Capture {
+			cd.Changed = append(cd.Changed, "capture")
+		}
+		if !reflect.DeepEqual(o.Options, n.Options) {
+			cd.Changed = append(cd.Changed, "options")
+		}
+		if len(cd.AddedPairs)+len(cd.RemovedPairs)+len(cd.Changed) > 0 {
+			d.Crawlers = append(d.Crawlers, cd)
+		}
+	}
+	for _, k := range CrawlerKeys(old) {
+		if o, ok := oldCrawlers[k]; ok {
+			d.Crawlers = append(d.Crawlers, CrawlerDiff{Key: k, Old: o})
+		}
+	}
+
+	oldWriters := map[string]*storage.WriterConfig{}
+	for i, k := range WriterKeys(old) {
+		oldWriters[k] = &old.WriterCFGS[i]
+	}
+	for i, k := range WriterKeys(new) {
+		n := &new.WriterCFGS[i]
+		o, ok := oldWriters[k]
+		delete(oldWriters, k)
+		if !ok {
+			d.Writers = append(d.Writers, WriterDiff{Key: k, New: n})
+			continue
+		}
+		changed := changedParams(o.Params, n.Params)
+		if !reflect.DeepEqual(o.Filter, n.Filter) {
+			changed = append(changed, fmt.Sprintf("filter %+v -> %+v", o.Filter, n.Filter))
+		}
+		if !reflect.DeepEqual(o.Fields, n.Fields) {
+			changed = append(changed, fmt.Sprintf("fields %v -> %v", o.Fields, n.Fields))
+		}
+		if o.Queue != n.Queue {
+			changed = append(changed, fmt.Sprintf("queue %+v -> %+v", o.Queue, n.Queue))
+		}
+		if o.Spool != n.Spool {
+			changed = append(changed, fmt.Sprintf("spool %+v -> %+v", o.Spool, n.Spool))
+		}
+		if len(changed) > 0 {
+			d.Writers = append(d.Writers, WriterDiff{Key: k, Old: o, New: n, Changed: changed})
+		}
+	}
+	for _, k := range WriterKeys(old) {
+		if o, ok := oldWriters[k]; ok {
+			d.Writers = append(d.Writers, WriterDiff{Key: k, Old: o})
+		}
+	}
+	return d
+}
+
+// missing returns the elements of a not in b
+func missing(a, b []string) []string {
+	var res []string
+	for _, e := range a {
+		if !contains(b, e) {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+func changedParams(old, new map[string]string) []string {
+	var names []string
+	for k := range old {
+		names = append(names, k)
+	}
+	for k := range new {
+		if _, ok := old[k]; !ok {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	var res []string
+	for _, k := range names {
+		o, inOld := old[k]
+		n, inNew := new[k]
+		switch {
+		case inOld && inNew && o == n:
+		case secret(k):
+			res = append(res, k+" changed")
+		case !inOld:
+			res = append(res, fmt.Sprintf("%s set to %q", k, n))
+		case !inNew:
+			res = append(res, fmt.Sprintf("%s unset", k))
+		default:
+			res = append(res, fmt.Sprintf("%s %q -> %q", k, o, n))
+		}
+	}
+	return res
+}
+
+// secret params are never logged
+func secret(param string) bool {
+	param = strings.ToLower(param)
+	for _, s := range []string{"pass", "token", "secret"} {
+		if strings.Contains(param, s) {
+			return true
+		}
+	}
+	return false
+}
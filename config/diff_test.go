@@ -0,0 +1,61 @@
+package config
+
+import (
+	"cryptoCrawl/crawler"
+	"cryptoCrawl/storage"
+	"testing"
+	"time"
+)
+
+func TestCompare(t *testing.T) {
+	old := Config{
+		CrawlerCFGS: []crawler.CrawlerConfig{
+			{Name: crawler.Kraken, Pairs: []string{"XXBTZUSD", "XETHZUSD"}},
+			{Name: crawler.Binance, Pairs: []string{"BTCUSDT"}},
+			{Name: crawler.Bitstamp, Pairs: []string{"BTCUSD"}},
+		},
+		WriterCFGS: []storage.WriterConfig{
+			{Name: "influxdb", Params: map[string]string{"host": "http://a:8086", "password": "x"}},
+			{Name: "jline", Params: map[string]string{"path": "a.jsonl"}},
+		},
+	}
+	new := Config{
+		CrawlerCFGS: []crawler.CrawlerConfig{
+			{Name: crawler.Kraken, Pairs: []string{"XXBTZUSD", "XXBTZEUR"}},
+			{Name: crawler.Binance, Pairs: []string{"BTCUSDT"}, Options: crawler.Options{PollInterval: crawler.Duration{Duration: time.Second}}},
+			{Name: crawler.Binance, Pairs: []string{"ETHUSDT"}},
+		},
+		WriterCFGS: []storage.WriterConfig{
+			{Name: "influxdb", Params: map[string]string{"host": "http://b:8086", "password": "y"}},
+			{Name: "elasticsearch", Params: map[string]string{"host": "http://a:9200"}},
+		},
+	}
+	d := Compare(old, new)
+	expected := []string{
+		"crawler kraken: pairs added [XXBTZEUR], pairs removed [XETHZUSD]",
+		"crawler binance: changed options",
+		"crawler binance#2 added with pairs [ETHUSDT]",
+		"crawler bitstamp removed",
+		`writer influxdb: host "http://a:8086" -> "http://b:8086", password changed`,
+		"writer elasticsearch added",
+		"writer jline removed",
+	}
+	var got []string
+	for _, c := range d.Crawlers {
+		got = append(got, c.String())
+	}
+	for _, w := range d.Writers {
+		got = append(got, w.String())
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d changes, got %q", len(expected), got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("expected %q, got %q", expected[i], got[i])
+		}
+	}
+	if !Compare(new, new).Empty() {
+		t.Error("expected no changes comparing a config to itself")
+	}
+}
@@ -0,0 +1,161 @@
+package config
+
+import (
+	"cryptoCrawl/crawler"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	EnvPrefix     = "CRYPTOCRAWL_"
+	crawlerPrefix = EnvPrefix + "CRAWLER_"
+	writerPrefix  = EnvPrefix + "WRITER_"
+)
+
+type override func(c *crawler.CrawlerConfig, v string) error
+
+var (
+	// CRYPTOCRAWL_CRAWLER_<NAME>_<FIELD> overrides a field of every crawler
+	// named NAME, lists are comma separated
+	crawlerOverrides = map[string]override{
+		"PAIRS": func(c *crawler.CrawlerConfig, v string) error {
+			c.Pairs = splitList(v)
+			return nil
+		},
+		"CAPTURE": func(c *crawler.CrawlerConfig, v string) error {
+			c.Capture = v
+			return nil
+		},
+		"ENDPOINTS_REST": func(c *crawler.CrawlerConfig, v string) error {
+			c.Endpoints.Rest = v
+			return nil
+		},
+		"ENDPOINTS_WEBSOCKET": func(c *crawler.CrawlerConfig, v string) error {
+			c.Endpoints.Websocket = v
+			return nil
+		},
+		"ENDPOINTS_APP_KEY": func(c *crawler.CrawlerConfig, v string) error {
+			c.Endpoints.AppKey = v
+			return nil
+		},
+		"OPTIONS_POLL_INTERVAL": func(c *crawler.CrawlerConfig, v string) error {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return err
+			}
+			c.Options.PollInterval = crawler.Duration{Duration: d}
+			return nil
+		},
+		"OPTIONS_CHANNELS": func(c *crawler.CrawlerConfig, v string) error {
+			c.Options.Channels = splitList(v)
+			return nil
+		},
+		"OPTIONS_BOOK_DEPTH": func(c *crawler.CrawlerConfig, v string) error {
+			depth, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("expected an integer, got %q", v)
+			}
+			c.Options.BookDepth = depth
+			return nil
+		},
+	}
+)
+
+// applyOverrides layers the CRYPTOCRAWL_ environment variables on top of the
+// file, they only change crawlers and writers the file already declares;
+// CRYPTOCRAWL_WRITER_<NAME>_<PARAM> sets the lower cased PARAM of writer NAME
+func applyOverrides(cfg *Config, env Env) error {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		if strings.HasPrefix(k, EnvPrefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var errs Errors
+	for _, k := range keys {
+		var err error
+		switch {
+		case strings.HasPrefix(k, crawlerPrefix):
+			err = overrideCrawler(cfg, strings.TrimPrefix(k, crawlerPrefix), env[k])
+		case strings.HasPrefix(k, writerPrefix):
+			err = overrideWriter(cfg, strings.TrimPrefix(k, writerPrefix), env[k])
+		default:
+			err = fmt.Errorf("unknown override, use %s<NAME>_<FIELD> or %s<NAME>_<PARAM>", crawlerPrefix, writerPrefix)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", k, err))
+		}
+	}
+	return errs.orNil()
+}
+
+func overrideCrawler(cfg *Config, key, v string) error {
+	found := false
+	for i := range cfg.CrawlerCFGS {
+		c := &cfg.CrawlerCFGS[i]
+		prefix := envName(c.Name) + "_"
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		o, ok := crawlerOverrides[strings.TrimPrefix(key, prefix)]
+		if !ok {
+			return fmt.Errorf("unknown crawler field %s", strings.TrimPrefix(key, prefix))
+		}
+		if err := o(c, v); err != nil {
+			return err
+		}
+		found = true
+	}
+	if !found {
+		return fmt.Errorf("no crawler in the config matches")
+	}
+	return nil
+}
+
+func overrideWriter(cfg *Config, key, v string) error {
+	found := false
+	for i := range cfg.WriterCFGS {
+		w := &cfg.WriterCFGS[i]
+		prefix := envName(w.Name) + "_"
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if w.Params == nil {
+			w.Params = map[string]string{}
+		}
+		w.Params[strings.ToLower(strings.TrimPrefix(key, prefix))] = v
+		found = true
+	}
+	if !found {
+		return fmt.Errorf("no writer in the config matches")
+	}
+	return nil
+}
+
+// envName upper cases a name and replaces everything but letters and digits
+// with underscores
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func splitList(v string) []string {
+	var res []string
+	for _, p := range strings.Split(v, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			res = append(res, p)
+		}
+	}
+	return res
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"cryptoCrawl/crawler"
+	"cryptoCrawl/storage"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Validate checks crawler names, pairs, options and writer params without
+// connecting anywhere
+func Validate(cfg Config) error {
+	var errs Errors
+	for i, c := range cfg.CrawlerCFGS {
+		path := fmt.Sprintf("crawlers[%d]", i)
+		supported := crawler.SupportedPairs(c.Name)
+		if supported == nil {
+			errs = append(errs, fieldError(path+".name", "unknown crawler %q", c.Name))
+			continue
+		}
+		if len(c.Pairs) == 0 {
+			errs = append(errs, fieldError(path+".pairs", "at least one pair is required"))
+		}
+		for j, p := range c.Pairs {
+			if !contains(supported, p) {
+				errs = append(errs, fieldError(fmt.Sprintf("%s.pairs[%d]", path, j),
+					"%s does not support %s, use one of %s", c.Name, p, strings.Join(supported, ", ")))
+			}
+		}
+		if _, err := crawler.ResolveOptions(c); err != nil {
+			errs = append(errs, fieldError(path+".options", "%s", err))
+		}
+		if err := checkEndpoint(c.Endpoints.Rest); err != nil {
+			errs = append(errs, fieldError(path+".endpoints.rest", "%s", err))
+		}
+		if err := checkEndpoint(c.Endpoints.Websocket); err != nil {
+			errs = append(errs, fieldError(path+".endpoints.websocket", "%s", err))
+		}
+	}
+	for i, w := range cfg.WriterCFGS {
+		path := fmt.Sprintf("writers[%d]", i)
+		for _, err := range storage.ValidateParams(w.Name, w.Params) {
+			if pe, ok := err.(*storage.ParamError); ok {
+				errs = append(errs, fieldError(path+".params."+pe.Param, "%s", pe.Err))
+			} else {
+				errs = append(errs, fieldError(path+".name", "%s", err))
+			}
+		}
+		checks := storage.ValidateRoute(w)
+		checks = append(checks, storage.ValidateQueue(w.Queue)...)
+		checks = append(checks, storage.ValidateSpool(w.Name, w.Spool)...)
+		for _, err := range checks {
+			errs = append(errs, fmt.Errorf("%s.%s", path, err))
+		}
+	}
+	return errs.orNil()
+}
+
+func checkEndpoint(u string) error {
+	if u == "" {
+		return nil
+	}
+	if parsed, err := url.Parse(u); err != nil || parsed.Scheme == "" || parsed.Host == "" {
+		return fmt.Errorf("%q should be an absolute url", u)
+	}
+	return nil
+}
+
+func contains(l []string, s string) bool {
+	for _, e := range l {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -5,9 +5,9 @@ import (
 	"fmt"
 	"github.com/gorilla/websocket"
 	log "github.com/sirupsen/logrus"
-	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -21,89 +21,240 @@ var (
 )
 
 const (
-	tradeWSSFormat     = "wss://stream.binance.com:9443/ws/%s@aggTrade"
-	orderWSSFormat     = "wss://stream.binance.com:9443/ws/%s@depth"
+	binanceWSSEndpoint = "wss://stream.binance.com:9443/ws"
+	tradeWSSFormat     = "%s/%s@aggTrade"
+	orderWSSFormat     = "%s/%s@depth"
 	binanceApiEndpoint = "https://api.binance.com"
 )
 
 type BinanceCrawler struct {
+	clock
 	timeDiff  int64
-	tradeConn []*websocket.Conn
-	orderConn []*websocket.Conn
 	pairs     []string
 	writers   []DataWriter
 	tradeChan chan TradeMessageBinance
 	orderChan chan OrderMessageBinance
+	closeChan chan bool
+	// Close may be called once Loop returned on its own
+	closeOnce sync.Once
+	done      chan struct{}
+	recorder  *Recorder
+	options   Options
+
+	// one trade and one depth stream per pair, keyed by pair
+	locker      sync.Mutex
+	tradeConn   map[string]*websocket.Conn
+	orderConn   map[string]*websocket.Conn
+	wssEndpoint string
+	started     bool
 }
 
-func NewBinance(writers []DataWriter, pairs []string) (Crawler, error) {
-	serverTime, err := getBinanceServerTime()
+func NewBinance(writers []DataWriter, cfg CrawlerConfig) (Crawler, error) {
+	opts, err := ResolveOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	serverTime, err := getBinanceServerTime(cfg.Endpoints.rest(binanceApiEndpoint))
+	if err != nil {
+		return nil, err
+	}
+	rec, err := NewRecorder(cfg.Capture, Binance)
 	if err != nil {
 		return nil, err
 	}
 	timeDiff := serverTime - time.Now().Unix()
 	c := &BinanceCrawler{
-		pairs:     pairs,
-		writers:   writers,
-		orderChan: make(chan OrderMessageBinance, 1000),
-		tradeChan: make(chan TradeMessageBinance, 1000),
-		timeDiff:  timeDiff,
-	}
-	for _, p := range pairs {
-		p = strings.ToLower(p)
-		tradeConn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf(tradeWSSFormat, p), nil)
-		if err != nil {
+		pairs:       cfg.Pairs,
+		writers:     writers,
+		orderChan:   make(chan OrderMessageBinance, 1000),
+		tradeChan:   make(chan TradeMessageBinance, 1000),
+		tradeConn:   map[string]*websocket.Conn{},
+		orderConn:   map[string]*websocket.Conn{},
+		wssEndpoint: cfg.Endpoints.websocket(binanceWSSEndpoint),
+		timeDiff:    timeDiff,
+		closeChan:   make(chan bool),
+		done:        make(chan struct{}),
+		recorder:    rec,
+		options:     opts,
+	}
+	for _, p := range cfg.Pairs {
+		if err := c.dial(p); err != nil {
+			c.closeConns()
 			return nil, err
 		}
-		c.tradeConn = append(c.tradeConn, tradeConn)
-		orderConn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf(orderWSSFormat, p), nil)
+	}
+	return c, nil
+}
+
+// dial opens the streams of a pair, the caller holds the lock or is the
+// constructor
+func (c *BinanceCrawler) dial(pair string) error {
+	p := strings.ToLower(pair)
+	if c.options.Collects(Trades) {
+		tradeConn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf(tradeWSSFormat, c.wssEndpoint, p), nil)
+		if err != nil {
+			return err
+		}
+		c.tradeConn[pair] = tradeConn
+	}
+	if c.options.Collects(Books) {
+		orderConn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf(orderWSSFormat, c.wssEndpoint, p), nil)
 		if err != nil {
-			return nil, err
+			if tc, ok := c.tradeConn[pair]; ok {
+				tc.Close()
+				delete(c.tradeConn, pair)
+			}
+			return err
 		}
-		c.orderConn = append(c.orderConn, orderConn)
+		c.orderConn[pair] = orderConn
 	}
-	return c, nil
+	return nil
 }
 
 func (c *BinanceCrawler) produce() {
-	for i := range c.orderConn {
-		or := c.orderConn[i]
-		tr := c.tradeConn[i]
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	c.started = true
+	for _, or := range c.orderConn {
 		go c.produceOrder(or)
+	}
+	for _, tr := range c.tradeConn {
 		go c.produceTrade(tr)
 	}
 }
 
+func (c *BinanceCrawler) Subscribe(pair string) error {
+	if _, ok := binancePairMapping[pair]; !ok {
+		return fmt.Errorf("%s does not support pair %s", Binance, pair)
+	}
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	_, trades := c.tradeConn[pair]
+	_, orders := c.orderConn[pair]
+	if trades || orders {
+		return fmt.Errorf("already subscribed to %s on %s", pair, Binance)
+	}
+	if err := c.dial(pair); err != nil {
+		return err
+	}
+	if c.started {
+		if or, ok := c.orderConn[pair]; ok {
+			go c.produceOrder(or)
+		}
+		if tr, ok := c.tradeConn[pair]; ok {
+			go c.produceTrade(tr)
+		}
+	}
+	log.Infof("subscribed to %s on %s", pair, Binance)
+	return nil
+}
+
+func (c *BinanceCrawler) Unsubscribe(pair string) error {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	tr, trades := c.tradeConn[pair]
+	or, orders := c.orderConn[pair]
+	if !trades && !orders {
+		return fmt.Errorf("not subscribed to %s on %s", pair, Binance)
+	}
+	delete(c.tradeConn, pair)
+	delete(c.orderConn, pair)
+	// the producers notice the dropped connection and return
+	if trades {
+		tr.Close()
+	}
+	if orders {
+		or.Close()
+	}
+	log.Infof("unsubscribed from %s on %s", pair, Binance)
+	return nil
+}
+
+// active tells whether a connection still belongs to a subscribed pair
+func (c *BinanceCrawler) active(conn *websocket.Conn) bool {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	for _, tc := range c.tradeConn {
+		if tc == conn {
+			return true
+		}
+	}
+	for _, oc := range c.orderConn {
+		if oc == conn {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *BinanceCrawler) produceOrder(orderChan *websocket.Conn) {
-	m := &OrderMessageBinance{}
 	for {
-		err := orderChan.ReadJSON(m)
+		_, bits, err := orderChan.ReadMessage()
 		if err != nil {
+			if c.closed() || !c.active(orderChan) {
+				return
+			}
 			log.Errorf("error reading from WS: %s", err)
 			continue
 		}
-		c.orderChan <- *m
+		c.recorder.Record(order, bits)
+		m := OrderMessageBinance{}
+		if err = json.Unmarshal(bits, &m); err != nil {
+			parseErrors.WithLabelValues(Binance).Inc()
+			log.Errorf("error decoding WS message: %s", err)
+			continue
+		}
+		select {
+		case c.orderChan <- m:
+		case <-c.done:
+			return
+		}
 	}
 
 }
 
 func (c *BinanceCrawler) produceTrade(tradeConn *websocket.Conn) {
-	m := &TradeMessageBinance{}
 	for {
-		err := tradeConn.ReadJSON(m)
+		_, bits, err := tradeConn.ReadMessage()
 		if err != nil {
+			if c.closed() || !c.active(tradeConn) {
+				return
+			}
 			log.Errorf("error reading from WS: %s", err)
 			continue
 		}
-		c.tradeChan <- *m
+		c.recorder.Record(trade, bits)
+		m := TradeMessageBinance{}
+		if err = json.Unmarshal(bits, &m); err != nil {
+			parseErrors.WithLabelValues(Binance).Inc()
+			log.Errorf("error decoding WS message: %s", err)
+			continue
+		}
+		select {
+		case c.tradeChan <- m:
+		case <-c.done:
+			return
+		}
 	}
 }
 
-func (c *BinanceCrawler) Close() {
+func (c *BinanceCrawler) closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
 
+func (c *BinanceCrawler) Close() {
+	c.closeOnce.Do(func() { close(c.closeChan) })
+	c.recorder.Close()
 }
 
 func (c *BinanceCrawler) closeConns() {
+	c.locker.Lock()
+	defer c.locker.Unlock()
 	for _, c := range c.orderConn {
 		c.Close()
 	}
@@ -116,79 +267,116 @@ func (c *BinanceCrawler) Loop() {
 	c.produce()
 	for {
 		select {
+		case <-c.closeChan:
+			log.Info("closing down binance crawler")
+			close(c.done)
+			c.closeConns()
+			return
 		case t, ok := <-c.tradeChan:
 			if !ok {
 				c.closeConns()
 				close(c.orderChan)
 				return
 			}
-			if v, ok := binancePairMapping[t.Pair]; ok {
-				typ := buy
-				if t.IsMaker {
-					typ = sell
-				}
-				m := TradeMeasurement{
-					Amount:          t.Amount,
-					Price:           t.Price,
-					Pair:            v,
-					Platform:        Binance,
-					TradeType:       limit,
-					Timestamp:       time.Now().Unix(),
-					TransactionType: typ,
-					Meta:            trade,
-				}
-				for _, w := range c.writers {
-					w.Write(m)
-				}
-			} else {
-				log.Errorf("unrecognized reverse mapping: %s", t.Pair)
-			}
+			c.handleTrade(t)
 		case o, ok := <-c.orderChan:
 			if !ok {
 				c.closeConns()
 				close(c.tradeChan)
 				return
 			}
-			if v, ok := binancePairMapping[o.Pair]; ok {
-				for i, b := range o.Bid {
-					if b.Amount == 0 {
-						continue
-					}
-					m := OrderMeasurement{
-						Pair:      v,
-						Meta:      order,
-						Timestamp: Now() - int64(i),
-						Platform:  Binance,
-						Type:      buy,
-						Price:     b.Price,
-						Amount:    b.Amount,
-					}
-					for _, w := range c.writers {
-						w.Write(m)
-					}
-				}
-				for i, a := range o.Ask {
-					if a.Amount == 0 {
-						continue
-					}
-					m := OrderMeasurement{
-						Pair:      v,
-						Meta:      order,
-						Timestamp: Now() - int64(i),
-						Platform:  Binance,
-						Type:      sell,
-						Price:     a.Price,
-						Amount:    a.Amount,
-					}
-					for _, w := range c.writers {
-						w.Write(m)
-					}
-				}
-			} else {
-				log.Errorf("unrecognized reverse mapping: %s", o.Pair)
+			c.handleOrder(o)
+		}
+	}
+}
+
+func (c *BinanceCrawler) handleTrade(t TradeMessageBinance) {
+	if v, ok := binancePairMapping[t.Pair]; ok {
+		typ := buy
+		if t.IsMaker {
+			typ = sell
+		}
+		m := TradeMeasurement{
+			Amount:          t.Amount,
+			Price:           t.Price,
+			Pair:            v,
+			Platform:        Binance,
+			TradeType:       limit,
+			Timestamp:       c.now(),
+			TransactionType: typ,
+			Meta:            trade,
+			TradeID:         strconv.FormatInt(t.AggregatedTrade, 10),
+		}
+		emit(c.writers, m, t.TradeTimestamp)
+	} else {
+		log.Errorf("unrecognized reverse mapping: %s", t.Pair)
+	}
+}
+
+func (c *BinanceCrawler) handleOrder(o OrderMessageBinance) {
+	if v, ok := binancePairMapping[o.Pair]; ok {
+		for i, b := range o.Bid {
+			if b.Amount == 0 {
+				continue
+			}
+			m := OrderMeasurement{
+				Pair:      v,
+				Meta:      order,
+				Timestamp: c.now() - int64(i),
+				Platform:  Binance,
+				Type:      buy,
+				Price:     b.Price,
+				Amount:    b.Amount,
+			}
+			emit(c.writers, m, o.Timestamp)
+		}
+		for i, a := range o.Ask {
+			if a.Amount == 0 {
+				continue
+			}
+			m := OrderMeasurement{
+				Pair:      v,
+				Meta:      order,
+				Timestamp: c.now() - int64(i),
+				Platform:  Binance,
+				Type:      sell,
+				Price:     a.Price,
+				Amount:    a.Amount,
 			}
+			emit(c.writers, m, o.Timestamp)
 		}
+	} else {
+		log.Errorf("unrecognized reverse mapping: %s", o.Pair)
+	}
+}
+
+func newBinanceReplayer(writers []DataWriter, cfg CrawlerConfig) (Replayer, error) {
+	opts, err := ResolveOptions(cfg)
+	if err != nil {
+		return nil, err
 	}
+	return &BinanceCrawler{pairs: cfg.Pairs, writers: writers, options: opts}, nil
+}
+
+func (c *BinanceCrawler) Replay(source string, data []byte, received int64) error {
+	c.replayed = received
+	switch source {
+	case trade:
+		m := TradeMessageBinance{}
+		if err := json.Unmarshal(data, &m); err != nil {
+			return err
+		}
+		c.handleTrade(m)
+	case order:
+		m := OrderMessageBinance{}
+		if err := json.Unmarshal(data, &m); err != nil {
+			return err
+		}
+		c.handleOrder(m)
+	default:
+		return unknownSource(source)
+	}
+	return nil
 }
 
 type TradeMessageBinance struct {
@@ -252,13 +440,9 @@ type TimeResponse struct {
 	Time int64 `json:"serverTime"`
 }
 
-func getBinanceServerTime() (int64, error) {
-	r, err := http.Get(binanceApiEndpoint + "/api/v1/time")
-	if err != nil {
-		return 0, err
-	}
+func getBinanceServerTime(apiEndpoint string) (int64, error) {
 	t := &TimeResponse{}
-	err = ReadJson(r, t)
+	err := restClientFor(Binance).GetJson(apiEndpoint+"/api/v1/time", t)
 	if err != nil {
 		return 0, err
 	}
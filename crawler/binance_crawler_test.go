@@ -0,0 +1,71 @@
+package crawler
+
+import (
+	"cryptoCrawl/crawler/mockexchange"
+	"testing"
+)
+
+func TestBinanceCrawler(t *testing.T) {
+	s := mockexchange.Binance("BTCUSDT")
+	defer s.Close()
+	w := make(chanWriter, 10)
+	cfg := CrawlerConfig{
+		Name:      Binance,
+		Pairs:     []string{"BTCUSDT"},
+		Endpoints: Endpoints{Rest: s.URL, Websocket: s.WebsocketURL() + "/ws"},
+	}
+	c, err := NewBinance([]DataWriter{w}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go c.Loop()
+	defer c.Close()
+	trades, orders := expectMeasurements(t, w, 3)
+	if len(trades) != 1 || len(orders) != 2 {
+		t.Fatalf("expected 1 trade and 2 orders, got %+v %+v", trades, orders)
+	}
+	tr := trades[0]
+	if tr.Pair != BTCUSD || tr.Platform != Binance || tr.Price != 15000.5 || tr.Amount != 0.25 || tr.TransactionType != sell {
+		t.Fatalf("unexpected trade %+v", tr)
+	}
+	checkOrders(t, orders, Binance, BTCUSD)
+}
+
+func TestBinanceSubscribe(t *testing.T) {
+	s := mockexchange.Binance("BTCUSDT", "ETHUSDT")
+	defer s.Close()
+	w := make(chanWriter, 10)
+	cfg := CrawlerConfig{
+		Name:      Binance,
+		Pairs:     []string{"BTCUSDT"},
+		Endpoints: Endpoints{Rest: s.URL, Websocket: s.WebsocketURL() + "/ws"},
+		Options:   Options{Channels: []string{Trades}},
+	}
+	c, err := NewBinance([]DataWriter{w}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go c.Loop()
+	defer c.Close()
+	trades, _ := expectMeasurements(t, w, 1)
+	if trades[0].Pair != BTCUSD {
+		t.Fatalf("unexpected trade %+v", trades[0])
+	}
+	ps := c.(PairSubscriber)
+	if err := ps.Unsubscribe("BTCUSDT"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.Subscribe("ETHUSDT"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.Subscribe("DOGEUSDT"); err == nil {
+		t.Fatal("expected an error subscribing to an unsupported pair")
+	}
+	trades, _ = expectMeasurements(t, w, 1)
+	if trades[0].Pair != ETHUSD {
+		t.Fatalf("unexpected trade %+v", trades[0])
+	}
+	if s.Hits("/ws/btcusdt@depth") != 0 {
+		t.Fatal("order book stream should not be dialed when only trades are collected")
+	}
+}
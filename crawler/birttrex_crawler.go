@@ -1,8 +1,11 @@
 package crawler
 
 import (
+	"fmt"
 	log "github.com/sirupsen/logrus"
 	"github.com/toorop/go-bittrex"
+	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -23,53 +26,120 @@ const (
 )
 
 type BittrexCrawler struct {
+	clock
 	writers   []DataWriter
 	client    bittrex.Bittrex
-	pairs     []string
+	pairs     *pairList
 	data      sync.Map
 	timDiff   int64
 	closeChan chan bool
+	// Close may be called once Loop returned on its own
+	closeOnce sync.Once
+	recorder  *Recorder
+	options   Options
 }
 
-func NewBittrex(writers []DataWriter, pairs []string) (Crawler, error) {
-	cli := bittrex.New("", "")
+func NewBittrex(writers []DataWriter, cfg CrawlerConfig) (Crawler, error) {
+	opts, err := ResolveOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	rest, err := restClientFor(Bittrex).WithBaseURL(cfg.Endpoints.Rest)
+	if err != nil {
+		return nil, err
+	}
+	rec, err := NewRecorder(cfg.Capture, Bittrex)
+	if err != nil {
+		return nil, err
+	}
+	cli := bittrex.NewWithCustomHttpClient("", "", rest.WithRecorder(rec).HTTPClient())
 	return &BittrexCrawler{
+		recorder:  rec,
 		writers:   writers,
-		pairs:     pairs,
+		pairs:     newPairList(cfg.Pairs),
 		client:    *cli,
 		data:      sync.Map{},
 		closeChan: make(chan bool),
+		options:   opts,
 	}, nil
 }
 
-func (c *BittrexCrawler) Close() {}
+func (c *BittrexCrawler) Close() {
+	c.closeOnce.Do(func() { close(c.closeChan) })
+}
 
 func (c *BittrexCrawler) Loop() {
-	t := time.Tick(600 * time.Millisecond)
+	t := time.Tick(c.options.PollInterval.Duration)
 	for {
 		select {
 		case <-t:
-			for _, p := range c.pairs {
+			for _, p := range c.pairs.list() {
 				if v, ok := bitrexPairMapping[p]; ok {
-					go func() {
-						trades, err := c.client.GetMarketHistory(p)
-						if err != nil {
-							log.Errorf("error getting market data: %s", err)
-						} else {
-							c.handle(v, trades)
-						}
-					}()
+					go c.readHistory(p, v)
 				} else {
 					log.Errorf("unknown mapping: %s", p)
 				}
 			}
 		case <-c.closeChan:
 			log.Info("closing down bittrex crawler")
+			c.recorder.Close()
 			return
 		}
 	}
 }
 
+func (c *BittrexCrawler) readHistory(market, pair string) {
+	trades, err := c.client.GetMarketHistory(market)
+	if err != nil {
+		log.Errorf("error getting market data: %s", err)
+		return
+	}
+	c.handle(pair, trades)
+}
+
+// bittrexReplayer hands the recorded responses to the bittrex client, which
+// parses them for handle as if they came from the exchange
+type bittrexReplayer struct {
+	crawler   *BittrexCrawler
+	transport *replayTransport
+}
+
+func newBittrexReplayer(writers []DataWriter, cfg CrawlerConfig) (Replayer, error) {
+	opts, err := ResolveOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	t := &replayTransport{}
+	return &bittrexReplayer{
+		crawler: &BittrexCrawler{
+			writers: writers,
+			pairs:   newPairList(cfg.Pairs),
+			client:  *bittrex.NewWithCustomHttpClient("", "", &http.Client{Transport: t}),
+			options: opts,
+		},
+		transport: t,
+	}, nil
+}
+
+func (r *bittrexReplayer) Replay(source string, data []byte, received int64) error {
+	r.crawler.replayed = received
+	u, err := restSource(source)
+	if err != nil {
+		return err
+	}
+	if !strings.HasSuffix(strings.ToLower(u.Path), "getmarkethistory") {
+		return unknownSource(source)
+	}
+	market := strings.ToUpper(u.Query().Get("market"))
+	pair, ok := bitrexPairMapping[market]
+	if !ok {
+		return fmt.Errorf("unknown bittrex market %s", market)
+	}
+	r.transport.body = data
+	r.crawler.readHistory(market, pair)
+	return nil
+}
+
 func (c *BittrexCrawler) handle(pair string, trades []bittrex.Trade) {
 	if len(trades) == 0 {
 		log.Warn("no actual trades to process")
@@ -93,9 +163,10 @@ func (c *BittrexCrawler) handle(pair string, trades []bittrex.Trade) {
 			Meta:      trade,
 			Platform:  Bittrex,
 			Pair:      pair,
-			Timestamp: Now() - int64(i),
+			Timestamp: c.now() - int64(i),
 			Amount:    t.Quantity,
 			Price:     t.Price,
+			TradeID:   strconv.FormatInt(t.OrderUuid, 10),
 		}
 		ttype := strings.ToLower(t.OrderType)
 		if ttype != buy && ttype != sell {
@@ -103,8 +174,14 @@ func (c *BittrexCrawler) handle(pair string, trades []bittrex.Trade) {
 			return
 		}
 		m.TransactionType = ttype
-		for _, w := range c.writers {
-			w.Write(m)
-		}
+		emit(c.writers, m, 0)
 	}
 }
+
+func (c *BittrexCrawler) Subscribe(pair string) error {
+	return subscribePair(c.pairs, bitrexPairMapping, Bittrex, pair)
+}
+
+func (c *BittrexCrawler) Unsubscribe(pair string) error {
+	return unsubscribePair(c.pairs, Bittrex, pair)
+}
@@ -2,9 +2,14 @@ package crawler
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/bitfinexcom/bitfinex-api-go/v2"
 	log "github.com/sirupsen/logrus"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 var (
@@ -18,32 +23,56 @@ var (
 )
 
 type BitfinexCrawler struct {
+	clock
 	client    bitfinex.Client
 	pairs     []string
 	timeDiff  int64
 	writers   []DataWriter
 	closeChan chan bool
+	// Close may be called once Loop returned on its own
+	closeOnce sync.Once
+	recorder  *Recorder
+	options   Options
 }
 
-func NewBitfinex(writers []DataWriter, pairs []string) (Crawler, error) {
+func NewBitfinex(writers []DataWriter, cfg CrawlerConfig) (Crawler, error) {
+	opts, err := ResolveOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
 	cl := bitfinex.NewClient()
+	if cfg.Endpoints.Rest != "" {
+		u, err := url.Parse(cfg.Endpoints.rest("") + "/")
+		if err != nil {
+			return nil, err
+		}
+		cl.BaseURL = u
+	}
+	cl.Websocket.BaseURL = cfg.Endpoints.websocket(cl.Websocket.BaseURL)
 	status, err := cl.Platform.Status()
 	if !status || err != nil {
 		return nil, fmt.Errorf("unable to contact platform")
 	}
+	rec, err := NewRecorder(cfg.Capture, Bitfin)
+	if err != nil {
+		return nil, err
+	}
 	crawler := &BitfinexCrawler{
+		recorder:  rec,
 		client:    *cl,
-		pairs:     pairs,
+		pairs:     cfg.Pairs,
 		timeDiff:  0,
 		writers:   writers,
 		closeChan: make(chan bool),
+		options:   opts,
 	}
 	crawler.connect()
 	return crawler, nil
 }
 
 func (c *BitfinexCrawler) Close() {
-	c.closeChan <- true
+	c.closeOnce.Do(func() { close(c.closeChan) })
+	c.recorder.Close()
 }
 
 func (c *BitfinexCrawler) connect() {
@@ -60,31 +89,37 @@ func (c *BitfinexCrawler) connect() {
 			log.Errorf("unable to find mapping for %s", p)
 			continue
 		}
-		msg := &bitfinex.PublicSubscriptionRequest{
-			Pair:    p,
-			Channel: bitfinex.ChanBook,
-			Event:   "subscribe",
-		}
-		handler := func(data interface{}) {
-			c.handleOrder(v, data)
-		}
-		err = c.client.Websocket.Subscribe(ctx, msg, handler)
-		if err != nil {
-			log.Error(err)
-			return
-		}
-		msg = &bitfinex.PublicSubscriptionRequest{
-			Pair:    p,
-			Channel: bitfinex.ChanTrades,
-			Event:   "subscribe",
-		}
-		handler = func(data interface{}) {
-			c.handleTrade(v, data)
+		if c.options.Collects(Books) {
+			msg := &bitfinex.PublicSubscriptionRequest{
+				Pair:    p,
+				Channel: bitfinex.ChanBook,
+				Event:   "subscribe",
+			}
+			handler := func(data interface{}) {
+				c.record(order+":"+v, data)
+				c.handleOrder(v, data)
+			}
+			err = c.client.Websocket.Subscribe(ctx, msg, handler)
+			if err != nil {
+				log.Error(err)
+				return
+			}
 		}
-		err = c.client.Websocket.Subscribe(ctx, msg, handler)
-		if err != nil {
-			log.Fatal(err)
-			return
+		if c.options.Collects(Trades) {
+			msg := &bitfinex.PublicSubscriptionRequest{
+				Pair:    p,
+				Channel: bitfinex.ChanTrades,
+				Event:   "subscribe",
+			}
+			handler := func(data interface{}) {
+				c.record(trade+":"+v, data)
+				c.handleTrade(v, data)
+			}
+			err = c.client.Websocket.Subscribe(ctx, msg, handler)
+			if err != nil {
+				log.Fatal(err)
+				return
+			}
 		}
 	}
 }
@@ -94,6 +129,7 @@ func (c *BitfinexCrawler) Loop() {
 		select {
 		case <-c.client.Websocket.Done():
 			log.Info("client disconnected, reconnecting")
+			reconnects.WithLabelValues(Bitfin).Inc()
 			c.connect()
 		case <-c.closeChan:
 			log.Info("closing down bitfinex client")
@@ -103,6 +139,55 @@ func (c *BitfinexCrawler) Loop() {
 	}
 }
 
+// record keeps the payload as decoded by the bitfinex library, the raw frame
+// never reaches us
+func (c *BitfinexCrawler) record(source string, data interface{}) {
+	if c.recorder == nil {
+		return
+	}
+	if _, ok := data.(bitfinex.Heartbeat); ok {
+		return
+	}
+	bits, err := json.Marshal(data)
+	if err != nil {
+		log.Errorf("unable to record %+v: %s", data, err)
+		return
+	}
+	c.recorder.Record(source, bits)
+}
+
+func newBitfinexReplayer(writers []DataWriter, cfg CrawlerConfig) (Replayer, error) {
+	opts, err := ResolveOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &BitfinexCrawler{pairs: cfg.Pairs, writers: writers, options: opts}, nil
+}
+
+func (c *BitfinexCrawler) Replay(source string, data []byte, received int64) error {
+	c.replayed = received
+	parts := strings.SplitN(source, ":", 2)
+	if len(parts) != 2 {
+		return unknownSource(source)
+	}
+	var payload interface{}
+	var snapshot [][]float64
+	if err := json.Unmarshal(data, &snapshot); err == nil {
+		payload = snapshot
+	} else if err := json.Unmarshal(data, &payload); err != nil {
+		return err
+	}
+	switch parts[0] {
+	case trade:
+		c.handleTrade(parts[1], payload)
+	case order:
+		c.handleOrder(parts[1], payload)
+	default:
+		return unknownSource(source)
+	}
+	return nil
+}
+
 func (c *BitfinexCrawler) handleTrade(pair string, data interface{}) {
 	if _, ok := data.(bitfinex.Heartbeat); ok {
 		return
@@ -121,16 +206,15 @@ func (c *BitfinexCrawler) handleTrade(pair string, data interface{}) {
 			m := TradeMeasurement{
 				Price:           dpiece[3],
 				Amount:          total,
-				Timestamp:       Now() - int64(i),
+				Timestamp:       c.now() - int64(i),
 				Platform:        Bitfin,
 				Pair:            pair,
 				Meta:            trade,
 				TransactionType: typ,
 				TradeType:       limit,
+				TradeID:         strconv.FormatFloat(dpiece[0], 'f', -1, 64),
 			}
-			for _, w := range c.writers {
-				w.Write(m)
-			}
+			emit(c.writers, m, int64(dpiece[1]))
 		}
 	} else {
 		log.Errorf("unable to convert data: %+v: %T", data, data)
@@ -158,14 +242,12 @@ func (c *BitfinexCrawler) handleOrder(pair string, data interface{}) {
 				Meta:      order,
 				Pair:      pair,
 				Platform:  Bitfin,
-				Timestamp: Now() - int64(i),
+				Timestamp: c.now() - int64(i),
 				Amount:    amount,
 				Price:     price,
 				Type:      tip,
 			}
-			for _, w := range c.writers {
-				w.Write(m)
-			}
+			emit(c.writers, m, 0)
 		}
 	} else {
 		log.Errorf("unable to convert data: %+v", data)
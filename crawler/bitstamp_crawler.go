@@ -5,7 +5,7 @@ import (
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"github.com/toorop/go-pusher"
-	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"sync"
@@ -26,107 +26,221 @@ var (
 )
 
 const (
-	bitstampTickerURL    = "https://www.bitstamp.net/api/ticker/"
+	bitStampUrlBase      = "https://www.bitstamp.net/api"
+	bitstampTickerURL    = "/ticker/"
 	bitStampAppId        = "de504dc5763aeef9ff52"
 	bitStampTradeChannel = "live_trades_%s"
 	bitStampOrderChannel = "diff_order_book_%s"
-	bitStampUrlFormat    = "https://www.bitstamp.net/api/v2/transactions/%s/"
+	bitStampUrlFormat    = "%s/v2/transactions/%s/"
 )
 
 type BitStampCrawler struct {
-	pairs      []string
+	clock
+	urlBase    string
+	pairs      *pairList
 	state      sync.Map
 	writers    []DataWriter
-	httpClient http.Client
-	client     pusher.Client
+	httpClient *RestClient
+	client     *pusher.Client
 	tradeChan  chan *pusher.Event
 	orderChan  chan *pusher.Event
 	closeChan  chan bool
-	timeDiff   int64
+	// Close may be called once Loop returned on its own
+	closeOnce sync.Once
+	timeDiff  int64
+	recorder  *Recorder
+	options   Options
 }
 
-func NewBitStamp(writers []DataWriter, pairs []string) (Crawler, error) {
-	cli, err := pusher.NewClient(bitStampAppId)
+func NewBitStamp(writers []DataWriter, cfg CrawlerConfig) (Crawler, error) {
+	opts, err := ResolveOptions(cfg)
 	if err != nil {
 		return nil, err
 	}
-	for _, p := range pairs {
+	cli, err := newBitStampPusher(cfg.Endpoints)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range cfg.Pairs {
 		v, ok := bitStampPairMapping[p]
 		if !ok {
 			return nil, fmt.Errorf("invalid mapping: %s", p)
 		}
-		cli.Subscribe(fmt.Sprintf(bitStampTradeChannel, strings.ToLower(v)))
-		cli.Subscribe(fmt.Sprintf(bitStampOrderChannel, strings.ToLower(v)))
+		if opts.Collects(Trades) {
+			cli.Subscribe(fmt.Sprintf(bitStampTradeChannel, strings.ToLower(v)))
+		}
+		if opts.Collects(Books) {
+			cli.Subscribe(fmt.Sprintf(bitStampOrderChannel, strings.ToLower(v)))
+		}
 	}
-	tc, err := cli.Bind("trade")
-	if err != nil {
-		return nil, err
+	var tc, oc chan *pusher.Event
+	if opts.Collects(Trades) {
+		if tc, err = cli.Bind("trade"); err != nil {
+			return nil, err
+		}
 	}
-	oc, err := cli.Bind("data")
+	if opts.Collects(Books) {
+		if oc, err = cli.Bind("data"); err != nil {
+			return nil, err
+		}
+	}
+	urlBase := cfg.Endpoints.rest(bitStampUrlBase)
+	timeServ, err := getBitStampTime(urlBase)
 	if err != nil {
 		return nil, err
 	}
-	timeServ, err := getBitStampTime()
+	log.Infof("got a time diff of %d, with local time: %+v", timeServ-time.Now().Unix(), time.Now())
+	rec, err := NewRecorder(cfg.Capture, Bitstamp)
 	if err != nil {
 		return nil, err
 	}
-	log.Infof("got a time diff of %d, with local time: %+v", timeServ-time.Now().Unix(), time.Now())
 	return &BitStampCrawler{
-		client:     *cli,
+		client:     cli,
+		urlBase:    urlBase,
 		writers:    writers,
-		pairs:      pairs,
-		httpClient: http.Client{},
+		pairs:      newPairList(cfg.Pairs),
+		httpClient: restClientFor(Bitstamp).WithRecorder(rec),
 		state:      sync.Map{},
 		tradeChan:  tc,
 		orderChan:  oc,
 		timeDiff:   timeServ - time.Now().Unix(),
 		closeChan:  make(chan bool),
+		recorder:   rec,
+		options:    opts,
 	}, nil
 }
 
+func (c *BitStampCrawler) Subscribe(pair string) error {
+	v, ok := bitStampPairMapping[pair]
+	if !ok {
+		return fmt.Errorf("%s does not support pair %s", Bitstamp, pair)
+	}
+	if !c.pairs.add(pair) {
+		return fmt.Errorf("already subscribed to %s on %s", pair, Bitstamp)
+	}
+	for _, ch := range c.channels(v) {
+		if err := c.client.Subscribe(ch); err != nil {
+			c.pairs.remove(pair)
+			return err
+		}
+	}
+	log.Infof("subscribed to %s on %s", pair, Bitstamp)
+	return nil
+}
+
+func (c *BitStampCrawler) Unsubscribe(pair string) error {
+	if !c.pairs.remove(pair) {
+		return fmt.Errorf("not subscribed to %s on %s", pair, Bitstamp)
+	}
+	for _, ch := range c.channels(bitStampPairMapping[pair]) {
+		if err := c.client.Unsubscribe(ch); err != nil {
+			return err
+		}
+	}
+	log.Infof("unsubscribed from %s on %s", pair, Bitstamp)
+	return nil
+}
+
+// channels lists the pusher channels of a pair for the collected channels
+func (c *BitStampCrawler) channels(pair string) []string {
+	var res []string
+	if c.options.Collects(Trades) {
+		res = append(res, fmt.Sprintf(bitStampTradeChannel, strings.ToLower(pair)))
+	}
+	if c.options.Collects(Books) {
+		res = append(res, fmt.Sprintf(bitStampOrderChannel, strings.ToLower(pair)))
+	}
+	return res
+}
+
 func (c *BitStampCrawler) Close() {
-	c.closeChan <- true
+	c.closeOnce.Do(func() {
+		close(c.closeChan)
+		// replayers have no client
+		if c.client != nil {
+			if err := c.client.Close(); err != nil {
+				log.Warnf("error closing bitstamp websocket: %s", err)
+			}
+		}
+	})
+	c.recorder.Close()
 }
 
 func (c *BitStampCrawler) Loop() {
 	for {
 		select {
 		case <-c.closeChan:
-			close(c.tradeChan)
-			close(c.orderChan)
+			// the channels belong to the pusher client, it may still be
+			// sending on them
 			log.Info("closing bitstamp crawler")
 			return
 		case t := <-c.tradeChan:
-			for k, v := range bitStampPairMapping {
-				if strings.Contains(t.Channel, strings.ToLower(k)) {
-					tr := BitstampStreamTrade{}
-					err := json.Unmarshal([]byte(t.Data), &tr)
-					if err != nil {
-						log.Error(err)
-						continue
-					} else {
-						c.handleTrade(v, tr)
-					}
-				}
-			}
+			c.recorder.Record(trade+":"+t.Channel, []byte(t.Data))
+			c.handleTradeEvent(t.Channel, t.Data)
 		case o := <-c.orderChan:
-			for k, v := range bitStampPairMapping {
-				if strings.Contains(o.Channel, strings.ToLower(k)) {
-					or := BitstampStreamOrder{}
-					err := json.Unmarshal([]byte(o.Data), &or)
-					if err != nil {
-						log.Error(err)
-						continue
-					} else {
-						c.handleOrder(v, or)
-					}
-				}
+			c.recorder.Record(order+":"+o.Channel, []byte(o.Data))
+			c.handleOrderEvent(o.Channel, o.Data)
+		}
+	}
+}
+
+func (c *BitStampCrawler) handleTradeEvent(channel, data string) {
+	for k, v := range bitStampPairMapping {
+		if strings.Contains(channel, strings.ToLower(k)) {
+			tr := BitstampStreamTrade{}
+			err := json.Unmarshal([]byte(data), &tr)
+			if err != nil {
+				parseErrors.WithLabelValues(Bitstamp).Inc()
+				log.Error(err)
+				continue
+			} else {
+				c.handleTrade(v, tr)
 			}
+		}
+	}
+}
 
+func (c *BitStampCrawler) handleOrderEvent(channel, data string) {
+	for k, v := range bitStampPairMapping {
+		if strings.Contains(channel, strings.ToLower(k)) {
+			or := BitstampStreamOrder{}
+			err := json.Unmarshal([]byte(data), &or)
+			if err != nil {
+				parseErrors.WithLabelValues(Bitstamp).Inc()
+				log.Error(err)
+				continue
+			} else {
+				c.handleOrder(v, or)
+			}
 		}
 	}
 }
 
+func newBitStampReplayer(writers []DataWriter, cfg CrawlerConfig) (Replayer, error) {
+	opts, err := ResolveOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &BitStampCrawler{pairs: newPairList(cfg.Pairs), writers: writers, options: opts}, nil
+}
+
+func (c *BitStampCrawler) Replay(source string, data []byte, received int64) error {
+	c.replayed = received
+	parts := strings.SplitN(source, ":", 2)
+	if len(parts) != 2 {
+		return unknownSource(source)
+	}
+	switch parts[0] {
+	case trade:
+		c.handleTradeEvent(parts[1], string(data))
+	case order:
+		c.handleOrderEvent(parts[1], string(data))
+	default:
+		return unknownSource(source)
+	}
+	return nil
+}
+
 func (c *BitStampCrawler) handleTrade(pair string, tr BitstampStreamTrade) {
 	trans := buy
 	if tr.Type == 1 {
@@ -134,17 +248,16 @@ func (c *BitStampCrawler) handleTrade(pair string, tr BitstampStreamTrade) {
 	}
 	m := TradeMeasurement{
 		Platform:        Bitstamp,
-		Timestamp:       time.Now().Unix(),
+		Timestamp:       c.now(),
 		Price:           tr.Price,
 		Amount:          tr.Amount,
 		Meta:            trade,
 		Pair:            pair,
 		TradeType:       limit,
 		TransactionType: trans,
+		TradeID:         strconv.FormatInt(tr.Id, 10),
 	}
-	for _, w := range c.writers {
-		w.Write(m)
-	}
+	emit(c.writers, m, tr.Timestamp*1000)
 }
 
 func (c *BitStampCrawler) handleOrder(pair string, or BitstampStreamOrder) {
@@ -153,39 +266,31 @@ func (c *BitStampCrawler) handleOrder(pair string, or BitstampStreamOrder) {
 			Amount:    b.Amount,
 			Price:     b.Price,
 			Pair:      pair,
-			Timestamp: Now() - int64(i),
+			Timestamp: c.now() - int64(i),
 			Meta:      order,
 			Platform:  Bitstamp,
 			Type:      buy,
 		}
-		for _, w := range c.writers {
-			w.Write(m)
-		}
+		emit(c.writers, m, or.Timestamp*1000)
 	}
 	for i, a := range or.Asks {
 		m := OrderMeasurement{
 			Amount:    a.Amount,
 			Price:     a.Price,
 			Pair:      pair,
-			Timestamp: Now() - int64(i),
+			Timestamp: c.now() - int64(i),
 			Meta:      order,
 			Platform:  Bitstamp,
 			Type:      sell,
 		}
-		for _, w := range c.writers {
-			w.Write(m)
-		}
+		emit(c.writers, m, or.Timestamp*1000)
 	}
 }
 
 func (c *BitStampCrawler) Trades(pair string) ([]BitstampTrade, error) {
-	fullUrl := fmt.Sprintf(bitStampUrlFormat, strings.ToLower(pair))
-	resp, err := c.httpClient.Get(fullUrl)
-	if err != nil {
-		return nil, err
-	}
+	fullUrl := fmt.Sprintf(bitStampUrlFormat, c.urlBase, strings.ToLower(pair))
 	trades := []BitstampTrade{}
-	err = ReadJson(resp, &trades)
+	err := c.httpClient.GetJson(fullUrl, &trades)
 	if err != nil {
 		return nil, err
 	}
@@ -245,13 +350,24 @@ func (o *BitstampOrderData) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
-func getBitStampTime() (int64, error) {
-	r, err := http.Get(bitstampTickerURL)
+func newBitStampPusher(endpoints Endpoints) (*pusher.Client, error) {
+	appKey := bitStampAppId
+	if endpoints.AppKey != "" {
+		appKey = endpoints.AppKey
+	}
+	if endpoints.Websocket == "" {
+		return pusher.NewClient(appKey)
+	}
+	u, err := url.Parse(endpoints.Websocket)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
+	return pusher.NewCustomClient(appKey, u.Host, u.Scheme)
+}
+
+func getBitStampTime(urlBase string) (int64, error) {
 	var resp BitstampTickerResponse
-	err = ReadJson(r, &resp)
+	err := restClientFor(Bitstamp).GetJson(urlBase+bitstampTickerURL, &resp)
 	if err != nil {
 		return 0, err
 	}
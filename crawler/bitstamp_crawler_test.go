@@ -0,0 +1,55 @@
+package crawler
+
+import (
+	"cryptoCrawl/crawler/mockexchange"
+	"testing"
+	"time"
+)
+
+func TestBitStampCrawler(t *testing.T) {
+	s := mockexchange.Bitstamp(BTCUSD)
+	defer s.Close()
+	w := make(chanWriter, 10)
+	cfg := CrawlerConfig{
+		Name:      Bitstamp,
+		Pairs:     []string{BTCUSD},
+		Endpoints: Endpoints{Rest: s.URL + "/api", Websocket: s.WebsocketURL(), AppKey: "test"},
+	}
+	cr, err := NewBitStamp([]DataWriter{w}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cr.(*BitStampCrawler)
+	history, err := c.Trades(BTCUSD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].Price != 15000 {
+		t.Fatalf("unexpected transactions %+v", history)
+	}
+	done := make(chan struct{})
+	go func() {
+		c.Loop()
+		close(done)
+	}()
+	trades, orders := expectMeasurements(t, w, 3)
+	if len(trades) != 1 || len(orders) != 2 {
+		t.Fatalf("expected 1 trade and 2 orders, got %+v %+v", trades, orders)
+	}
+	tr := trades[0]
+	if tr.Pair != BTCUSD || tr.Platform != Bitstamp || tr.Price != 15000.5 || tr.Amount != 0.5 || tr.TransactionType != sell {
+		t.Fatalf("unexpected trade %+v", tr)
+	}
+	checkOrders(t, orders, Bitstamp, BTCUSD)
+
+	c.Close()
+	<-done
+	c.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Connections() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := s.Connections(); n != 0 {
+		t.Errorf("expected the pusher websocket to be closed, %d still open", n)
+	}
+}
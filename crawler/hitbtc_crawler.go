@@ -3,9 +3,9 @@ package crawler
 import (
 	"fmt"
 	log "github.com/sirupsen/logrus"
-	"net/http"
 	"net/url"
 	"path"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -23,29 +23,60 @@ var (
 )
 
 const (
-	hitBTCUrlBase = "https://api.hitbtc.com/api/2/"
+	hitBTCUrlBase = "https://api.hitbtc.com/api/2"
 )
 
 type HitBTCCrawler struct {
-	pairs     []string
-	client    http.Client
+	clock
+	urlBase   string
+	pairs     *pairList
+	client    *RestClient
 	state     sync.Map
 	writers   []DataWriter
 	closeChan chan bool
+	// Close may be called once Loop returned on its own
+	closeOnce sync.Once
+	recorder  *Recorder
+	options   Options
 }
 
-func NewHitBTC(writers []DataWriter, pairs []string) (Crawler, error) {
-	cli := http.Client{Timeout: time.Second * 10}
+func NewHitBTC(writers []DataWriter, cfg CrawlerConfig) (Crawler, error) {
+	opts, err := ResolveOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	rec, err := NewRecorder(cfg.Capture, HitBTC)
+	if err != nil {
+		return nil, err
+	}
 	return &HitBTCCrawler{
-		pairs:     pairs,
-		client:    cli,
+		recorder:  rec,
+		urlBase:   cfg.Endpoints.rest(hitBTCUrlBase),
+		pairs:     newPairList(cfg.Pairs),
+		client:    restClientFor(HitBTC),
 		state:     sync.Map{},
 		writers:   writers,
+		options:   opts,
 		closeChan: make(chan bool)}, nil
 }
 
-//unusable due to order of results
+// the order of results makes the timestamps approximate, only collected when
+// the books channel is enabled
 func (c *HitBTCCrawler) Orders(pair string) (*HitBTCOrderResponse, error) {
+	orderUrl := fmt.Sprintf("%s/public/orderbook/%s", c.urlBase, strings.ToLower(pair))
+	if c.options.BookDepth > 0 {
+		orderUrl = fmt.Sprintf("%s?limit=%d", orderUrl, c.options.BookDepth)
+	}
+	log.Debugf("calling %s for orders", orderUrl)
+	bits, err := c.client.GetBytes(orderUrl)
+	if err != nil {
+		return nil, err
+	}
+	c.recorder.Record(order+":"+pair, bits)
+	return c.decodeOrders(pair, bits)
+}
+
+func (c *HitBTCCrawler) decodeOrders(pair string, bits []byte) (*HitBTCOrderResponse, error) {
 	var lastAskOrder, lastBidOrder HitBTCOrder
 	var orders HitBTCOrderResponse
 	if la, ok := c.state.Load(lastAskTime + pair); ok {
@@ -58,14 +89,7 @@ func (c *HitBTCCrawler) Orders(pair string) (*HitBTCOrderResponse, error) {
 			lastBidOrder = lbo
 		}
 	}
-
-	orderUrl := fmt.Sprintf("%spublic/orderbook/%s", hitBTCUrlBase, strings.ToLower(pair))
-	log.Debugf("calling % for orders", orderUrl)
-	resp, err := http.Get(orderUrl)
-	if err != nil {
-		return nil, err
-	}
-	err = ReadJson(resp, &orders)
+	err := c.client.Decode(bits, &orders)
 	if err != nil {
 		return nil, err
 	}
@@ -99,15 +123,18 @@ func (c *HitBTCCrawler) Orders(pair string) (*HitBTCOrderResponse, error) {
 	return &orders, nil
 }
 
-func (c *HitBTCCrawler) Trades(pair string) ([]HitBTCTradeResponse, error) {
-	lastId := -1
+func (c *HitBTCCrawler) lastTradeId(pair string) int {
 	lid, ok := c.state.Load(lastTrade + pair)
-	if ok {
-		lastId = lid.(int)
-	} else {
+	if !ok {
 		log.Warn("could not find last trade id for pair ", pair)
+		return -1
 	}
-	u, err := url.Parse(hitBTCUrlBase)
+	return lid.(int)
+}
+
+func (c *HitBTCCrawler) Trades(pair string) ([]HitBTCTradeResponse, error) {
+	lastId := c.lastTradeId(pair)
+	u, err := url.Parse(c.urlBase)
 	if err != nil {
 		return nil, err
 	}
@@ -119,12 +146,17 @@ func (c *HitBTCCrawler) Trades(pair string) ([]HitBTCTradeResponse, error) {
 	u.RawQuery = values.Encode()
 	tradeUrl := u.String()
 	log.Debugf("calling %s for trades", tradeUrl)
-	resp, err := c.client.Get(tradeUrl)
+	bits, err := c.client.GetBytes(tradeUrl)
 	if err != nil {
 		return nil, err
 	}
+	c.recorder.Record(trade+":"+pair, bits)
+	return c.decodeTrades(pair, lastId, bits)
+}
+
+func (c *HitBTCCrawler) decodeTrades(pair string, lastId int, bits []byte) ([]HitBTCTradeResponse, error) {
 	var decoded []HitBTCTradeResponse
-	err = ReadJson(resp, &decoded)
+	err := c.client.Decode(bits, &decoded)
 	if err != nil {
 		return nil, err
 	}
@@ -140,16 +172,22 @@ func (c *HitBTCCrawler) Trades(pair string) ([]HitBTCTradeResponse, error) {
 }
 
 func (c *HitBTCCrawler) Close() {
-	c.closeChan <- true
+	c.closeOnce.Do(func() { close(c.closeChan) })
+	c.recorder.Close()
 }
 
 func (c *HitBTCCrawler) Loop() {
-	ticker := time.Tick(time.Second * 2)
+	ticker := time.Tick(c.options.PollInterval.Duration)
 	for {
 		select {
 		case <-ticker:
-			for _, p := range c.pairs {
-				go c.handleTrade(p)
+			for _, p := range c.pairs.list() {
+				if c.options.Collects(Trades) {
+					go c.handleTrade(p)
+				}
+				if c.options.Collects(Books) {
+					go c.handleOrder(p)
+				}
 			}
 		case <-c.closeChan:
 			log.Info("closing down hitbtc crawler")
@@ -165,34 +203,34 @@ func (c *HitBTCCrawler) handleOrder(pair string) {
 			log.Errorf("error retrieving hitbtc orders: %s", err)
 			return
 		}
-		for i, a := range orders.Asks {
-			m := OrderMeasurement{
-				Platform:  HitBTC,
-				Meta:      order,
-				Type:      buy,
-				Pair:      v,
-				Price:     a.Price,
-				Amount:    a.Amount,
-				Timestamp: Now() - int64(i),
-			}
-			for _, w := range c.writers {
-				w.Write(m)
-			}
+		c.writeOrders(v, orders)
+	}
+}
+
+func (c *HitBTCCrawler) writeOrders(pair string, orders *HitBTCOrderResponse) {
+	for i, a := range orders.Asks {
+		m := OrderMeasurement{
+			Platform:  HitBTC,
+			Meta:      order,
+			Type:      buy,
+			Pair:      pair,
+			Price:     a.Price,
+			Amount:    a.Amount,
+			Timestamp: c.now() - int64(i),
 		}
-		for i, b := range orders.Bids {
-			m := OrderMeasurement{
-				Platform:  HitBTC,
-				Meta:      order,
-				Type:      sell,
-				Pair:      v,
-				Price:     b.Price,
-				Amount:    b.Amount,
-				Timestamp: Now() - int64(i),
-			}
-			for _, w := range c.writers {
-				w.Write(m)
-			}
+		emit(c.writers, m, 0)
+	}
+	for i, b := range orders.Bids {
+		m := OrderMeasurement{
+			Platform:  HitBTC,
+			Meta:      order,
+			Type:      sell,
+			Pair:      pair,
+			Price:     b.Price,
+			Amount:    b.Amount,
+			Timestamp: c.now() - int64(i),
 		}
+		emit(c.writers, m, 0)
 	}
 }
 
@@ -203,27 +241,66 @@ func (c *HitBTCCrawler) handleTrade(pair string) {
 			log.Errorf("error retrieving trades: %s", err)
 			return
 		}
-		for _, response := range trades {
-			m := TradeMeasurement{
-				Pair:            v,
-				Meta:            trade,
-				Price:           response.Price,
-				Amount:          response.Amount,
-				Timestamp:       response.TimeStamp.Unix(),
-				Platform:        HitBTC,
-				TransactionType: response.Type,
-				TradeType:       limit,
-			}
-			for _, w := range c.writers {
-				w.Write(m)
-			}
-		}
-
+		c.writeTrades(v, trades)
 	} else {
 		log.Error("unable to find mapping for ", pair)
 	}
 }
 
+func (c *HitBTCCrawler) writeTrades(pair string, trades []HitBTCTradeResponse) {
+	for _, response := range trades {
+		m := TradeMeasurement{
+			Pair:            pair,
+			Meta:            trade,
+			Price:           response.Price,
+			Amount:          response.Amount,
+			Timestamp:       response.TimeStamp.UnixNano() / int64(time.Millisecond),
+			Platform:        HitBTC,
+			TransactionType: response.Type,
+			TradeType:       limit,
+			TradeID:         strconv.Itoa(response.Id),
+		}
+		emit(c.writers, m, m.Timestamp)
+	}
+}
+
+func newHitBTCReplayer(writers []DataWriter, cfg CrawlerConfig) (Replayer, error) {
+	opts, err := ResolveOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &HitBTCCrawler{pairs: newPairList(cfg.Pairs), writers: writers, options: opts, client: restClientFor(HitBTC)}, nil
+}
+
+func (c *HitBTCCrawler) Replay(source string, data []byte, received int64) error {
+	c.replayed = received
+	parts := strings.SplitN(source, ":", 2)
+	if len(parts) != 2 {
+		return unknownSource(source)
+	}
+	v, ok := hitBTCPairMapping[parts[1]]
+	if !ok {
+		return fmt.Errorf("unable to find mapping for %s", parts[1])
+	}
+	switch parts[0] {
+	case trade:
+		trades, err := c.decodeTrades(parts[1], c.lastTradeId(parts[1]), data)
+		if err != nil {
+			return err
+		}
+		c.writeTrades(v, trades)
+	case order:
+		orders, err := c.decodeOrders(parts[1], data)
+		if err != nil {
+			return err
+		}
+		c.writeOrders(v, orders)
+	default:
+		return unknownSource(source)
+	}
+	return nil
+}
+
 type HitBTCOrder struct {
 	Price  float64 `json:"price,string"`
 	Amount float64 `json:"size,string"`
@@ -241,3 +318,11 @@ type HitBTCTradeResponse struct {
 	Type      string    `json:"side"`
 	TimeStamp time.Time `json:"timestamp"`
 }
+
+func (c *HitBTCCrawler) Subscribe(pair string) error {
+	return subscribePair(c.pairs, hitBTCPairMapping, HitBTC, pair)
+}
+
+func (c *HitBTCCrawler) Unsubscribe(pair string) error {
+	return unsubscribePair(c.pairs, HitBTC, pair)
+}
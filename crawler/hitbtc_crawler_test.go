@@ -0,0 +1,33 @@
+package crawler
+
+import (
+	"cryptoCrawl/crawler/mockexchange"
+	"testing"
+)
+
+func TestHitBTCCrawler(t *testing.T) {
+	s := mockexchange.HitBTC(BTCUSD)
+	defer s.Close()
+	w := make(chanWriter, 10)
+	cfg := CrawlerConfig{
+		Name:      HitBTC,
+		Pairs:     []string{BTCUSD},
+		Endpoints: Endpoints{Rest: s.URL + "/api/2"},
+	}
+	cr, err := NewHitBTC([]DataWriter{w}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cr.(*HitBTCCrawler)
+	c.handleTrade(BTCUSD)
+	c.handleOrder(BTCUSD)
+	trades, orders := expectMeasurements(t, w, 3)
+	if len(trades) != 1 || len(orders) != 2 {
+		t.Fatalf("expected 1 trade and 2 orders, got %+v %+v", trades, orders)
+	}
+	tr := trades[0]
+	if tr.Pair != BTCUSD || tr.Platform != HitBTC || tr.Price != 15000.5 || tr.Amount != 0.25 || tr.TransactionType != sell {
+		t.Fatalf("unexpected trade %+v", tr)
+	}
+	checkOrders(t, orders, HitBTC, BTCUSD)
+}
@@ -1,8 +1,12 @@
 package crawler
 
 import (
+	"encoding/json"
+	"fmt"
 	"github.com/beldur/kraken-go-api-client"
 	log "github.com/sirupsen/logrus"
+	"net/http"
+	"path"
 	"sync"
 	"time"
 )
@@ -28,43 +32,77 @@ var (
 )
 
 type KrakenCrawler struct {
-	pairs     []string
+	pairs     *pairList
 	state     sync.Map
 	client    krakenapi.KrakenApi
 	writers   []DataWriter
 	timeDiff  int64
 	closeChan chan bool
+	// Close may be called once Loop returned on its own
+	closeOnce sync.Once
+	recorder  *Recorder
+	options   Options
 }
 
-func NewKraken(writers []DataWriter, pairs []string) (Crawler, error) {
-	log.Debugf("creating new kraken crawler for pairs %+v and writers %+v", pairs, writers)
-	cli := krakenapi.New("", "")
+func NewKraken(writers []DataWriter, cfg CrawlerConfig) (Crawler, error) {
+	log.Debugf("creating new kraken crawler for pairs %+v and writers %+v", cfg.Pairs, writers)
+	opts, err := ResolveOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	rest, err := restClientFor(Kraken).WithBaseURL(cfg.Endpoints.Rest)
+	if err != nil {
+		return nil, err
+	}
+	rec, err := NewRecorder(cfg.Capture, Kraken)
+	if err != nil {
+		return nil, err
+	}
+	cli := krakenapi.NewWithClient("", "", rest.WithRecorder(rec).HTTPClient())
 	cl := KrakenCrawler{
-		pairs:     pairs,
+		recorder:  rec,
+		pairs:     newPairList(cfg.Pairs),
 		client:    *cli,
 		writers:   writers,
 		state:     sync.Map{},
 		closeChan: make(chan bool),
+		options:   opts,
 	}
 	there, err := cl.client.Time()
 	if err != nil {
-		panic(err)
+		rec.Close()
+		return nil, fmt.Errorf("error getting kraken server time: %s", err)
 	}
 	cl.timeDiff = there.Unixtime - time.Now().Unix()
 	return &cl, nil
 }
 
 func (c *KrakenCrawler) Close() {
-	c.closeChan <- true
+	c.closeOnce.Do(func() { close(c.closeChan) })
+	c.recorder.Close()
 }
 
 func (c *KrakenCrawler) Loop() {
 	for {
-		for _, p := range c.pairs {
+		pairs := c.pairs.list()
+		if len(pairs) == 0 {
 			select {
-			case <-time.After(500 * time.Millisecond):
-				go c.ReadTrades(p)
-				go c.ReadDepth(p)
+			case <-time.After(c.options.PollInterval.Duration):
+				continue
+			case <-c.closeChan:
+				log.Info("closing down kraken crawler")
+				return
+			}
+		}
+		for _, p := range pairs {
+			select {
+			case <-time.After(c.options.PollInterval.Duration):
+				if c.options.Collects(Trades) {
+					go c.ReadTrades(p)
+				}
+				if c.options.Collects(Books) {
+					go c.ReadDepth(p)
+				}
 			case <-c.closeChan:
 				log.Info("closing down kraken crawler")
 				return
@@ -93,24 +131,27 @@ func (c *KrakenCrawler) ReadTrades(symbol string) {
 		return
 	}
 	for _, t := range trades.Trades {
+		// kraken gives no trade id, the exchange time keeps the content hash
+		// the trade is keyed by the same across runs
 		m := TradeMeasurement{
 			Meta:      trade,
 			Platform:  Kraken,
 			Pair:      pairName,
 			Amount:    t.VolumeFloat,
 			Price:     t.PriceFloat,
-			Timestamp: t.Time - c.timeDiff,
+			Timestamp: t.Time * 1000,
 		}
 		if t.Buy {
-			m.TradeType = buy
+			m.TransactionType = buy
 		} else {
-			m.TradeType = sell
+			m.TransactionType = sell
 		}
 		if t.Market {
-			m.TransactionType = market
+			m.TradeType = market
 		} else {
-			m.TransactionType = limit
+			m.TradeType = limit
 		}
+		emit(c.writers, m, t.Time*1000)
 	}
 	c.state.Store(lastTrade+symbol, trades.Last)
 }
@@ -122,7 +163,7 @@ func (c *KrakenCrawler) ReadDepth(symbol string) {
 		log.Errorf("unable to find mapping for symbol %s", symbol)
 		return
 	}
-	book, err := c.client.Depth(symbol, 0)
+	book, err := c.client.Depth(symbol, c.options.BookDepth)
 	if err != nil {
 		log.Warnf("unable to get order data: %s", err)
 		return
@@ -146,16 +187,14 @@ func (c *KrakenCrawler) ReadDepth(symbol string) {
 		if a.Ts > askTime {
 			m := OrderMeasurement{
 				Meta:      order,
-				Timestamp: a.Ts - c.timeDiff,
+				Timestamp: (a.Ts - c.timeDiff) * 1000,
 				Amount:    a.Amount,
 				Price:     a.Price,
 				Pair:      pairName,
 				Platform:  Kraken,
 				Type:      sell,
 			}
-			for _, w := range c.writers {
-				w.Write(m)
-			}
+			emit(c.writers, m, a.Ts*1000)
 			if a.Ts > lastAsk {
 				lastAsk = a.Ts
 			}
@@ -167,16 +206,14 @@ func (c *KrakenCrawler) ReadDepth(symbol string) {
 			if b.Ts > askTime {
 				m := OrderMeasurement{
 					Meta:      order,
-					Timestamp: b.Ts - c.timeDiff,
+					Timestamp: (b.Ts - c.timeDiff) * 1000,
 					Amount:    b.Amount,
 					Price:     b.Price,
 					Pair:      pairName,
 					Platform:  Kraken,
 					Type:      buy,
 				}
-				for _, w := range c.writers {
-					w.Write(m)
-				}
+				emit(c.writers, m, b.Ts*1000)
 				if b.Ts > lastBid {
 					lastBid = b.Ts
 				}
@@ -185,3 +222,73 @@ func (c *KrakenCrawler) ReadDepth(symbol string) {
 	}
 	c.state.Store(lastBidTime+symbol, lastBid)
 }
+
+// krakenReplayer hands the recorded responses to the kraken client, which
+// parses them for ReadTrades and ReadDepth as if they came from the exchange
+type krakenReplayer struct {
+	crawler   *KrakenCrawler
+	transport *replayTransport
+}
+
+func newKrakenReplayer(writers []DataWriter, cfg CrawlerConfig) (Replayer, error) {
+	opts, err := ResolveOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	t := &replayTransport{}
+	return &krakenReplayer{
+		crawler: &KrakenCrawler{
+			pairs:   newPairList(cfg.Pairs),
+			client:  *krakenapi.NewWithClient("", "", &http.Client{Transport: t}),
+			writers: writers,
+			options: opts,
+		},
+		transport: t,
+	}, nil
+}
+
+func (r *krakenReplayer) Replay(source string, data []byte, received int64) error {
+	u, err := restSource(source)
+	if err != nil {
+		return err
+	}
+	method := path.Base(u.Path)
+	if method == "Time" {
+		return nil
+	}
+	// the pair is in the request body, which is not recorded, the response
+	// is keyed by it
+	var res struct {
+		Result map[string]json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(data, &res); err != nil {
+		return err
+	}
+	var symbol string
+	for s := range res.Result {
+		if _, ok := krakenPairMapping[s]; ok {
+			symbol = s
+		}
+	}
+	if symbol == "" {
+		return fmt.Errorf("no known pair in kraken response to %s", u.Path)
+	}
+	r.transport.body = data
+	switch method {
+	case "Trades":
+		r.crawler.ReadTrades(symbol)
+	case "Depth":
+		r.crawler.ReadDepth(symbol)
+	default:
+		return unknownSource(source)
+	}
+	return nil
+}
+
+func (c *KrakenCrawler) Subscribe(pair string) error {
+	return subscribePair(c.pairs, krakenPairMapping, Kraken, pair)
+}
+
+func (c *KrakenCrawler) Unsubscribe(pair string) error {
+	return unsubscribePair(c.pairs, Kraken, pair)
+}
@@ -0,0 +1,93 @@
+package crawler
+
+import (
+	"cryptoCrawl/crawler/mockexchange"
+	"github.com/beldur/kraken-go-api-client"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKrakenCrawler(t *testing.T) {
+	s := mockexchange.Kraken(krakenapi.XXBTZUSD)
+	defer s.Close()
+	w := make(chanWriter, 10)
+	cfg := CrawlerConfig{
+		Name:      Kraken,
+		Pairs:     []string{krakenapi.XXBTZUSD},
+		Endpoints: Endpoints{Rest: s.URL},
+	}
+	cr, err := NewKraken([]DataWriter{w}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cr.(*KrakenCrawler)
+	c.ReadDepth(krakenapi.XXBTZUSD)
+	_, orders := expectMeasurements(t, w, 2)
+	checkOrders(t, orders, Kraken, BTCUSD)
+	for _, o := range orders {
+		if d := Now() - o.Timestamp; d < 0 || d > 60*1000 {
+			t.Fatalf("order timestamp %d should be in milliseconds of now", o.Timestamp)
+		}
+	}
+	c.ReadTrades(krakenapi.XXBTZUSD)
+	trades, _ := expectMeasurements(t, w, 1)
+	tr := trades[0]
+	if tr.Pair != BTCUSD || tr.Platform != Kraken || tr.Price != 15000.5 || tr.Amount != 0.25 ||
+		tr.TransactionType != buy || tr.TradeType != limit {
+		t.Fatalf("unexpected trade %+v", tr)
+	}
+	if s.Hits("/0/public/Time") != 1 || s.Hits("/0/public/Depth") != 1 || s.Hits("/0/public/Trades") != 1 {
+		t.Fatal("expected the kraken calls to go through the mock server")
+	}
+}
+
+func TestKrakenTimeError(t *testing.T) {
+	s := mockexchange.New()
+	defer s.Close()
+	s.HandleJSON("/0/public/Time", `{"error":["EService:Unavailable"],"result":{}}`)
+	_, err := NewKraken(nil, CrawlerConfig{Name: Kraken, Endpoints: Endpoints{Rest: s.URL}})
+	if err == nil {
+		t.Fatal("expected an error when the server time is unavailable")
+	}
+}
+
+func TestCloseTwiceWithCapture(t *testing.T) {
+	s := mockexchange.Kraken(krakenapi.XXBTZUSD)
+	defer s.Close()
+	cfg := CrawlerConfig{
+		Name:      Kraken,
+		Pairs:     []string{krakenapi.XXBTZUSD},
+		Endpoints: Endpoints{Rest: s.URL},
+		Capture:   filepath.Join(t.TempDir(), "kraken.jsonl.gz"),
+	}
+	c, err := NewKraken(nil, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	// used to panic closing the recorder again
+	c.Close()
+}
+
+func TestCloseAfterLoop(t *testing.T) {
+	c := &KrakenCrawler{pairs: newPairList(nil), closeChan: make(chan bool)}
+	done := make(chan struct{})
+	go func() {
+		c.Loop()
+		close(done)
+	}()
+	c.Close()
+	<-done
+	closed := make(chan struct{})
+	go func() {
+		// the loop is gone, this used to block forever
+		c.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked once the loop returned")
+	}
+}
@@ -0,0 +1,143 @@
+package crawler
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+	"time"
+)
+
+var (
+	messagesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cryptocrawl",
+		Subsystem: "crawler",
+		Name:      "messages_total",
+		Help:      "Measurements produced, by platform, pair and meta.",
+	}, []string{"platform", "pair", "meta"})
+	parseErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cryptocrawl",
+		Subsystem: "crawler",
+		Name:      "parse_errors_total",
+		Help:      "Exchange messages that could not be decoded.",
+	}, []string{"platform"})
+	reconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cryptocrawl",
+		Subsystem: "crawler",
+		Name:      "reconnects_total",
+		Help:      "Connections to an exchange opened again after being lost.",
+	}, []string{"platform"})
+	latency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "cryptocrawl",
+		Subsystem: "crawler",
+		Name:      "latency_seconds",
+		Help:      "Time between the exchange timestamp of a measurement and its production.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 14),
+	}, []string{"platform"})
+	lastMessages = newLastMessages()
+	restRequests = restDesc("requests", "REST calls made to an exchange, retries included.")
+	restRetries  = restDesc("retries", "REST calls retried after a failed attempt.")
+	restLimited  = restDesc("rate_limited", "REST calls the exchange answered with a rate limit.")
+	restAuth     = restDesc("auth_errors", "REST calls the exchange rejected as unauthorized.")
+	restParse    = restDesc("parse_errors", "REST responses that could not be decoded.")
+	restServer   = restDesc("server_errors", "REST calls the exchange answered with a server error.")
+	restFailures = restDesc("failures", "REST calls given up after the last retry.")
+)
+
+func init() {
+	prometheus.MustRegister(messagesTotal, parseErrors, reconnects, latency, lastMessages, restCollector{})
+}
+
+func restDesc(name, help string) *prometheus.Desc {
+	return prometheus.NewDesc("cryptocrawl_crawler_rest_"+name+"_total", help, []string{"platform"}, nil)
+}
+
+// restCollector reports the REST counters of every exchange client
+type restCollector struct{}
+
+func (c restCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{restRequests, restRetries, restLimited, restAuth, restParse, restServer, restFailures} {
+		ch <- d
+	}
+}
+
+func (c restCollector) Collect(ch chan<- prometheus.Metric) {
+	for platform, m := range RestStats() {
+		for d, v := range map[*prometheus.Desc]int64{
+			restRequests: m.Requests,
+			restRetries:  m.Retries,
+			restLimited:  m.RateLimited,
+			restAuth:     m.AuthErrors,
+			restParse:    m.ParseErrors,
+			restServer:   m.ServerErrors,
+			restFailures: m.Failures,
+		} {
+			ch <- prometheus.MustNewConstMetric(d, prometheus.CounterValue, float64(v), platform)
+		}
+	}
+}
+
+// lastMessageCollector reports how long ago every pair produced something,
+// the age is computed when scraped
+type lastMessageCollector struct {
+	locker sync.Mutex
+	last   map[[2]string]time.Time
+	desc   *prometheus.Desc
+}
+
+func newLastMessages() *lastMessageCollector {
+	return &lastMessageCollector{
+		last: map[[2]string]time.Time{},
+		desc: prometheus.NewDesc("cryptocrawl_crawler_last_message_age_seconds",
+			"Seconds since the last measurement of a pair.", []string{"platform", "pair"}, nil),
+	}
+}
+
+func (l *lastMessageCollector) seen(platform, pair string, t time.Time) {
+	l.locker.Lock()
+	l.last[[2]string{platform, pair}] = t
+	l.locker.Unlock()
+}
+
+func (l *lastMessageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- l.desc
+}
+
+func (l *lastMessageCollector) Collect(ch chan<- prometheus.Metric) {
+	l.locker.Lock()
+	defer l.locker.Unlock()
+	now := time.Now()
+	for k, t := range l.last {
+		ch <- prometheus.MustNewConstMetric(l.desc, prometheus.GaugeValue, now.Sub(t).Seconds(), k[0], k[1])
+	}
+}
+
+// emit hands a measurement to the writers, counting it on the way. event is
+// the exchange time of the measurement in epoch millis, 0 when the exchange
+// gives none
+func emit(writers []DataWriter, m interface{}, event int64) {
+	observe(m, event)
+	for _, w := range writers {
+		w.Write(m)
+	}
+}
+
+func observe(m interface{}, event int64) {
+	var platform, pair, meta string
+	switch v := m.(type) {
+	case TradeMeasurement:
+		platform, pair, meta = v.Platform, v.Pair, v.Meta
+	case OrderMeasurement:
+		platform, pair, meta = v.Platform, v.Pair, v.Meta
+	case CancelMeasurement:
+		platform, pair, meta = v.Platform, v.Pair, v.Meta
+	default:
+		return
+	}
+	now := time.Now()
+	messagesTotal.WithLabelValues(platform, pair, meta).Inc()
+	lastMessages.seen(platform, pair, now)
+	// measurements stamped locally would read 0, clocks may be off a bit the
+	// other way
+	if d := now.Sub(FromMillis(event)); event > 0 && d >= 0 {
+		latency.WithLabelValues(platform).Observe(d.Seconds())
+	}
+}
@@ -0,0 +1,67 @@
+package crawler
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEmitObserves(t *testing.T) {
+	w := make(chanWriter, 2)
+	before := testutil.ToFloat64(messagesTotal.WithLabelValues("metrics", BTCUSD, trade))
+	observed := latencyCount(t)
+	emit([]DataWriter{w}, TradeMeasurement{Meta: trade, Platform: "metrics", Pair: BTCUSD, Timestamp: Now()}, Now()-1500)
+	emit([]DataWriter{w}, OrderMeasurement{Meta: order, Platform: "metrics", Pair: BTCUSD, Timestamp: Now()}, 0)
+	expectMeasurements(t, w, 2)
+	if n := testutil.ToFloat64(messagesTotal.WithLabelValues("metrics", BTCUSD, trade)) - before; n != 1 {
+		t.Errorf("expected 1 trade counted, got %v", n)
+	}
+	if n := latencyCount(t) - observed; n != 1 {
+		t.Errorf("latency should be observed for the trade only, got %d", n)
+	}
+	lastMessages.locker.Lock()
+	seen := lastMessages.last[[2]string{"metrics", BTCUSD}]
+	lastMessages.locker.Unlock()
+	if time.Since(seen) > time.Minute {
+		t.Errorf("last message time not updated: %s", seen)
+	}
+}
+
+func latencyCount(t *testing.T) uint64 {
+	var m dto.Metric
+	if err := latency.WithLabelValues("metrics").(prometheus.Histogram).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestRestMetrics(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+	SetRateLimit("metrics", testRateLimit())
+	if err := restClientFor("metrics").GetJson(srv.URL, &TimeResponse{}); err == nil {
+		t.Fatal("expected an auth error")
+	}
+	ch := make(chan prometheus.Metric, 100)
+	restCollector{}.Collect(ch)
+	close(ch)
+	counts := map[string]float64{}
+	for m := range ch {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		if pb.GetLabel()[0].GetValue() == "metrics" {
+			counts[m.Desc().String()] = pb.GetCounter().GetValue()
+		}
+	}
+	if counts[restRequests.String()] != 1 || counts[restAuth.String()] != 1 || counts[restRetries.String()] != 0 {
+		t.Errorf("unexpected rest counters: %v", counts)
+	}
+}
@@ -0,0 +1,43 @@
+package crawler
+
+import (
+	"testing"
+	"time"
+)
+
+type chanWriter chan interface{}
+
+func (w chanWriter) Write(d interface{}) {
+	w <- d
+}
+
+func expectMeasurements(t *testing.T, w chanWriter, count int) (trades []TradeMeasurement, orders []OrderMeasurement) {
+	timeout := time.After(5 * time.Second)
+	for len(trades)+len(orders) < count {
+		select {
+		case m := <-w:
+			switch v := m.(type) {
+			case TradeMeasurement:
+				trades = append(trades, v)
+			case OrderMeasurement:
+				orders = append(orders, v)
+			default:
+				t.Fatalf("unexpected measurement %T", m)
+			}
+		case <-timeout:
+			t.Fatalf("expected %d measurements, got trades %+v and orders %+v", count, trades, orders)
+		}
+	}
+	return
+}
+
+func checkOrders(t *testing.T, orders []OrderMeasurement, platform, pair string) {
+	for _, o := range orders {
+		if o.Platform != platform || o.Pair != pair || o.Meta != order {
+			t.Fatalf("unexpected order %+v", o)
+		}
+		if !(o.Price == 14999 && o.Amount == 1.5) && !(o.Price == 15001 && o.Amount == 2) {
+			t.Fatalf("unexpected order values %+v", o)
+		}
+	}
+}
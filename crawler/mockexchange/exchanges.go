@@ -0,0 +1,66 @@
+package mockexchange
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Binance serves the server time and one trade and one depth update for
+// every pair, pairs are given in binance notation e.g. BTCUSDT
+func Binance(pairs ...string) *Server {
+	s := New()
+	s.HandleJSON("/api/v1/time", fmt.Sprintf(`{"serverTime": %d}`, time.Now().Unix()*1000))
+	for _, p := range pairs {
+		s.Stream(fmt.Sprintf("/ws/%s@aggTrade", strings.ToLower(p)),
+			fmt.Sprintf(`{"e":"aggTrade","E":1515000000000,"s":"%s","a":1,"p":"15000.50","q":"0.25","f":10,"l":11,"T":1515000000000,"m":true}`, p))
+		s.Stream(fmt.Sprintf("/ws/%s@depth", strings.ToLower(p)),
+			fmt.Sprintf(`{"e":"depthUpdate","E":1515000000000,"s":"%s","u":7,"b":[["14999.00","1.5",[]]],"a":[["15001.00","2.0",[]]]}`, p))
+	}
+	return s
+}
+
+// Bitstamp serves the ticker and transactions endpoints and pushes one trade
+// and one order book diff for every pair, e.g. BTCUSD
+func Bitstamp(pairs ...string) *Server {
+	s := New()
+	s.HandleJSON("/api/ticker/", fmt.Sprintf(`{"timestamp": "%d"}`, time.Now().Unix()))
+	for _, p := range pairs {
+		s.HandleJSON(fmt.Sprintf("/api/v2/transactions/%s/", strings.ToLower(p)),
+			`[{"date":"1515000000","tid":"1","price":"15000.00","amount":"0.10","type":"0"}]`)
+		s.Pusher("live_trades_"+strings.ToLower(p), "trade",
+			`{"amount":0.5,"buy_order_id":1,"sell_order_id":2,"price":15000.5,"timestamp":"1515000000","id":3,"type":1}`)
+		s.Pusher("diff_order_book_"+strings.ToLower(p), "data",
+			`{"timestamp":"1515000000","bids":[["14999.00","1.50"]],"asks":[["15001.00","2.00"]]}`)
+	}
+	return s
+}
+
+// HitBTC serves one trade and a small order book for every pair, e.g. BTCUSD
+func HitBTC(pairs ...string) *Server {
+	s := New()
+	for _, p := range pairs {
+		s.HandleJSON("/api/2/public/trades/"+p,
+			`[{"id":2,"price":"15000.50","quantity":"0.25","side":"sell","timestamp":"2018-01-03T17:20:00.000Z"}]`)
+		s.HandleJSON("/api/2/public/orderbook/"+strings.ToLower(p),
+			`{"ask":[{"price":"15001.00","size":"2.0"}],"bid":[{"price":"14999.00","size":"1.5"}]}`)
+	}
+	return s
+}
+
+// Kraken serves the public time, trades and depth calls, the depth answer
+// holds every pair given in kraken notation e.g. XXBTZUSD
+func Kraken(pairs ...string) *Server {
+	s := New()
+	now := time.Now().Unix()
+	s.HandleJSON("/0/public/Time", fmt.Sprintf(`{"error":[],"result":{"unixtime":%d,"rfc1123":""}}`, now))
+	var trades, books []string
+	for _, p := range pairs {
+		trades = append(trades, fmt.Sprintf(`"%s":[["15000.50000","0.25000000",%d.1234,"b","l",""]]`, p, now))
+		books = append(books, fmt.Sprintf(`"%s":{"asks":[["15001.00000","2.000",%d]],"bids":[["14999.00000","1.500",%d]]}`, p, now, now))
+	}
+	trades = append(trades, fmt.Sprintf(`"last":"%d"`, now*int64(time.Second)))
+	s.HandleJSON("/0/public/Trades", fmt.Sprintf(`{"error":[],"result":{%s}}`, strings.Join(trades, ",")))
+	s.HandleJSON("/0/public/Depth", fmt.Sprintf(`{"error":[],"result":{%s}}`, strings.Join(books, ",")))
+	return s
+}
@@ -0,0 +1,226 @@
+// Package mockexchange serves scripted exchange responses so crawlers can be
+// tested end to end without network access.
+package mockexchange
+
+import (
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	pusherPrefix = "/app/"
+	// clients bind their event handlers right after subscribing
+	pusherDelay = 100 * time.Millisecond
+)
+
+type Response struct {
+	Status int
+	Header map[string]string
+	Body   string
+}
+
+type pusherEvent struct {
+	Event   string          `json:"event"`
+	Channel string          `json:"channel,omitempty"`
+	Data    json.RawMessage `json:"data"`
+}
+
+type Server struct {
+	*httptest.Server
+	locker   sync.Mutex
+	routes   map[string][]Response
+	streams  map[string][]string
+	channels map[string][]pusherEvent
+	hits     map[string]int
+	conns    int
+	upgrader websocket.Upgrader
+}
+
+func New() *Server {
+	s := &Server{
+		routes:   map[string][]Response{},
+		streams:  map[string][]string{},
+		channels: map[string][]pusherEvent{},
+		hits:     map[string]int{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// WebsocketURL is the base url websocket clients should dial
+func (s *Server) WebsocketURL() string {
+	return "ws" + strings.TrimPrefix(s.URL, "http")
+}
+
+// Handle scripts the answers for a path, they are served in order and the
+// last one is repeated once the script runs out
+func (s *Server) Handle(path string, responses ...Response) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	s.routes[path] = append(s.routes[path], responses...)
+}
+
+func (s *Server) HandleJSON(path string, body string) {
+	s.Handle(path, Response{Status: http.StatusOK, Body: body})
+}
+
+// Stream sends the frames to every websocket client connecting on path
+func (s *Server) Stream(path string, frames ...string) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	s.streams[path] = append(s.streams[path], frames...)
+}
+
+// Pusher sends the events to clients subscribing to channel through the
+// pusher protocol, data is sent as an encoded string like pusher does
+func (s *Server) Pusher(channel, event string, data ...string) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	for _, d := range data {
+		encoded, _ := json.Marshal(d)
+		s.channels[channel] = append(s.channels[channel], pusherEvent{Event: event, Channel: channel, Data: encoded})
+	}
+}
+
+// Hits returns the number of requests received for path
+func (s *Server) Hits(path string) int {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	return s.hits[path]
+}
+
+// Connections returns the number of websocket clients connected
+func (s *Server) Connections() int {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	return s.conns
+}
+
+// upgrade opens a websocket, counted until done is called
+func (s *Server) upgrade(w http.ResponseWriter, r *http.Request) (*websocket.Conn, func(), error) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.locker.Lock()
+	s.conns++
+	s.locker.Unlock()
+	return conn, func() {
+		conn.Close()
+		s.locker.Lock()
+		s.conns--
+		s.locker.Unlock()
+	}, nil
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.locker.Lock()
+	s.hits[r.URL.Path]++
+	frames, isStream := s.streams[r.URL.Path]
+	s.locker.Unlock()
+	switch {
+	case strings.HasPrefix(r.URL.Path, pusherPrefix):
+		s.servePusher(w, r)
+	case isStream:
+		s.serveStream(w, r, frames)
+	default:
+		s.serveRest(w, r)
+	}
+}
+
+func (s *Server) serveRest(w http.ResponseWriter, r *http.Request) {
+	s.locker.Lock()
+	script, ok := s.routes[r.URL.Path]
+	if !ok {
+		s.locker.Unlock()
+		http.NotFound(w, r)
+		return
+	}
+	resp := script[0]
+	if len(script) > 1 {
+		s.routes[r.URL.Path] = script[1:]
+	}
+	s.locker.Unlock()
+	for k, v := range resp.Header {
+		w.Header().Set(k, v)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if resp.Status != 0 {
+		w.WriteHeader(resp.Status)
+	}
+	w.Write([]byte(resp.Body))
+}
+
+func (s *Server) serveStream(w http.ResponseWriter, r *http.Request, frames []string) {
+	conn, done, err := s.upgrade(w, r)
+	if err != nil {
+		return
+	}
+	defer done()
+	for _, f := range frames {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(f)); err != nil {
+			return
+		}
+	}
+	// keep the connection open until the client goes away
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) servePusher(w http.ResponseWriter, r *http.Request) {
+	conn, done, err := s.upgrade(w, r)
+	if err != nil {
+		return
+	}
+	defer done()
+	established, _ := json.Marshal(`{"socket_id":"1.1","activity_timeout":120}`)
+	err = conn.WriteJSON(pusherEvent{Event: "pusher:connection_established", Data: established})
+	if err != nil {
+		return
+	}
+	for {
+		ev := pusherEvent{}
+		if err := conn.ReadJSON(&ev); err != nil {
+			return
+		}
+		if ev.Event != "pusher:subscribe" {
+			continue
+		}
+		channel := subscribedChannel(ev.Data)
+		err = conn.WriteJSON(pusherEvent{Event: "pusher_internal:subscription_succeeded", Channel: channel, Data: []byte(`"{}"`)})
+		if err != nil {
+			return
+		}
+		s.locker.Lock()
+		events := s.channels[channel]
+		s.locker.Unlock()
+		time.Sleep(pusherDelay)
+		for _, e := range events {
+			if err := conn.WriteJSON(e); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// subscribedChannel accepts the subscription data either as an object or as
+// an encoded string, pusher clients do both
+func subscribedChannel(data json.RawMessage) string {
+	var encoded string
+	if json.Unmarshal(data, &encoded) == nil {
+		data = []byte(encoded)
+	}
+	sub := struct {
+		Channel string `json:"channel"`
+	}{}
+	json.Unmarshal(data, &sub)
+	return sub.Channel
+}
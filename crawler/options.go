@@ -0,0 +1,182 @@
+package crawler
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	Trades = "trades"
+	Books  = "books"
+	// the order book updates, like the quotes channels of the websocket writer
+	Quotes = "quotes"
+
+	minPollInterval = 100 * time.Millisecond
+)
+
+// Duration reads durations from config strings such as "500ms"
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration should be a string like \"500ms\": %s", string(b))
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// Options tune what and how often a crawler collects, zero values fall back
+// to the exchange defaults in crawlerOptions
+type Options struct {
+	PollInterval Duration `json:"poll_interval"`
+	Channels     []string `json:"channels"`
+	// number of price levels requested per side, 0 lets the exchange decide
+	BookDepth int `json:"book_depth"`
+}
+
+func (o Options) Collects(channel string) bool {
+	for _, c := range o.Channels {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
+
+type optionSpec struct {
+	defaults Options
+	channels []string
+	polling  bool
+	depth    bool
+}
+
+var (
+	// defaults of every crawler along with what each of them supports, this is
+	// the reference for what a config without an options block will do
+	crawlerOptions = map[string]optionSpec{
+		Kraken: {
+			defaults: Options{PollInterval: Duration{500 * time.Millisecond}, Channels: []string{Trades, Books}},
+			channels: []string{Trades, Books},
+			polling:  true,
+			depth:    true,
+		},
+		Bittrex: {
+			defaults: Options{PollInterval: Duration{600 * time.Millisecond}, Channels: []string{Trades}},
+			channels: []string{Trades},
+			polling:  true,
+		},
+		// the hitbtc order book is off by default, see HitBTCCrawler.Orders
+		HitBTC: {
+			defaults: Options{PollInterval: Duration{2 * time.Second}, Channels: []string{Trades}},
+			channels: []string{Trades, Books},
+			polling:  true,
+			depth:    true,
+		},
+		Binance: {
+			defaults: Options{Channels: []string{Trades, Books}},
+			channels: []string{Trades, Books},
+		},
+		Bitstamp: {
+			defaults: Options{Channels: []string{Trades, Books}},
+			channels: []string{Trades, Books},
+		},
+		Bitfin: {
+			defaults: Options{Channels: []string{Trades, Books}},
+			channels: []string{Trades, Books},
+		},
+		Poloniex: {
+			defaults: Options{Channels: []string{Trades, Books}},
+			channels: []string{Trades, Books},
+		},
+	}
+)
+
+var (
+	pairMappings = map[string]map[string]string{
+		Kraken:   krakenPairMapping,
+		Bittrex:  bitrexPairMapping,
+		HitBTC:   hitBTCPairMapping,
+		Binance:  binancePairMapping,
+		Bitstamp: bitStampPairMapping,
+		Bitfin:   bitfinexPairMapping,
+		Poloniex: poloniexPairMapping,
+	}
+)
+
+// SupportedPairs lists the pairs a crawler accepts in its exchange notation,
+// nil for an unknown crawler
+func SupportedPairs(name string) []string {
+	mapping, ok := pairMappings[name]
+	if !ok {
+		return nil
+	}
+	pairs := make([]string, 0, len(mapping))
+	for p := range mapping {
+		pairs = append(pairs, p)
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+// ResolveOptions validates the options of a crawler config and fills in the
+// exchange defaults for everything left out
+func ResolveOptions(cfg CrawlerConfig) (Options, error) {
+	spec, ok := crawlerOptions[cfg.Name]
+	if !ok {
+		return Options{}, fmt.Errorf("unknown crawler %s", cfg.Name)
+	}
+	opts := cfg.Options
+	if opts.PollInterval.Duration != 0 {
+		if !spec.polling {
+			return opts, fmt.Errorf("%s: poll_interval is not supported, the crawler is streaming", cfg.Name)
+		}
+		if opts.PollInterval.Duration < minPollInterval {
+			return opts, fmt.Errorf("%s: poll_interval should be at least %s", cfg.Name, minPollInterval)
+		}
+	} else {
+		opts.PollInterval = spec.defaults.PollInterval
+	}
+	if len(opts.Channels) == 0 {
+		opts.Channels = spec.defaults.Channels
+	}
+	channels := make([]string, 0, len(opts.Channels))
+	seen := map[string]bool{}
+	for _, name := range opts.Channels {
+		ch := name
+		if ch == Quotes {
+			ch = Books
+		}
+		supported := false
+		for _, s := range spec.channels {
+			supported = supported || s == ch
+		}
+		if !supported {
+			return opts, fmt.Errorf("%s: channel %s is not supported, use one of %v", cfg.Name, name, spec.channels)
+		}
+		if !seen[ch] {
+			seen[ch] = true
+			channels = append(channels, ch)
+		}
+	}
+	opts.Channels = channels
+	if opts.BookDepth < 0 {
+		return opts, fmt.Errorf("%s: book_depth can not be negative", cfg.Name)
+	}
+	if opts.BookDepth > 0 && !spec.depth {
+		return opts, fmt.Errorf("%s: book_depth is not supported", cfg.Name)
+	}
+	return opts, nil
+}
@@ -0,0 +1,50 @@
+package crawler
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestResolveOptions(t *testing.T) {
+	opts, err := ResolveOptions(CrawlerConfig{Name: Kraken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.PollInterval.Duration != 500*time.Millisecond || !opts.Collects(Trades) || !opts.Collects(Books) {
+		t.Errorf("unexpected kraken defaults: %+v", opts)
+	}
+	cfg := CrawlerConfig{}
+	err = json.Unmarshal([]byte(`{"name":"hitbtc","options":{"poll_interval":"5s","channels":["books"],"book_depth":10}}`), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts, err = ResolveOptions(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.PollInterval.Duration != 5*time.Second || opts.Collects(Trades) || !opts.Collects(Books) || opts.BookDepth != 10 {
+		t.Errorf("unexpected hitbtc options: %+v", opts)
+	}
+	opts, err = ResolveOptions(CrawlerConfig{Name: Binance, Options: Options{Channels: []string{Quotes, Books}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(opts.Channels) != 1 || !opts.Collects(Books) {
+		t.Errorf("expected quotes to collect the books, got %+v", opts)
+	}
+	invalid := []CrawlerConfig{
+		{Name: Bittrex, Options: Options{Channels: []string{Quotes}}},
+		{Name: "unknown"},
+		{Name: Binance, Options: Options{PollInterval: Duration{time.Second}}},
+		{Name: Kraken, Options: Options{PollInterval: Duration{time.Millisecond}}},
+		{Name: Bittrex, Options: Options{Channels: []string{Books}}},
+		{Name: Bitstamp, Options: Options{BookDepth: 10}},
+		{Name: Kraken, Options: Options{BookDepth: -1}},
+	}
+	for _, c := range invalid {
+		if _, err := ResolveOptions(c); err == nil {
+			t.Errorf("expected an error for %+v", c)
+		}
+	}
+}
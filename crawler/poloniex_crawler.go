@@ -6,14 +6,14 @@ import (
 	"github.com/gammazero/nexus/client"
 	"github.com/gammazero/nexus/wamp"
 	log "github.com/sirupsen/logrus"
-	"net/http"
 	"strconv"
 	"sync"
 	"time"
 )
 
 const (
-	poloniexChartUrl = "https://poloniex.com/public?command=returnTradeHistory&currencyPair=USDT_BTC"
+	poloniexUrlBase  = "https://poloniex.com"
+	poloniexChartUrl = "/public?command=returnTradeHistory&currencyPair=USDT_BTC"
 	poloniexWssURL   = "wss://api.poloniex.com"
 	modify           = "orderBookModify"
 	remove           = "orderBookRemove"
@@ -37,49 +37,73 @@ var (
 )
 
 type PoloniexCrawler struct {
+	clock
 	writers   []DataWriter
 	cli       *client.Client
 	pairs     []string
 	state     sync.Map
 	timeDiff  int64
 	closeChan chan bool
+	// Close may be called once Loop returned on its own
+	closeOnce sync.Once
 	clientCfg client.ClientConfig
+	wssURL    string
+	recorder  *Recorder
+	options   Options
 }
 
-func NewPoloniex(writers []DataWriter, pairs []string) (Crawler, error) {
-	diff, err := getPoloniexTimeDiff()
+func NewPoloniex(writers []DataWriter, cfg CrawlerConfig) (Crawler, error) {
+	opts, err := ResolveOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	diff, err := getPoloniexTimeDiff(cfg.Endpoints.rest(poloniexUrlBase))
 	if err != nil {
 		return nil, err
 	}
 	log.Infof("time difference %d", diff)
-	cfg := client.ClientConfig{
+	clientCfg := client.ClientConfig{
 		Realm:           "realm1",
 		Logger:          log.New(),
 		ResponseTimeout: time.Second * 30,
 	}
-	cli, err := client.ConnectNet(poloniexWssURL, cfg)
+	wssURL := cfg.Endpoints.websocket(poloniexWssURL)
+	cli, err := client.ConnectNet(wssURL, clientCfg)
 	if err != nil {
 		return nil, fmt.Errorf("error creating wamp client: %s", err)
 	}
 	log.Infof("created WAMP client")
+	rec, err := NewRecorder(cfg.Capture, Poloniex)
+	if err != nil {
+		return nil, err
+	}
 	return &PoloniexCrawler{
+		recorder:  rec,
 		writers:   writers,
-		pairs:     pairs,
+		pairs:     cfg.Pairs,
 		cli:       cli,
 		state:     sync.Map{},
 		timeDiff:  diff,
 		closeChan: make(chan bool),
-		clientCfg: cfg,
+		clientCfg: clientCfg,
+		wssURL:    wssURL,
+		options:   opts,
 	}, nil
 }
 
 func (c *PoloniexCrawler) Close() {
-	c.closeChan <- true
+	c.closeOnce.Do(func() { close(c.closeChan) })
+	c.recorder.Close()
 }
 
 func (c *PoloniexCrawler) connect() error {
 	for k, v := range poloniexPairMapping {
 		err := c.cli.Subscribe(k, func(args wamp.List, kwargs wamp.Dict, details wamp.Dict) {
+			if c.recorder != nil {
+				if bits, err := json.Marshal(args); err == nil {
+					c.recorder.Record(k, bits)
+				}
+			}
 			details["pair"] = v
 			c.handle(args, kwargs, details)
 		}, nil)
@@ -93,9 +117,10 @@ func (c *PoloniexCrawler) connect() error {
 
 func (c *PoloniexCrawler) Loop() {
 	defer c.cli.Close()
-	err := c.connect()
-	if err != nil {
-		panic(err)
+	// started by a reload too, a failure should not take the process down
+	if err := c.connect(); err != nil {
+		log.Errorf("error connecting to poloniex, the crawler is stopped: %s", err)
+		return
 	}
 	select {
 	case <-c.closeChan:
@@ -103,6 +128,7 @@ func (c *PoloniexCrawler) Loop() {
 		return
 	case <-c.cli.Done():
 		log.Info("router gone, reconnecting")
+		reconnects.WithLabelValues(Poloniex).Inc()
 		c.reConnect()
 	}
 
@@ -110,7 +136,7 @@ func (c *PoloniexCrawler) Loop() {
 
 func (c *PoloniexCrawler) reConnect() {
 	for {
-		cli, err := client.ConnectNet(poloniexWssURL, c.clientCfg)
+		cli, err := client.ConnectNet(c.wssURL, c.clientCfg)
 		if err != nil {
 			log.Errorf("error creating client, retrying: %s", err)
 			time.Sleep(time.Second)
@@ -127,6 +153,28 @@ func (c *PoloniexCrawler) reConnect() {
 	}
 }
 
+func newPoloniexReplayer(writers []DataWriter, cfg CrawlerConfig) (Replayer, error) {
+	opts, err := ResolveOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &PoloniexCrawler{pairs: cfg.Pairs, writers: writers, options: opts}, nil
+}
+
+func (c *PoloniexCrawler) Replay(source string, data []byte, received int64) error {
+	c.replayed = received
+	v, ok := poloniexPairMapping[source]
+	if !ok {
+		return unknownSource(source)
+	}
+	var args wamp.List
+	if err := json.Unmarshal(data, &args); err != nil {
+		return err
+	}
+	c.handle(args, wamp.Dict{}, wamp.Dict{pair: v})
+	return nil
+}
+
 func (c *PoloniexCrawler) handle(args wamp.List, kwargs wamp.Dict, details wamp.Dict) {
 	var p string
 	if pi, ok := details[pair]; ok {
@@ -153,6 +201,7 @@ func (c *PoloniexCrawler) handle(args wamp.List, kwargs wamp.Dict, details wamp.
 					}
 					err = json.Unmarshal(bits, dt)
 					if err != nil {
+						parseErrors.WithLabelValues(Poloniex).Inc()
 						log.Errorf("unable to marshal bytes into %T object: %s", dt, err)
 						continue
 					}
@@ -177,12 +226,23 @@ func (c *PoloniexCrawler) handle(args wamp.List, kwargs wamp.Dict, details wamp.
 }
 
 func (c *PoloniexCrawler) sendData(data interface{}, pair string) error {
+	// the push api mixes book updates and trades on a single topic
+	switch data.(type) {
+	case *Modify, *Remove:
+		if !c.options.Collects(Books) {
+			return nil
+		}
+	case *Trade:
+		if !c.options.Collects(Trades) {
+			return nil
+		}
+	}
 	switch v := data.(type) {
 	case *Modify:
 		m := OrderMeasurement{
 			Amount:    v.Amount,
 			Price:     v.Price,
-			Timestamp: Now(),
+			Timestamp: c.now(),
 			Platform:  Poloniex,
 			Pair:      pair,
 			Meta:      cancel,
@@ -194,9 +254,7 @@ func (c *PoloniexCrawler) sendData(data interface{}, pair string) error {
 		} else {
 			return fmt.Errorf("unknown trade type: %s", v.Type)
 		}
-		for _, w := range c.writers {
-			w.Write(m)
-		}
+		emit(c.writers, m, 0)
 		return nil
 		// buy == sell and sell == buy because of the pair ordering
 	case *Trade:
@@ -207,7 +265,8 @@ func (c *PoloniexCrawler) sendData(data interface{}, pair string) error {
 			Price:     v.Price,
 			Amount:    v.Amount,
 			TradeType: market,
-			Timestamp: Now(),
+			Timestamp: c.now(),
+			TradeID:   v.ID,
 		}
 		if v.Type == bid || v.Type == buy {
 			m.TransactionType = sell
@@ -216,9 +275,7 @@ func (c *PoloniexCrawler) sendData(data interface{}, pair string) error {
 		} else {
 			return fmt.Errorf("unknown trade type: %s", v.Type)
 		}
-		for _, w := range c.writers {
-			w.Write(m)
-		}
+		emit(c.writers, m, 0)
 		return nil
 	case *Remove:
 		m := CancelMeasurement{
@@ -226,7 +283,7 @@ func (c *PoloniexCrawler) sendData(data interface{}, pair string) error {
 			Price:     v.Price,
 			Platform:  Poloniex,
 			Pair:      pair,
-			TimeStamp: time.Now().Unix(),
+			TimeStamp: c.now(),
 		}
 		if v.Type == bid {
 			m.Type = sell
@@ -235,9 +292,7 @@ func (c *PoloniexCrawler) sendData(data interface{}, pair string) error {
 		} else {
 			return fmt.Errorf("unknown trade type: %s", v.Type)
 		}
-		for _, w := range c.writers {
-			w.Write(m)
-		}
+		emit(c.writers, m, 0)
 		return nil
 	default:
 		return fmt.Errorf("unknown data type: %T", v)
@@ -287,6 +342,7 @@ type Trade struct {
 	Type   string     `json:"type"`
 	Amount float64    `json:"amount,string"`
 	Date   CustomTime `json:"date"`
+	ID     string     `json:"tradeID"`
 }
 
 type Remove struct {
@@ -298,13 +354,9 @@ type PoloniexChart struct {
 	Date CustomTime `json:"date"`
 }
 
-func getPoloniexTimeDiff() (int64, error) {
-	r, err := http.Get(poloniexChartUrl)
-	if err != nil {
-		return 0, err
-	}
+func getPoloniexTimeDiff(urlBase string) (int64, error) {
 	var chrs []PoloniexChart
-	err = ReadJson(r, &chrs)
+	err := restClientFor(Poloniex).GetJson(urlBase+poloniexChartUrl, &chrs)
 	if err != nil {
 		return 0, err
 	}
@@ -1,7 +1,5 @@
 package crawler
 
-import "net/http"
-
 var (
 	quionePairMapping = map[string]string{
 		ETHEUR: ETHEUR,
@@ -13,7 +11,7 @@ var (
 )
 
 const (
-	quioneProductsUrl = "https://api.quoine.com/products"
+	quioneUrlBase = "https://api.quoine.com"
 )
 
 type QuioneCrawler struct {
@@ -34,20 +32,15 @@ func (c *QuioneCrawler) Close() {
 	c.closeChan <- true
 }
 
-func NewQuioneCrawler(writers []DataWriter, pairs []string) (Crawler, error) {
-	resp, err := http.Get(quioneProductsUrl)
-	if err != nil {
-		return nil, err
-	}
+func NewQuioneCrawler(writers []DataWriter, cfg CrawlerConfig) (Crawler, error) {
 	var ids []ProductResponse
-	defer resp.Body.Close()
-	err = ReadJson(resp, &ids)
+	err := restClientFor(Quione).GetJson(cfg.Endpoints.rest(quioneUrlBase)+"/products", &ids)
 	if err != nil {
 		return nil, err
 	}
 	pairMapping := map[string]int{}
 	for _, p := range ids {
-		for _, pair := range pairs {
+		for _, pair := range cfg.Pairs {
 			if p.Pair == pair {
 				pairMapping[pair] = p.ID
 			}
@@ -0,0 +1,152 @@
+package crawler
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const recorderFlushPeriod = time.Second
+
+// Frame is a raw message as received from an exchange
+type Frame struct {
+	// receive time in unix nanoseconds
+	Time     int64  `json:"time"`
+	Platform string `json:"platform"`
+	// crawler specific origin of the frame e.g. trade, order or http:/path
+	Source string `json:"source"`
+	Data   string `json:"data"`
+}
+
+// Recorder appends raw frames to a gzip compressed json lines capture file;
+// a nil Recorder discards everything so crawlers can call it unconditionally
+type Recorder struct {
+	locker    sync.Mutex
+	platform  string
+	file      *os.File
+	buf       *bufio.Writer
+	gz        *gzip.Writer
+	enc       *json.Encoder
+	closeChan chan bool
+	closed    bool
+}
+
+func NewRecorder(path, platform string) (*Recorder, error) {
+	if path == "" {
+		return nil, nil
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	buf := bufio.NewWriter(file)
+	gz := gzip.NewWriter(buf)
+	r := &Recorder{
+		platform:  platform,
+		file:      file,
+		buf:       buf,
+		gz:        gz,
+		enc:       json.NewEncoder(gz),
+		closeChan: make(chan bool),
+	}
+	log.Infof("recording raw %s frames to %s", platform, path)
+	go r.flushLoop()
+	return r, nil
+}
+
+func (r *Recorder) Record(source string, data []byte) {
+	if r == nil {
+		return
+	}
+	f := Frame{
+		Time:     time.Now().UnixNano(),
+		Platform: r.platform,
+		Source:   source,
+		Data:     string(data),
+	}
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	if r.closed {
+		return
+	}
+	if err := r.enc.Encode(f); err != nil {
+		log.Errorf("error recording %s frame: %s", r.platform, err)
+	}
+}
+
+// flushLoop makes sure a capture stays readable when the process gets killed
+func (r *Recorder) flushLoop() {
+	ticker := time.NewTicker(recorderFlushPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.locker.Lock()
+			err := r.flush()
+			r.locker.Unlock()
+			if err != nil {
+				log.Errorf("error flushing %s capture: %s", r.platform, err)
+			}
+		case <-r.closeChan:
+			return
+		}
+	}
+}
+
+func (r *Recorder) flush() error {
+	if err := r.gz.Flush(); err != nil {
+		return err
+	}
+	return r.buf.Flush()
+}
+
+// Close may be called more than once, crawlers close their recorder on every
+// Close
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	close(r.closeChan)
+	if err := r.gz.Close(); err != nil {
+		return err
+	}
+	if err := r.buf.Flush(); err != nil {
+		return err
+	}
+	return r.file.Close()
+}
+
+// ReadCapture calls fn for every frame of a capture, a capture cut short by
+// a killed process is read up to its last flushed frame
+func ReadCapture(in io.Reader, fn func(Frame) error) error {
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	dec := json.NewDecoder(gz)
+	for {
+		f := Frame{}
+		err := dec.Decode(&f)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+}
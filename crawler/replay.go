@@ -0,0 +1,126 @@
+package crawler
+
+import (
+	"bytes"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Replayer feeds a recorded frame through the same parsing and
+// normalization code the crawler uses on live data, received is the epoch
+// millis the frame was recorded at
+type Replayer interface {
+	Replay(source string, data []byte, received int64) error
+}
+
+// clock stamps the measurements the exchange gives no time for, with the
+// receive time of the frame being replayed so that replays are reproducible
+type clock struct {
+	replayed int64
+}
+
+func (c *clock) now() int64 {
+	if c.replayed != 0 {
+		return c.replayed
+	}
+	return Now()
+}
+
+type replayFactory func(writers []DataWriter, cfg CrawlerConfig) (Replayer, error)
+
+var (
+	replayFactories = map[string]replayFactory{
+		Binance:  newBinanceReplayer,
+		Bitstamp: newBitStampReplayer,
+		HitBTC:   newHitBTCReplayer,
+		Poloniex: newPoloniexReplayer,
+		Bitfin:   newBitfinexReplayer,
+		Kraken:   newKrakenReplayer,
+		Bittrex:  newBittrexReplayer,
+	}
+)
+
+// Replay sends every frame of a capture to a crawler of the platform that
+// recorded it; speed scales the original pacing, 0 replays as fast as possible
+func Replay(in io.Reader, writers []DataWriter, configs []CrawlerConfig, speed float64) error {
+	replayers := map[string]Replayer{}
+	skipped := map[string]int{}
+	var first int64
+	start := time.Now()
+	err := ReadCapture(in, func(f Frame) error {
+		r, ok := replayers[f.Platform]
+		if !ok {
+			rf, ok := replayFactories[f.Platform]
+			if !ok {
+				skipped[f.Platform]++
+				return nil
+			}
+			cfg := CrawlerConfig{Name: f.Platform}
+			for _, c := range configs {
+				if c.Name == f.Platform {
+					cfg = c
+				}
+			}
+			var err error
+			if r, err = rf(writers, cfg); err != nil {
+				return err
+			}
+			replayers[f.Platform] = r
+		}
+		if first == 0 {
+			first = f.Time
+		}
+		if speed > 0 {
+			offset := time.Duration(float64(f.Time-first) / speed)
+			if wait := time.Until(start.Add(offset)); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+		if err := r.Replay(f.Source, []byte(f.Data), f.Time/int64(time.Millisecond)); err != nil {
+			log.Errorf("error replaying %s frame from %s: %s", f.Platform, f.Source, err)
+		}
+		return nil
+	})
+	for p, n := range skipped {
+		log.Warnf("skipped %d frames from %s, replay is not supported for it", n, p)
+	}
+	return err
+}
+
+func unknownSource(source string) error {
+	return fmt.Errorf("unknown frame source: %s", source)
+}
+
+// restSource returns the request uri of a recorded rest response
+func restSource(source string) (*url.URL, error) {
+	if !strings.HasPrefix(source, "http:") {
+		return nil, unknownSource(source)
+	}
+	u, err := url.ParseRequestURI(strings.TrimPrefix(source, "http:"))
+	if err != nil {
+		return nil, unknownSource(source)
+	}
+	return u, nil
+}
+
+// replayTransport answers every request with a recorded response body, so
+// that the crawlers using third party clients replay through their parsing
+type replayTransport struct {
+	body []byte
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(bytes.NewReader(t.body)),
+		Request:    req,
+	}, nil
+}
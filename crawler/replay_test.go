@@ -0,0 +1,103 @@
+package crawler
+
+import (
+	"cryptoCrawl/crawler/mockexchange"
+	"github.com/beldur/kraken-go-api-client"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	s := mockexchange.Binance("BTCUSDT")
+	defer s.Close()
+	capture := filepath.Join(t.TempDir(), "binance.jsonl.gz")
+	w := make(chanWriter, 10)
+	cfg := CrawlerConfig{
+		Name:      Binance,
+		Pairs:     []string{"BTCUSDT"},
+		Endpoints: Endpoints{Rest: s.URL, Websocket: s.WebsocketURL() + "/ws"},
+		Capture:   capture,
+	}
+	c, err := NewBinance([]DataWriter{w}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go c.Loop()
+	liveTrades, liveOrders := expectMeasurements(t, w, 3)
+	c.Close()
+
+	f, err := os.Open(capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	replayed := make(chanWriter, 10)
+	if err = Replay(f, []DataWriter{replayed}, []CrawlerConfig{cfg}, 0); err != nil {
+		t.Fatal(err)
+	}
+	trades, orders := expectMeasurements(t, replayed, 3)
+	if len(trades) != len(liveTrades) || len(orders) != len(liveOrders) {
+		t.Fatalf("replay produced %+v %+v, live crawl %+v %+v", trades, orders, liveTrades, liveOrders)
+	}
+	if trades[0].Price != liveTrades[0].Price || trades[0].TransactionType != liveTrades[0].TransactionType {
+		t.Fatalf("replayed trade %+v differs from %+v", trades[0], liveTrades[0])
+	}
+	checkOrders(t, orders, Binance, BTCUSD)
+
+	// orders have no exchange time, they get the time they were recorded at
+	time.Sleep(5 * time.Millisecond)
+	again := make(chanWriter, 10)
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if err = Replay(f, []DataWriter{again}, []CrawlerConfig{cfg}, 0); err != nil {
+		t.Fatal(err)
+	}
+	trades2, orders2 := expectMeasurements(t, again, 3)
+	if !reflect.DeepEqual(trades, trades2) || !reflect.DeepEqual(orders, orders2) {
+		t.Fatalf("replaying twice gave %+v %+v then %+v %+v", trades, orders, trades2, orders2)
+	}
+}
+
+func TestRecordAndReplayKraken(t *testing.T) {
+	s := mockexchange.Kraken(krakenapi.XXBTZUSD)
+	defer s.Close()
+	capture := filepath.Join(t.TempDir(), "kraken.jsonl.gz")
+	w := make(chanWriter, 10)
+	cfg := CrawlerConfig{
+		Name:      Kraken,
+		Pairs:     []string{krakenapi.XXBTZUSD},
+		Endpoints: Endpoints{Rest: s.URL},
+		Capture:   capture,
+	}
+	c, err := NewKraken([]DataWriter{w}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.(*KrakenCrawler).ReadTrades(krakenapi.XXBTZUSD)
+	c.(*KrakenCrawler).ReadDepth(krakenapi.XXBTZUSD)
+	liveTrades, liveOrders := expectMeasurements(t, w, 3)
+	c.Close()
+
+	f, err := os.Open(capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	replayed := make(chanWriter, 10)
+	if err = Replay(f, []DataWriter{replayed}, []CrawlerConfig{cfg}, 0); err != nil {
+		t.Fatal(err)
+	}
+	trades, orders := expectMeasurements(t, replayed, 3)
+	if len(trades) != len(liveTrades) || len(orders) != len(liveOrders) {
+		t.Fatalf("replay produced %+v %+v, live crawl %+v %+v", trades, orders, liveTrades, liveOrders)
+	}
+	if trades[0].Price != liveTrades[0].Price || trades[0].TransactionType != liveTrades[0].TransactionType {
+		t.Fatalf("replayed trade %+v differs from %+v", trades[0], liveTrades[0])
+	}
+	checkOrders(t, orders, Kraken, BTCUSD)
+}
@@ -0,0 +1,485 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Binance answers with 418 once an IP keeps hammering it after a 429
+	statusBanned = 418
+	fixer        = "fixer"
+	// time to get the response headers of a single attempt
+	attemptTimeout = 10 * time.Second
+)
+
+// RateLimit configures the token bucket and retry policy used for all REST
+// calls towards one exchange
+type RateLimit struct {
+	PerSecond  float64
+	Burst      int
+	MaxWait    time.Duration
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+// timeout bounds a whole call, retries included, so that a response body
+// that never ends does not hang the caller
+func (l RateLimit) timeout() time.Duration {
+	attempts := time.Duration(l.MaxRetries + 1)
+	return attempts * (l.MaxWait + attemptTimeout + l.MaxDelay)
+}
+
+var (
+	defaultRateLimit = RateLimit{
+		PerSecond:  1,
+		Burst:      5,
+		MaxWait:    10 * time.Second,
+		MaxRetries: 3,
+		BaseDelay:  500 * time.Millisecond,
+		MaxDelay:   30 * time.Second,
+	}
+	restLimits = map[string]RateLimit{
+		Binance:  {PerSecond: 20, Burst: 10, MaxWait: 5 * time.Second, MaxRetries: 3, BaseDelay: 500 * time.Millisecond, MaxDelay: 30 * time.Second},
+		Bitstamp: {PerSecond: 10, Burst: 10, MaxWait: 5 * time.Second, MaxRetries: 3, BaseDelay: 500 * time.Millisecond, MaxDelay: 30 * time.Second},
+		HitBTC:   {PerSecond: 10, Burst: 20, MaxWait: 5 * time.Second, MaxRetries: 3, BaseDelay: 500 * time.Millisecond, MaxDelay: 30 * time.Second},
+		Kraken:   {PerSecond: 1, Burst: 15, MaxWait: 5 * time.Second, MaxRetries: 3, BaseDelay: time.Second, MaxDelay: time.Minute},
+		Bittrex:  {PerSecond: 5, Burst: 10, MaxWait: 5 * time.Second, MaxRetries: 3, BaseDelay: 500 * time.Millisecond, MaxDelay: 30 * time.Second},
+		Poloniex: {PerSecond: 6, Burst: 6, MaxWait: 5 * time.Second, MaxRetries: 3, BaseDelay: 500 * time.Millisecond, MaxDelay: 30 * time.Second},
+		Quione:   {PerSecond: 1, Burst: 5, MaxWait: 10 * time.Second, MaxRetries: 3, BaseDelay: time.Second, MaxDelay: time.Minute},
+		fixer:    defaultRateLimit,
+	}
+	restClients = map[string]*RestClient{}
+	restLock    = sync.Mutex{}
+)
+
+// SetRateLimit overrides the limits for an exchange; clients that were
+// already created keep their settings
+func SetRateLimit(exchange string, limit RateLimit) {
+	restLock.Lock()
+	defer restLock.Unlock()
+	restLimits[exchange] = limit
+}
+
+func restClientFor(exchange string) *RestClient {
+	restLock.Lock()
+	defer restLock.Unlock()
+	if c, ok := restClients[exchange]; ok {
+		return c
+	}
+	limit, ok := restLimits[exchange]
+	if !ok {
+		limit = defaultRateLimit
+	}
+	c := NewRestClient(exchange, limit)
+	restClients[exchange] = c
+	return c
+}
+
+// RestStats returns a snapshot of the REST metrics of every exchange
+func RestStats() map[string]RestMetrics {
+	restLock.Lock()
+	defer restLock.Unlock()
+	stats := map[string]RestMetrics{}
+	for k, c := range restClients {
+		stats[k] = c.metrics.snapshot()
+	}
+	return stats
+}
+
+type RestMetrics struct {
+	Requests     int64 `json:"requests"`
+	Retries      int64 `json:"retries"`
+	RateLimited  int64 `json:"rate_limited"`
+	AuthErrors   int64 `json:"auth_errors"`
+	ParseErrors  int64 `json:"parse_errors"`
+	ServerErrors int64 `json:"server_errors"`
+	Failures     int64 `json:"failures"`
+}
+
+func (m *RestMetrics) snapshot() RestMetrics {
+	return RestMetrics{
+		Requests:     atomic.LoadInt64(&m.Requests),
+		Retries:      atomic.LoadInt64(&m.Retries),
+		RateLimited:  atomic.LoadInt64(&m.RateLimited),
+		AuthErrors:   atomic.LoadInt64(&m.AuthErrors),
+		ParseErrors:  atomic.LoadInt64(&m.ParseErrors),
+		ServerErrors: atomic.LoadInt64(&m.ServerErrors),
+		Failures:     atomic.LoadInt64(&m.Failures),
+	}
+}
+
+type RateLimitError struct {
+	Status     int
+	RetryAfter time.Duration
+	// set when the local token bucket refused the call, no request was made
+	Local bool
+}
+
+func (e *RateLimitError) Error() string {
+	if e.Local {
+		return fmt.Sprintf("local rate limit exceeded, next slot in %s", e.RetryAfter)
+	}
+	return fmt.Sprintf("rate limited with status code %d, retry after %s", e.Status, e.RetryAfter)
+}
+
+type AuthError struct {
+	Status int
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("authentication failed with status code: %d", e.Status)
+}
+
+type StatusError struct {
+	Status int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("invalid status code: %d", e.Status)
+}
+
+type ParseError struct {
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("unable to parse response: %s", e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+func statusError(resp *http.Response) error {
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == statusBanned:
+		return &RateLimitError{Status: resp.StatusCode, RetryAfter: retryAfter(resp)}
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return &AuthError{Status: resp.StatusCode}
+	default:
+		return &StatusError{Status: resp.StatusCode}
+	}
+}
+
+func retryAfter(resp *http.Response) time.Duration {
+	h := resp.Header.Get("Retry-After")
+	if h == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(h); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+type TokenBucket struct {
+	locker  sync.Mutex
+	rate    float64
+	burst   float64
+	tokens  float64
+	last    time.Time
+	blocked time.Time
+	maxWait time.Duration
+}
+
+func NewTokenBucket(perSecond float64, burst int, maxWait time.Duration) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucket{
+		rate:    perSecond,
+		burst:   float64(burst),
+		tokens:  float64(burst),
+		last:    time.Now(),
+		maxWait: maxWait,
+	}
+}
+
+// reserve takes a token and returns how long the caller has to wait before using it
+func (b *TokenBucket) reserve() (time.Duration, error) {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	var wait time.Duration
+	if b.tokens < 1 {
+		wait = time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	}
+	if b.blocked.After(now.Add(wait)) {
+		wait = b.blocked.Sub(now)
+	}
+	if b.maxWait > 0 && wait > b.maxWait {
+		return 0, &RateLimitError{Local: true, RetryAfter: wait}
+	}
+	b.tokens--
+	return wait, nil
+}
+
+func (b *TokenBucket) Wait(ctx context.Context) error {
+	wait, err := b.reserve()
+	if err != nil {
+		return err
+	}
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Block stops handing out tokens for the given duration, used when the
+// exchange tells us to back off
+func (b *TokenBucket) Block(d time.Duration) {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+	until := time.Now().Add(d)
+	if until.After(b.blocked) {
+		b.blocked = until
+	}
+}
+
+type RestClient struct {
+	exchange string
+	limit    RateLimit
+	limiter  *TokenBucket
+	client   *http.Client
+	metrics  *RestMetrics
+	baseURL  *url.URL
+	recorder *Recorder
+}
+
+func NewRestClient(exchange string, limit RateLimit) *RestClient {
+	c := &RestClient{
+		exchange: exchange,
+		limit:    limit,
+		limiter:  NewTokenBucket(limit.PerSecond, limit.Burst, limit.MaxWait),
+		metrics:  &RestMetrics{},
+	}
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.ResponseHeaderTimeout = attemptTimeout
+	c.client = &http.Client{Transport: &restTransport{client: c, base: base}, Timeout: limit.timeout()}
+	return c
+}
+
+// WithBaseURL returns a client sharing the limiter and metrics of c that
+// sends every request to base instead of the host it was built for
+func (c *RestClient) WithBaseURL(base string) (*RestClient, error) {
+	if base == "" {
+		return c, nil
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid base url: %s", base)
+	}
+	nc := c.clone()
+	nc.baseURL = u
+	return nc, nil
+}
+
+// WithRecorder returns a client sharing the limiter and metrics of c that
+// records every successful response body
+func (c *RestClient) WithRecorder(r *Recorder) *RestClient {
+	if r == nil {
+		return c
+	}
+	nc := c.clone()
+	nc.recorder = r
+	return nc
+}
+
+func (c *RestClient) clone() *RestClient {
+	nc := *c
+	nc.client = &http.Client{Transport: &restTransport{client: &nc, base: c.client.Transport.(*restTransport).base}, Timeout: c.client.Timeout}
+	return &nc
+}
+
+// HTTPClient returns a client going through the limiter and retry policy,
+// meant for the third party exchange libraries
+func (c *RestClient) HTTPClient() *http.Client {
+	return c.client
+}
+
+func (c *RestClient) Do(req *http.Request) (*http.Response, error) {
+	return c.client.Do(req)
+}
+
+func (c *RestClient) Get(url string) (*http.Response, error) {
+	return c.client.Get(url)
+}
+
+// GetBytes returns the body of a successful response, other status codes
+// are turned into the matching typed errors
+func (c *RestClient) GetBytes(url string) ([]byte, error) {
+	resp, err := c.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	return readBody(resp)
+}
+
+func (c *RestClient) GetJson(url string, data interface{}) error {
+	bits, err := c.GetBytes(url)
+	if err != nil {
+		return err
+	}
+	return c.Decode(bits, data)
+}
+
+// Decode unmarshals a response body fetched earlier, counting parse errors
+func (c *RestClient) Decode(bits []byte, data interface{}) error {
+	if err := json.Unmarshal(bits, data); err != nil {
+		atomic.AddInt64(&c.metrics.ParseErrors, 1)
+		parseErrors.WithLabelValues(c.exchange).Inc()
+		return &ParseError{Err: err}
+	}
+	return nil
+}
+
+func (c *RestClient) backoff(attempt int) time.Duration {
+	d := c.limit.BaseDelay * time.Duration(1<<uint(attempt))
+	if d <= 0 || d > c.limit.MaxDelay {
+		d = c.limit.MaxDelay
+	}
+	half := int64(d / 2)
+	if half <= 0 {
+		return d
+	}
+	return time.Duration(half + rand.Int63n(half))
+}
+
+// retryWait honours Retry-After up to MaxDelay, a longer one would stall the
+// poll loop of the crawler
+func (c *RestClient) retryWait(resp *http.Response, attempt int) time.Duration {
+	wait := retryAfter(resp)
+	if wait == 0 {
+		return c.backoff(attempt)
+	}
+	if wait > c.limit.MaxDelay {
+		wait = c.limit.MaxDelay
+	}
+	return wait
+}
+
+type restTransport struct {
+	client *RestClient
+	base   http.RoundTripper
+}
+
+func (t *restTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c := t.client
+	ctx := req.Context()
+	if c.baseURL != nil {
+		req = req.Clone(ctx)
+		req.URL.Scheme = c.baseURL.Scheme
+		req.URL.Host = c.baseURL.Host
+		req.URL.Path = strings.TrimSuffix(c.baseURL.Path, "/") + req.URL.Path
+		req.Host = ""
+	}
+	for attempt := 0; ; attempt++ {
+		if err := c.limiter.Wait(ctx); err != nil {
+			if _, ok := err.(*RateLimitError); ok {
+				atomic.AddInt64(&c.metrics.RateLimited, 1)
+			}
+			return nil, err
+		}
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		atomic.AddInt64(&c.metrics.Requests, 1)
+		resp, err := t.base.RoundTrip(req)
+		var wait time.Duration
+		switch {
+		case err != nil:
+			atomic.AddInt64(&c.metrics.Failures, 1)
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			wait = c.backoff(attempt)
+		case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == statusBanned:
+			atomic.AddInt64(&c.metrics.RateLimited, 1)
+			wait = c.retryWait(resp, attempt)
+			c.limiter.Block(wait)
+		case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+			atomic.AddInt64(&c.metrics.AuthErrors, 1)
+			return resp, nil
+		case resp.StatusCode >= http.StatusInternalServerError:
+			atomic.AddInt64(&c.metrics.ServerErrors, 1)
+			wait = c.retryWait(resp, attempt)
+		default:
+			if c.recorder != nil && resp.StatusCode == http.StatusOK {
+				resp.Body = &recordingBody{ReadCloser: resp.Body, recorder: c.recorder, source: "http:" + req.URL.RequestURI()}
+			}
+			return resp, nil
+		}
+		if attempt >= c.limit.MaxRetries || (req.Body != nil && req.GetBody == nil) {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		atomic.AddInt64(&c.metrics.Retries, 1)
+		log.Warnf("%s request to %s failed (attempt %d), retrying in %s", c.exchange, req.URL.Path, attempt+1, wait)
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// recordingBody records everything read from a response body once closed
+type recordingBody struct {
+	io.ReadCloser
+	recorder *Recorder
+	source   string
+	data     bytes.Buffer
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.data.Write(p[:n])
+	return n, err
+}
+
+func (b *recordingBody) Close() error {
+	b.recorder.Record(b.source, b.data.Bytes())
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,101 @@
+package crawler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testRateLimit() RateLimit {
+	return RateLimit{
+		PerSecond:  100,
+		Burst:      10,
+		MaxWait:    time.Second,
+		MaxRetries: 2,
+		BaseDelay:  time.Millisecond,
+		MaxDelay:   10 * time.Millisecond,
+	}
+}
+
+func TestRestClientRetry(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch calls {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			// capped to MaxDelay
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.Write([]byte(`{"serverTime": 42}`))
+		}
+	}))
+	defer srv.Close()
+	c := NewRestClient("test", testRateLimit())
+	resp := TimeResponse{}
+	start := time.Now()
+	if err := c.GetJson(srv.URL, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("Retry-After should be capped to MaxDelay, took %s", time.Since(start))
+	}
+	if resp.Time != 42 {
+		t.Fatalf("time should be 42 but is %d", resp.Time)
+	}
+	m := c.metrics.snapshot()
+	if m.Requests != 3 || m.Retries != 2 || m.RateLimited != 1 || m.ServerErrors != 1 {
+		t.Fatalf("unexpected metrics: %+v", m)
+	}
+}
+
+func TestRestClientErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth":
+			w.WriteHeader(http.StatusUnauthorized)
+		case "/limited":
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.Write([]byte(`not json`))
+		}
+	}))
+	defer srv.Close()
+	c := NewRestClient("test", testRateLimit())
+	resp := TimeResponse{}
+	if _, ok := c.GetJson(srv.URL+"/auth", &resp).(*AuthError); !ok {
+		t.Fatal("expected an auth error")
+	}
+	if _, ok := c.GetJson(srv.URL+"/limited", &resp).(*RateLimitError); !ok {
+		t.Fatal("expected a rate limit error")
+	}
+	if _, ok := c.GetJson(srv.URL+"/parse", &resp).(*ParseError); !ok {
+		t.Fatal("expected a parse error")
+	}
+	m := c.metrics.snapshot()
+	if m.AuthErrors != 1 || m.ParseErrors != 1 || m.RateLimited != 3 {
+		t.Fatalf("unexpected metrics: %+v", m)
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	b := NewTokenBucket(1, 2, 100*time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if wait, err := b.reserve(); err != nil || wait != 0 {
+			t.Fatalf("burst token %d should be free, got %s %v", i, wait, err)
+		}
+	}
+	if _, err := b.reserve(); err == nil {
+		t.Fatal("expected the bucket to refuse waiting a whole second")
+	}
+	b = NewTokenBucket(1000, 1, 0)
+	b.Block(50 * time.Millisecond)
+	if wait, _ := b.reserve(); wait < 40*time.Millisecond {
+		t.Fatalf("blocked bucket should wait, got %s", wait)
+	}
+}
@@ -3,6 +3,7 @@ package crawler
 import (
 	"encoding/json"
 	"fmt"
+	log "github.com/sirupsen/logrus"
 	"io/ioutil"
 	"net/http"
 	"strings"
@@ -79,7 +80,7 @@ func (c CancelMeasurement) AsInfluxMeasurement() InfluxMeasurement {
 		Measurement: c.Meta,
 		Tags:        map[string]string{"pair": c.Pair, "type": c.Type, "platform": c.Platform},
 		Fields:      map[string]interface{}{"price": c.Price},
-		Timestamp:   time.Unix(c.TimeStamp/1000, 0),
+		Timestamp:   FromMillis(c.TimeStamp),
 	}
 }
 
@@ -101,7 +102,7 @@ func (o OrderMeasurement) AsInfluxMeasurement() InfluxMeasurement {
 		Measurement: o.Meta,
 		Tags:        map[string]string{"pair": o.Pair, "type": o.Type, "platform": o.Platform},
 		Fields:      map[string]interface{}{"price": o.Price, "amount": o.Amount},
-		Timestamp:   time.Unix(o.Timestamp/1000, 0),
+		Timestamp:   FromMillis(o.Timestamp),
 	}
 }
 
@@ -116,6 +117,8 @@ type TradeMeasurement struct {
 	// buy, sell
 	TransactionType string `json:"type"`
 	Timestamp       int64  `json:"time"`
+	// id given by the exchange, empty when it does not give any
+	TradeID string `json:"trade_id,omitempty"`
 }
 
 func (o TradeMeasurement) AsInfluxMeasurement() InfluxMeasurement {
@@ -123,24 +126,119 @@ func (o TradeMeasurement) AsInfluxMeasurement() InfluxMeasurement {
 		Measurement: o.Meta,
 		Tags:        map[string]string{"pair": o.Pair, "platform": o.Platform, "trade_type": o.TradeType, "type": o.TransactionType},
 		Fields:      map[string]interface{}{"price": o.Price, "amount": o.Amount},
-		Timestamp:   time.Unix(o.Timestamp/1000, 0),
+		Timestamp:   FromMillis(o.Timestamp),
 	}
 }
 
-type CrawlerFactory func(writers []DataWriter, pairs []string) (Crawler, error)
+type CrawlerFactory func(writers []DataWriter, cfg CrawlerConfig) (Crawler, error)
 
 type Crawler interface {
 	Loop()
 	Close()
 }
 
+// PairSubscriber is implemented by crawlers able to follow or drop a pair
+// while running, the others have to be restarted to change their pairs
+type PairSubscriber interface {
+	Subscribe(pair string) error
+	Unsubscribe(pair string) error
+}
+
+// pairList holds the pairs of a polling crawler, it is read on every tick and
+// changed by Subscribe and Unsubscribe
+type pairList struct {
+	locker sync.RWMutex
+	pairs  []string
+}
+
+func newPairList(pairs []string) *pairList {
+	return &pairList{pairs: append([]string(nil), pairs...)}
+}
+
+func (l *pairList) list() []string {
+	l.locker.RLock()
+	defer l.locker.RUnlock()
+	return append([]string(nil), l.pairs...)
+}
+
+func (l *pairList) add(pair string) bool {
+	l.locker.Lock()
+	defer l.locker.Unlock()
+	for _, p := range l.pairs {
+		if p == pair {
+			return false
+		}
+	}
+	l.pairs = append(l.pairs, pair)
+	return true
+}
+
+func (l *pairList) remove(pair string) bool {
+	l.locker.Lock()
+	defer l.locker.Unlock()
+	for i, p := range l.pairs {
+		if p == pair {
+			l.pairs = append(l.pairs[:i], l.pairs[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// subscribePair and unsubscribePair implement PairSubscriber for the polling
+// crawlers, mapping holds the pairs the exchange supports
+func subscribePair(l *pairList, mapping map[string]string, platform, pair string) error {
+	if _, ok := mapping[pair]; !ok {
+		return fmt.Errorf("%s does not support pair %s", platform, pair)
+	}
+	if !l.add(pair) {
+		return fmt.Errorf("already subscribed to %s on %s", pair, platform)
+	}
+	log.Infof("subscribed to %s on %s", pair, platform)
+	return nil
+}
+
+func unsubscribePair(l *pairList, platform, pair string) error {
+	if !l.remove(pair) {
+		return fmt.Errorf("not subscribed to %s on %s", pair, platform)
+	}
+	log.Infof("unsubscribed from %s on %s", pair, platform)
+	return nil
+}
+
 type InfluxIngestable interface {
 	AsInfluxMeasurement() InfluxMeasurement
 }
 
 type CrawlerConfig struct {
-	Name  string   `json:"name"`
-	Pairs []string `json:"pairs"`
+	Name      string    `json:"name"`
+	Pairs     []string  `json:"pairs"`
+	Endpoints Endpoints `json:"endpoints"`
+	// gzip compressed json lines file raw frames get recorded to
+	Capture string  `json:"capture"`
+	Options Options `json:"options"`
+}
+
+// Endpoints overrides the exchange urls, mostly useful to run against mock servers
+type Endpoints struct {
+	Rest      string `json:"rest"`
+	Websocket string `json:"websocket"`
+	// pusher application key, only used by bitstamp
+	AppKey string `json:"app_key"`
+}
+
+func (e Endpoints) rest(def string) string {
+	if e.Rest == "" {
+		return def
+	}
+	return strings.TrimSuffix(e.Rest, "/")
+}
+
+func (e Endpoints) websocket(def string) string {
+	if e.Websocket == "" {
+		return def
+	}
+	return strings.TrimSuffix(e.Websocket, "/")
 }
 
 type CustomTime struct {
@@ -204,29 +302,36 @@ func (f *OrderFilter) FilterOrders(orderList []OrderData) []OrderData {
 }
 
 func ReadJson(resp *http.Response, data interface{}) error {
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("invalid status code: %d", resp.StatusCode)
-	}
-	bits, err := ioutil.ReadAll(resp.Body)
+	bits, err := readBody(resp)
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(bits, data)
+	if err = json.Unmarshal(bits, data); err != nil {
+		return &ParseError{Err: err}
+	}
+	return nil
+}
+
+func readBody(resp *http.Response) ([]byte, error) {
+	defer resp.Body.Close()
+	if err := statusError(resp); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(resp.Body)
 }
 
 func Now() int64 {
 	return time.Now().UnixNano() / int64(time.Millisecond)
 }
 
+// FromMillis is the time of a measurement timestamp
+func FromMillis(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
 func KrwUsd() (float64, error) {
-	resp, err := http.Get("https://api.fixer.io/latest?symbols=USD,KRW")
-	if err != nil {
-		return 0, err
-	}
-	defer resp.Body.Close()
 	exc := &ExchangeAnswer{}
-	err = ReadJson(resp, exc)
+	err := restClientFor(fixer).GetJson("https://api.fixer.io/latest?symbols=USD,KRW", exc)
 	if err != nil {
 		return 0, err
 	}
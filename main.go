@@ -1,13 +1,15 @@
 package main
 
 import (
+	"cryptoCrawl/api"
+	"cryptoCrawl/config"
 	"cryptoCrawl/crawler"
 	"cryptoCrawl/storage"
-	"encoding/json"
 	"flag"
 	"fmt"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
-	"io/ioutil"
+	"net/http"
 	"os"
 	"time"
 )
@@ -30,7 +32,22 @@ var (
 	writerFactories = map[string]storage.WriterFactory{
 		"elasticsearch": storage.NewESStorage,
 		"influxdb":      storage.NewInfluxStorage,
+		"influxdb2":     storage.NewInfluxV2Storage,
+		"line":          storage.NewLineStorage,
 		"jline":         storage.NewJsonLineStorage,
+		"postgres":      storage.NewPostgresStorage,
+		"sqlite":        storage.NewSqliteStorage,
+		"kafka":         storage.NewKafkaStorage,
+		"nats":          storage.NewNatsStorage,
+		"csv":           storage.NewCsvStorage,
+		"parquet":       storage.NewParquetStorage,
+		"websocket":     storage.NewWebsocketStorage,
+	}
+	// writers whose data can be read back, by writer name
+	readerFactories = map[string]storage.ReaderFactory{
+		"elasticsearch": storage.NewElasticReader,
+		"influxdb":      storage.NewInfluxReader,
+		"jline":         storage.NewJsonLineReader,
 	}
 )
 
@@ -40,67 +57,148 @@ func (b BasicWriter) Write(d interface{}) {
 	fmt.Printf("%+v\n", d)
 }
 
-type Config struct {
-	CrawlerCFGS []crawler.CrawlerConfig `json:"crawlers"`
-	WriterCFGS  []storage.WriterConfig  `json:"writers"`
-}
-
 type NullWriter struct{}
 
 func (n NullWriter) Write(d interface{}) {}
 
-func getConfig(configFile *string) Config {
-	if configFile == nil || *configFile == "" {
-		panic("error reading config file")
+func getConfig(configFile string) config.Config {
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		log.Fatalf("error loading config %s: %s", configFile, err)
 	}
-	s, err := os.Stat(*configFile)
-	if err != nil || s.IsDir() {
-		panic("error reading config file")
+	if err = config.Validate(cfg); err != nil {
+		log.Fatalf("invalid config %s: %s", configFile, err)
 	}
-	cfg := Config{}
-	cfgBytes, err := ioutil.ReadFile(*configFile)
-	if err != nil {
-		panic("error reading config file")
+	return cfg
+}
+
+func getWriters(cfg config.Config) []crawler.DataWriter {
+	var writers []crawler.DataWriter
+	log.Debugf("parsing writer configs: %+v", cfg.WriterCFGS)
+	for i, k := range config.WriterKeys(cfg) {
+		dataW, err := newWriter(k, cfg.WriterCFGS[i])
+		if err != nil {
+			log.Fatalf("error instantiating writer %s: %s", k, err)
+		}
+		writers = append(writers, dataW)
+	}
+	return writers
+}
+
+func replay(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	configFile := fs.String("config", "config.json", "config file in json, yaml or toml format")
+	capture := fs.String("capture", "", "capture file recorded by a crawler")
+	speed := fs.Float64("speed", 1, "speed factor relative to the recording, 0 replays as fast as possible")
+	linger := fs.Duration("linger", 15*time.Second, "time given to the writers to flush before exiting")
+	fs.Parse(args)
+	if *capture == "" {
+		log.Fatalf("capture file not present")
 	}
-	err = json.Unmarshal(cfgBytes, &cfg)
+	mainCfg := getConfig(*configFile)
+	f, err := os.Open(*capture)
 	if err != nil {
-		panic("error unmarshaling config")
+		log.Fatalf("error opening capture %s: %s", *capture, err)
+	}
+	defer f.Close()
+	writers := getWriters(mainCfg)
+	if err = crawler.Replay(f, writers, mainCfg.CrawlerCFGS, *speed); err != nil {
+		log.Fatalf("error replaying %s: %s", *capture, err)
+	}
+	log.Infof("replay done, waiting %s for writers to flush", *linger)
+	time.Sleep(*linger)
+}
+
+func validateConfig(args []string) {
+	fs := flag.NewFlagSet("validate-config", flag.ExitOnError)
+	configFile := fs.String("config", "config.json", "config file in json, yaml or toml format")
+	fs.Parse(args)
+	cfg, err := config.Load(*configFile)
+	if err == nil {
+		err = config.Validate(cfg)
+	}
+	if errs, ok := err.(config.Errors); ok {
+		for _, e := range errs {
+			fmt.Fprintln(os.Stderr, e)
+		}
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s is valid: %d crawlers, %d writers\n", *configFile, len(cfg.CrawlerCFGS), len(cfg.WriterCFGS))
+}
+
+func serveAPI(args []string) {
+	fs := flag.NewFlagSet("api", flag.ExitOnError)
+	configFile := fs.String("config", "config.json", "config file in json, yaml or toml format")
+	addr := fs.String("addr", ":8080", "address to listen on")
+	writer := fs.String("writer", "", "writer of the config to read from, the first one that can be read by default")
+	fs.Parse(args)
+	mainCfg := getConfig(*configFile)
+	var reader storage.Reader
+	for _, w := range mainCfg.WriterCFGS {
+		factory, ok := readerFactories[w.Name]
+		if !ok || (*writer != "" && w.Name != *writer) {
+			continue
+		}
+		r, err := factory(w.Params)
+		if err != nil {
+			log.Fatalf("error instantiating reader %s: %s", w.Name, err)
+		}
+		reader = r
+		log.Infof("reading from writer %s", w.Name)
+		break
+	}
+	if reader == nil {
+		log.Fatalf("no writer to read from in %s", *configFile)
+	}
+	log.Infof("serving api on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, api.NewServer(reader)))
+}
+
+// serveMetrics exposes the crawler and writer metrics on /metrics for prometheus
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	log.Infof("serving metrics on %s/metrics", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Fatalf("error serving metrics: %s", err)
 	}
-	return cfg
 }
 
 func main() {
-	configFile := flag.String("config", "config.json", "config file in json format")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "replay":
+			replay(os.Args[2:])
+			return
+		case "validate-config":
+			validateConfig(os.Args[2:])
+			return
+		case "api":
+			serveAPI(os.Args[2:])
+			return
+		}
+	}
+	configFile := flag.String("config", "config.json", "config file in json, yaml or toml format")
 	crawlerName := flag.String("crawler", "", "crawler to start")
+	watch := flag.Duration("watch", 2*time.Second, "how often the config file is checked for changes, 0 only reloads on SIGHUP")
+	metricsAddr := flag.String("metrics", "", "address serving prometheus metrics on /metrics, e.g. :9102, none by default")
 	flag.Parse()
 	if *crawlerName == "" {
 		log.Fatalf("crawler name not present")
 		return
 	}
-	mainCfg := getConfig(configFile)
+	mainCfg := getConfig(*configFile)
 	log.Debugf("working with config %+v", mainCfg)
-	if cf, ok := crawlerFactories[*crawlerName]; ok {
-		for _, cfg := range mainCfg.CrawlerCFGS {
-			if cfg.Name == *crawlerName {
-				var writers []crawler.DataWriter
-				log.Debugf("parsing writer configs: %+v", mainCfg.WriterCFGS)
-				for _, v := range mainCfg.WriterCFGS {
-					log.Debugf("searching factory config for %s", v.Name)
-					if wrf, ok := writerFactories[v.Name]; ok {
-						log.Debugf("adding new writer %s", v.Name)
-						dataW, err := wrf(v.Params)
-						if err != nil {
-							log.Fatalf("error instantiating writer %s: %s", v.Name, err)
-						}
-						writers = append(writers, dataW)
-					}
-				}
-				crawl, err := cf(writers, cfg.Pairs)
-				if err != nil {
-					log.Fatalf("error creating crawler with name %s: %s", cfg.Name, err)
-				}
-				crawl.Loop()
-			}
-		}
+	if _, ok := crawlerFactories[*crawlerName]; !ok {
+		log.Fatalf("unknown crawler %s", *crawlerName)
+	}
+	if *metricsAddr != "" {
+		go serveMetrics(*metricsAddr)
 	}
+	r := newRunner(*configFile, *crawlerName, mainCfg)
+	r.start()
+	r.watch(*watch)
 }
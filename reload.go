@@ -0,0 +1,285 @@
+package main
+
+import (
+	"cryptoCrawl/config"
+	"cryptoCrawl/crawler"
+	"cryptoCrawl/storage"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// runner keeps the crawlers named name and every writer of the config
+// running, applying config changes without restarting the process
+type runner struct {
+	locker   sync.Mutex
+	path     string
+	name     string
+	cfg      config.Config
+	fanout   *storage.Fanout
+	crawlers map[string]crawler.Crawler
+}
+
+func newRunner(path, name string, cfg config.Config) *runner {
+	return &runner{
+		path:     path,
+		name:     name,
+		cfg:      cfg,
+		fanout:   storage.NewFanout(),
+		crawlers: map[string]crawler.Crawler{},
+	}
+}
+
+func (r *runner) start() {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	for i, k := range config.WriterKeys(r.cfg) {
+		w, err := newWriter(k, r.cfg.WriterCFGS[i])
+		if err != nil {
+			log.Fatalf("error instantiating writer %s: %s", k, err)
+		}
+		r.fanout.Add(k, w)
+	}
+	for i, k := range config.CrawlerKeys(r.cfg) {
+		cfg := r.cfg.CrawlerCFGS[i]
+		if cfg.Name != r.name {
+			continue
+		}
+		if err := r.startCrawler(k, cfg); err != nil {
+			log.Fatalf("error creating crawler with name %s: %s", cfg.Name, err)
+		}
+	}
+}
+
+func (r *runner) startCrawler(key string, cfg crawler.CrawlerConfig) error {
+	cf, ok := crawlerFactories[cfg.Name]
+	if !ok {
+		return fmt.Errorf("unknown crawler %s", cfg.Name)
+	}
+	crawl, err := cf([]crawler.DataWriter{r.fanout}, cfg)
+	if err != nil {
+		return err
+	}
+	r.crawlers[key] = crawl
+	go crawl.Loop()
+	return nil
+}
+
+// newWriter puts a writer behind its own queue and routing rules, with a
+// spool for the batches it fails to deliver
+func newWriter(key string, cfg storage.WriterConfig) (storage.DataWriter, error) {
+	w, err := buildWriter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return wrapWriter(key, w, cfg)
+}
+
+func buildWriter(cfg storage.WriterConfig) (storage.DataWriter, error) {
+	wrf, ok := writerFactories[cfg.Name]
+	if !ok {
+		return nil, fmt.Errorf("unknown writer %s", cfg.Name)
+	}
+	return wrf(cfg.Params)
+}
+
+// wrapWriter attaches the spool, queue and route of cfg to w, w is closed
+// when that fails
+func wrapWriter(key string, w storage.DataWriter, cfg storage.WriterConfig) (storage.DataWriter, error) {
+	var err error
+	if cfg.Spool.Dir != "" {
+		var sw storage.DataWriter
+		if sw, err = storage.AttachSpool(key, w, cfg.Spool); err == nil {
+			w = sw
+		}
+	}
+	var q *storage.Queue
+	if err == nil {
+		q, err = storage.NewQueue(key, w, cfg.Queue)
+	}
+	if err != nil {
+		if c, ok := w.(storage.Closer); ok {
+			c.Close()
+		}
+		return nil, err
+	}
+	return storage.NewRoute(q, cfg), nil
+}
+
+// watch reloads the config on SIGHUP and whenever the file gets modified,
+// a zero period only reloads on SIGHUP
+func (r *runner) watch(period time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	var tick <-chan time.Time
+	if period > 0 {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	modified := modTime(r.path)
+	for {
+		select {
+		case <-hup:
+			log.Infof("got SIGHUP, reloading %s", r.path)
+			r.reload()
+		case <-tick:
+			if m := modTime(r.path); !m.Equal(modified) {
+				modified = m
+				log.Infof("%s changed, reloading", r.path)
+				r.reload()
+			}
+		}
+	}
+}
+
+func modTime(path string) time.Time {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+// reload applies the difference between the running and the new config, an
+// invalid config is ignored and the running one is kept
+func (r *runner) reload() {
+	cfg, err := config.Load(r.path)
+	if err == nil {
+		err = config.Validate(cfg)
+	}
+	if err != nil {
+		log.Errorf("keeping the running config, %s is invalid: %s", r.path, err)
+		return
+	}
+	r.locker.Lock()
+	diff := config.Compare(r.cfg, cfg)
+	if diff.Empty() {
+		r.locker.Unlock()
+		log.Infof("no changes in %s", r.path)
+		return
+	}
+	for _, d := range diff.Writers {
+		log.Infof("applying %s", d)
+		r.applyWriter(d)
+	}
+	var stopped []crawler.Crawler
+	var started []config.CrawlerDiff
+	for _, d := range diff.Crawlers {
+		c := d.New
+		if c == nil {
+			c = d.Old
+		}
+		if c.Name != r.name {
+			log.Debugf("ignoring %s, crawler %s runs in another process", d, c.Name)
+			continue
+		}
+		log.Infof("applying %s", d)
+		stop, start := r.applyCrawler(d)
+		if stop != nil {
+			stopped = append(stopped, stop)
+		}
+		if start {
+			started = append(started, d)
+		}
+	}
+	r.cfg = cfg
+	r.locker.Unlock()
+	// crawlers are stopped without the lock, a restarted one only starts once
+	// the previous one let go of its capture file
+	for _, c := range stopped {
+		c.Close()
+	}
+	if len(started) == 0 {
+		return
+	}
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	for _, d := range started {
+		if err := r.startCrawler(d.Key, *d.New); err != nil {
+			log.Errorf("error starting crawler %s: %s", d.Key, err)
+		}
+	}
+}
+
+// sharesFiles tells whether two configs of a writer use the same spill file
+// or spool, which can only be opened by one writer at a time
+func sharesFiles(old, new *storage.WriterConfig) bool {
+	spill := old.Queue.Policy == storage.Spill && new.Queue.Policy == storage.Spill && old.Queue.SpillDir == new.Queue.SpillDir
+	return spill || (old.Spool.Dir != "" && old.Spool.Dir == new.Spool.Dir)
+}
+
+// applyWriter replaces a writer once its replacement is built, the previous
+// one keeps running when that fails
+func (r *runner) applyWriter(d config.WriterDiff) {
+	if d.New == nil {
+		r.fanout.Close(d.Key)
+		return
+	}
+	w, err := buildWriter(*d.New)
+	if err != nil {
+		log.Errorf("error instantiating writer %s, keeping the previous one: %s", d.Key, err)
+		return
+	}
+	if d.Old == nil || !sharesFiles(d.Old, d.New) {
+		wrapped, err := wrapWriter(d.Key, w, *d.New)
+		if err != nil {
+			log.Errorf("error instantiating writer %s, keeping the previous one: %s", d.Key, err)
+			return
+		}
+		if old, ok := r.fanout.Add(d.Key, wrapped).(storage.Closer); ok {
+			old.Close()
+		}
+		return
+	}
+	// the previous writer has to let go of its files first, it is brought
+	// back when the new one can not open them
+	r.fanout.Close(d.Key)
+	wrapped, err := wrapWriter(d.Key, w, *d.New)
+	if err != nil {
+		log.Errorf("error instantiating writer %s, restoring the previous one: %s", d.Key, err)
+		if wrapped, err = newWriter(d.Key, *d.Old); err != nil {
+			log.Errorf("error restoring writer %s, it is stopped: %s", d.Key, err)
+			return
+		}
+	}
+	r.fanout.Add(d.Key, wrapped)
+}
+
+// applyCrawler updates the pairs of a running crawler when it can, otherwise
+// it returns the crawler to stop and whether the new one has to be started
+func (r *runner) applyCrawler(d config.CrawlerDiff) (crawler.Crawler, bool) {
+	crawl, running := r.crawlers[d.Key]
+	if d.New == nil {
+		if running {
+			delete(r.crawlers, d.Key)
+			return crawl, false
+		}
+		return nil, false
+	}
+	if running && len(d.Changed) == 0 {
+		if ps, ok := crawl.(crawler.PairSubscriber); ok {
+			for _, p := range d.RemovedPairs {
+				if err := ps.Unsubscribe(p); err != nil {
+					log.Errorf("error unsubscribing %s from %s: %s", d.Key, p, err)
+				}
+			}
+			for _, p := range d.AddedPairs {
+				if err := ps.Subscribe(p); err != nil {
+					log.Errorf("error subscribing %s to %s: %s", d.Key, p, err)
+				}
+			}
+			return nil, false
+		}
+	}
+	if running {
+		log.Infof("restarting crawler %s", d.Key)
+		delete(r.crawlers, d.Key)
+		return crawl, true
+	}
+	return nil, true
+}
@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBatchSize   = 5000
+	defaultBatchBytes  = 5 << 20
+	defaultMaxInFlight = 4
+)
+
+// PartialError is returned by a BatchWriter that delivered only part of a
+// batch, Failed holds what is left to retry
+type PartialError struct {
+	Failed []interface{}
+	Err    error
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("%d items failed: %s", len(e.Failed), e.Err)
+}
+
+// batchConfig tells when a batch gets flushed, whichever limit comes first
+type batchConfig struct {
+	maxItems int
+	maxBytes int
+	maxAge   time.Duration
+	// flushes running at once, further flushes wait for one to finish
+	maxInFlight int
+}
+
+func parseBatchConfig(params map[string]string) batchConfig {
+	return batchConfig{
+		maxItems:    parseInt(params, "batch_size", defaultBatchSize),
+		maxBytes:    parseInt(params, "batch_bytes", defaultBatchBytes),
+		maxAge:      parsePeriod(params),
+		maxInFlight: parseInt(params, "max_in_flight", defaultMaxInFlight),
+	}
+}
+
+func parseInt(params map[string]string, name string, def int) int {
+	v, err := strconv.Atoi(params[name])
+	if err != nil || v <= 0 {
+		return def
+	}
+	return v
+}
+
+// batcher accumulates the items of an Ingest loop and hands full batches to
+// flush in their own goroutine
+type batcher struct {
+	cfg   batchConfig
+	size  func(interface{}) int
+	flush func([]interface{})
+	slots chan struct{}
+	wg    sync.WaitGroup
+	items []interface{}
+	bytes int
+	timer *time.Timer
+	// set by writers that can be spooled, while it returns true batches go
+	// one at a time so a failed one is spooled before the next is written
+	serial func() bool
+}
+
+func newBatcher(cfg batchConfig, size func(interface{}) int, flush func([]interface{})) *batcher {
+	return &batcher{
+		cfg:   cfg,
+		size:  size,
+		flush: flush,
+		slots: make(chan struct{}, cfg.maxInFlight),
+	}
+}
+
+func (b *batcher) add(d interface{}) {
+	n := b.size(d)
+	if len(b.items) > 0 && b.bytes+n > b.cfg.maxBytes {
+		b.send()
+	}
+	if len(b.items) == 0 {
+		b.timer = time.NewTimer(b.cfg.maxAge)
+	}
+	b.items = append(b.items, d)
+	b.bytes += n
+	if len(b.items) >= b.cfg.maxItems || b.bytes >= b.cfg.maxBytes {
+		b.send()
+	}
+}
+
+// expired fires once the oldest item of the batch reaches the max age
+func (b *batcher) expired() <-chan time.Time {
+	if b.timer == nil {
+		return nil
+	}
+	return b.timer.C
+}
+
+// send flushes the current batch, blocking while too many flushes are running
+func (b *batcher) send() {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.items) == 0 {
+		return
+	}
+	items := b.items
+	b.items, b.bytes = nil, 0
+	b.run(func() { b.flush(items) })
+}
+
+// run calls f in its own goroutine once a flush slot is free
+func (b *batcher) run(f func()) {
+	if b.serial != nil && b.serial() {
+		b.wg.Wait()
+	}
+	b.slots <- struct{}{}
+	b.wg.Add(1)
+	go func() {
+		defer func() {
+			<-b.slots
+			b.wg.Done()
+		}()
+		f()
+	}()
+}
+
+// close flushes what is left and waits for every flush to finish
+func (b *batcher) close() {
+	b.send()
+	b.wg.Wait()
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBatcherLimits(t *testing.T) {
+	var locker sync.Mutex
+	var sizes []int
+	flush := func(items []interface{}) {
+		locker.Lock()
+		defer locker.Unlock()
+		sizes = append(sizes, len(items))
+	}
+	size := func(interface{}) int { return 10 }
+
+	b := newBatcher(batchConfig{maxItems: 3, maxBytes: 1000, maxAge: time.Hour, maxInFlight: 1}, size, flush)
+	for i := 0; i < 7; i++ {
+		b.add(i)
+	}
+	b.close()
+	if len(sizes) != 3 || sizes[0] != 3 || sizes[1] != 3 || sizes[2] != 1 {
+		t.Errorf("expected batches of 3, 3 and 1 items, got %v", sizes)
+	}
+
+	sizes = nil
+	b = newBatcher(batchConfig{maxItems: 100, maxBytes: 25, maxAge: time.Hour, maxInFlight: 1}, size, flush)
+	for i := 0; i < 5; i++ {
+		b.add(i)
+	}
+	b.close()
+	if len(sizes) != 3 || sizes[0] != 2 || sizes[1] != 2 || sizes[2] != 1 {
+		t.Errorf("expected batches of 2, 2 and 1 items, got %v", sizes)
+	}
+
+	sizes = nil
+	b = newBatcher(batchConfig{maxItems: 100, maxBytes: 1000, maxAge: 10 * time.Millisecond, maxInFlight: 1}, size, flush)
+	b.add(1)
+	select {
+	case <-b.expired():
+		b.send()
+	case <-time.After(time.Second):
+		t.Fatal("expected the batch to expire")
+	}
+	b.close()
+	if len(sizes) != 1 || sizes[0] != 1 {
+		t.Errorf("expected a single batch flushed on age, got %v", sizes)
+	}
+}
+
+func TestBatcherInFlight(t *testing.T) {
+	var running, peak int32
+	gate := make(chan struct{})
+	flush := func(items []interface{}) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		<-gate
+		atomic.AddInt32(&running, -1)
+	}
+	b := newBatcher(batchConfig{maxItems: 1, maxBytes: 1000, maxAge: time.Hour, maxInFlight: 2}, func(interface{}) int { return 1 }, flush)
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 5; i++ {
+			b.add(i)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("expected the batcher to wait for a flush to finish")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(gate)
+	<-done
+	b.close()
+	if peak != 2 {
+		t.Errorf("expected at most 2 flushes at once, got %d", peak)
+	}
+}
+
+func TestBatcherSerial(t *testing.T) {
+	var locker sync.Mutex
+	var order []int
+	var running, peak int32
+	flush := func(items []interface{}) {
+		if n := atomic.AddInt32(&running, 1); n > atomic.LoadInt32(&peak) {
+			atomic.StoreInt32(&peak, n)
+		}
+		time.Sleep(5 * time.Millisecond)
+		locker.Lock()
+		order = append(order, items[0].(int))
+		locker.Unlock()
+		atomic.AddInt32(&running, -1)
+	}
+	b := newBatcher(batchConfig{maxItems: 1, maxBytes: 1000, maxAge: time.Hour, maxInFlight: 4}, func(interface{}) int { return 1 }, flush)
+	b.serial = func() bool { return true }
+	for i := 0; i < 5; i++ {
+		b.add(i)
+	}
+	b.close()
+	if peak != 1 {
+		t.Errorf("expected a single flush at once, got %d", peak)
+	}
+	for i, n := range order {
+		if n != i {
+			t.Fatalf("expected batches in order, got %v", order)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"strings"
+)
+
+const defaultTopic = "crypto.{meta}.{platform}.{pair}"
+
+// busMessage is a measurement ready to be published to a message bus
+type busMessage struct {
+	topic string
+	// messages of a pair share a key, and a partition
+	key   []byte
+	value []byte
+	item  interface{}
+}
+
+// busConfig tells where a bus writer publishes and how it encodes
+type busConfig struct {
+	// template like {meta}.{platform}, see renderTemplate
+	topic    string
+	encoding string
+}
+
+func parseBusConfig(params map[string]string, topicParam string) (busConfig, error) {
+	cfg := busConfig{topic: defaultTopic, encoding: JSONEncoding}
+	if t, ok := params[topicParam]; ok {
+		cfg.topic = t
+	}
+	if e, ok := params["encoding"]; ok {
+		if err := checkEncoding(e); err != nil {
+			return cfg, err
+		}
+		cfg.encoding = e
+	}
+	return cfg, nil
+}
+
+func (cfg busConfig) message(d interface{}) (busMessage, error) {
+	value, err := marshalEnvelope(d, cfg.encoding)
+	if err != nil {
+		return busMessage{}, err
+	}
+	m, _ := attributes(d)
+	return busMessage{
+		topic: renderTemplate(cfg.topic, d),
+		key:   []byte(m.platform + ":" + m.pair),
+		value: value,
+		item:  d,
+	}, nil
+}
+
+func (cfg busConfig) contentType() string {
+	return contentTypes[cfg.encoding]
+}
+
+// messages encodes a batch, what can't be encoded is logged and dropped
+func (cfg busConfig) messages(data []interface{}) ([]busMessage, []error) {
+	res := make([]busMessage, 0, len(data))
+	var errs []error
+	for _, d := range data {
+		m, err := cfg.message(d)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		res = append(res, m)
+	}
+	return res, errs
+}
+
+func splitList(v string) []string {
+	var res []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			res = append(res, s)
+		}
+	}
+	return res
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"cryptoCrawl/crawler"
+	"encoding/json"
+	"fmt"
+	"github.com/segmentio/kafka-go"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeKafka stands in for the brokers, refusing the messages of a topic
+type fakeKafka struct {
+	sync.Mutex
+	refused  string
+	messages []kafka.Message
+}
+
+func (f *fakeKafka) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	f.Lock()
+	defer f.Unlock()
+	var errs kafka.WriteErrors
+	for _, m := range msgs {
+		if m.Topic == f.refused {
+			errs = append(errs, kafka.UnknownTopicOrPartition)
+			continue
+		}
+		errs = append(errs, nil)
+		f.messages = append(f.messages, m)
+	}
+	if errs.Count() > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (f *fakeKafka) Close() error { return nil }
+
+// fakeNats stands in for a nats server, failing after some publishes
+type fakeNats struct {
+	sync.Mutex
+	failAfter int
+	subjects  []string
+	flushes   int
+}
+
+func (f *fakeNats) Publish(subject string, data []byte) error {
+	f.Lock()
+	defer f.Unlock()
+	if f.failAfter >= 0 && len(f.subjects) >= f.failAfter {
+		return fmt.Errorf("connection closed")
+	}
+	f.subjects = append(f.subjects, subject)
+	return nil
+}
+
+func (f *fakeNats) FlushTimeout(time.Duration) error {
+	f.flushes++
+	return nil
+}
+
+func (f *fakeNats) Close() {}
+
+var busTrades = []interface{}{
+	crawler.TradeMeasurement{Meta: "trade", Platform: "binance", Pair: "BTCUSD", Price: 1, Timestamp: 1517443200000},
+	crawler.TradeMeasurement{Meta: "trade", Platform: "bitstamp", Pair: "BTCUSD", Price: 2, Timestamp: 1517443200000},
+	Projection{Measurement: crawler.TradeMeasurement{Meta: "trade", Platform: "binance", Pair: "ETHBTC", Price: 3}, Fields: []string{"price"}},
+}
+
+func TestKafkaStorage(t *testing.T) {
+	bus, _ := parseBusConfig(map[string]string{}, "topic")
+	fake := &fakeKafka{refused: "crypto.trade.bitstamp.BTCUSD"}
+	k := newKafkaStorage(fake, bus, parseBatchConfig(nil))
+	defer k.Close()
+
+	err := k.WriteBatch(busTrades)
+	partial, ok := err.(*PartialError)
+	if !ok || len(partial.Failed) != 1 || !reflect.DeepEqual(partial.Failed[0], busTrades[1]) {
+		t.Fatalf("expected the bitstamp trade to fail, got %v", err)
+	}
+	if len(fake.messages) != 2 || string(fake.messages[0].Key) != "binance:BTCUSD" || fake.messages[1].Topic != "crypto.trade.binance.ETHBTC" {
+		t.Fatalf("unexpected messages %+v", fake.messages)
+	}
+	if fake.messages[0].Time.UnixNano() != 1517443200000*int64(time.Millisecond) {
+		t.Errorf("expected the message time to be the trade time, got %s", fake.messages[0].Time)
+	}
+	var e Envelope
+	if err := json.Unmarshal(fake.messages[1].Value, &e); err != nil {
+		t.Fatal(err)
+	}
+	d, err := e.Measurement()
+	if err != nil || !reflect.DeepEqual(d, busTrades[2]) {
+		t.Errorf("expected the projection back, got %+v %v", d, err)
+	}
+	e.Version = EnvelopeVersion + 1
+	if _, err := e.Measurement(); err == nil {
+		t.Error("expected a later envelope version to be refused")
+	}
+}
+
+func TestNatsStorage(t *testing.T) {
+	bus, _ := parseBusConfig(map[string]string{"subject": "{platform}.{pair}"}, "subject")
+	fake := &fakeNats{failAfter: 1}
+	n := newNatsStorage(fake, bus, parseBatchConfig(nil))
+	defer n.Close()
+
+	err := n.WriteBatch(busTrades)
+	partial, ok := err.(*PartialError)
+	if !ok || len(partial.Failed) != 2 || !reflect.DeepEqual(partial.Failed[0], busTrades[1]) {
+		t.Fatalf("expected the last 2 trades to fail, got %v", err)
+	}
+	fake.failAfter = -1
+	if err := n.WriteBatch(partial.Failed); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fake.subjects, []string{"binance.BTCUSD", "bitstamp.BTCUSD", "binance.ETHBTC"}) || fake.flushes != 1 {
+		t.Errorf("unexpected subjects %v and flushes %d", fake.subjects, fake.flushes)
+	}
+}
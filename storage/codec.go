@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/vmihailenco/msgpack/v5"
+	"io"
+)
+
+const (
+	JSONEncoding    = "json"
+	MsgpackEncoding = "msgpack"
+)
+
+var (
+	encodings    = []string{JSONEncoding, MsgpackEncoding}
+	contentTypes = map[string]string{JSONEncoding: "application/json", MsgpackEncoding: "application/x-msgpack"}
+)
+
+// msgpackEnvelope is the binary Envelope, the measurement in it is encoded
+// with the keys of its json encoding
+type msgpackEnvelope struct {
+	Version int                `msgpack:"version"`
+	Type    string             `msgpack:"type"`
+	Fields  []string           `msgpack:"fields,omitempty"`
+	Data    msgpack.RawMessage `msgpack:"data"`
+}
+
+// marshalEnvelope encodes the envelope of a measurement
+func marshalEnvelope(d interface{}, encoding string) ([]byte, error) {
+	e, err := NewEnvelope(d)
+	if err != nil {
+		return nil, err
+	}
+	if encoding != MsgpackEncoding {
+		return json.Marshal(e)
+	}
+	if p, ok := d.(Projection); ok {
+		d = p.Measurement
+	}
+	data, err := marshalMsgpack(d)
+	if err != nil {
+		return nil, err
+	}
+	return marshalMsgpack(msgpackEnvelope{Version: e.Version, Type: e.Type, Fields: e.Fields, Data: data})
+}
+
+// marshalRecord encodes a measurement as a record of a file, a json line or
+// a msgpack envelope
+func marshalRecord(d interface{}, encoding string) ([]byte, error) {
+	if encoding == MsgpackEncoding {
+		return marshalEnvelope(d, encoding)
+	}
+	bits, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	return append(bits, '\n'), nil
+}
+
+func marshalMsgpack(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	err := enc.Encode(v)
+	return buf.Bytes(), err
+}
+
+func unmarshalMsgpack(bits []byte, v interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(bits))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+// Decoder reads back what the writers encoded: json envelopes or lines, one
+// after the other, or msgpack envelopes
+type Decoder struct {
+	json    *json.Decoder
+	msgpack *msgpack.Decoder
+}
+
+func NewDecoder(r io.Reader, encoding string) (*Decoder, error) {
+	switch encoding {
+	case JSONEncoding:
+		return &Decoder{json: json.NewDecoder(r)}, nil
+	case MsgpackEncoding:
+		return &Decoder{msgpack: msgpack.NewDecoder(r)}, nil
+	}
+	return nil, checkEncoding(encoding)
+}
+
+// Decode returns the next measurement, io.EOF once there is none left
+func (d *Decoder) Decode() (interface{}, error) {
+	if d.msgpack != nil {
+		var e msgpackEnvelope
+		if err := d.msgpack.Decode(&e); err != nil {
+			return nil, err
+		}
+		if e.Version < 1 || e.Version > EnvelopeVersion {
+			return nil, fmt.Errorf("unsupported envelope version %d", e.Version)
+		}
+		return decodeTyped(e.Type, e.Fields, func(v interface{}) error { return unmarshalMsgpack(e.Data, v) })
+	}
+	var raw json.RawMessage
+	if err := d.json.Decode(&raw); err != nil {
+		return nil, err
+	}
+	return decodeJSON(raw)
+}
+
+// decodeJSON decodes an envelope or a bare measurement, whose type is told
+// by its meta
+func decodeJSON(raw []byte) (interface{}, error) {
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &keys); err != nil {
+		return nil, err
+	}
+	if _, ok := keys["version"]; ok {
+		var e Envelope
+		if err := json.Unmarshal(raw, &e); err != nil {
+			return nil, err
+		}
+		return e.Measurement()
+	}
+	var meta string
+	json.Unmarshal(keys["meta"], &meta)
+	typ := meta
+	if _, ok := keys["amount"]; meta == "cancel" && ok {
+		// cancels with an amount are orders
+		typ = "order"
+	}
+	return decodeTyped(typ, nil, func(v interface{}) error { return json.Unmarshal(raw, v) })
+}
+
+func checkEncoding(v string) error {
+	if !contains(encodings, v) {
+		return fmt.Errorf("unknown encoding %s, use one of %v", v, encodings)
+	}
+	return nil
+}
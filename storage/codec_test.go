@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"bytes"
+	"cryptoCrawl/crawler"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var codecMeasurements = []interface{}{
+	crawler.TradeMeasurement{Meta: "trade", Platform: "binance", Pair: "BTCUSD", TransactionType: "buy", Amount: 0.1, Price: 10000.5, Timestamp: 1517443200000, TradeID: "42"},
+	crawler.OrderMeasurement{Meta: "cancel", Platform: "poloniex", Pair: "ETHBTC", Type: "sell", Amount: 2, Price: 0.1, Timestamp: 1517443200001},
+	crawler.CancelMeasurement{Meta: "cancel", Platform: "bitstamp", Pair: "BTCEUR", Type: "buy", Price: 9000, TimeStamp: 1517443200002},
+	Projection{Measurement: crawler.TradeMeasurement{Meta: "trade", Platform: "binance", Price: 1}, Fields: []string{"price"}},
+}
+
+func TestCodecs(t *testing.T) {
+	for _, encoding := range encodings {
+		var buf bytes.Buffer
+		for _, d := range codecMeasurements {
+			bits, err := marshalEnvelope(d, encoding)
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf.Write(bits)
+		}
+		dec, err := NewDecoder(&buf, encoding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, expected := range codecMeasurements {
+			d, err := dec.Decode()
+			if err != nil || !reflect.DeepEqual(d, expected) {
+				t.Errorf("%s: expected %+v, got %+v %v", encoding, expected, d, err)
+			}
+		}
+		if _, err := dec.Decode(); err != io.EOF {
+			t.Errorf("%s: expected the end of the stream, got %v", encoding, err)
+		}
+	}
+
+	jsonBits, _ := json.Marshal(codecMeasurements[0])
+	msgpackBits, _ := marshalEnvelope(codecMeasurements[0], MsgpackEncoding)
+	if len(msgpackBits) >= len(jsonBits) {
+		t.Errorf("expected msgpack to be smaller than json, got %d and %d bytes", len(msgpackBits), len(jsonBits))
+	}
+}
+
+func TestDecodeJSONLines(t *testing.T) {
+	var lines []string
+	for _, d := range codecMeasurements[:3] {
+		bits, _ := marshalRecord(d, JSONEncoding)
+		lines = append(lines, string(bits))
+	}
+	dec, _ := NewDecoder(strings.NewReader(strings.Join(lines, "")), JSONEncoding)
+	for _, expected := range codecMeasurements[:3] {
+		if d, err := dec.Decode(); err != nil || !reflect.DeepEqual(d, expected) {
+			t.Errorf("expected %+v, got %+v %v", expected, d, err)
+		}
+	}
+	if _, err := NewDecoder(nil, "xml"); err == nil {
+		t.Error("expected an unknown encoding to be refused")
+	}
+}
@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"bytes"
+	"cryptoCrawl/crawler"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// time formats of the csv writer besides go layouts
+	Millis  = "millis"
+	RFC3339 = "rfc3339"
+)
+
+var errCsvMeta = fmt.Errorf("csv path should contain {meta}, every meta has its own columns")
+
+// csvColumns are the headers of the file of each meta, fields missing from
+// a measurement are left empty
+var csvColumns = map[string][]string{
+	"trade":  {"time", "platform", "pair", "trade_type", "type", "amount", "price", "trade_id"},
+	"order":  {"time", "platform", "pair", "type", "amount", "price"},
+	"cancel": {"time", "platform", "pair", "type", "amount", "price"},
+}
+
+// CsvStorage writes a csv file per meta, its path template should contain {meta}
+type CsvStorage struct {
+	files      *rotatingFiles
+	delimiter  rune
+	timeFormat string
+	period     time.Duration
+	dataChan   chan interface{}
+	closeChan  chan bool
+	done       chan struct{}
+}
+
+func NewCsvStorage(params map[string]string) (DataWriter, error) {
+	cfg, err := parseRotateConfig(params)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(cfg.path, "{meta}") {
+		return nil, errCsvMeta
+	}
+	writer := &CsvStorage{
+		delimiter:  ',',
+		timeFormat: Millis,
+		period:     parsePeriod(params),
+		dataChan:   make(chan interface{}, 10000),
+		closeChan:  make(chan bool),
+		done:       make(chan struct{}),
+	}
+	if d, ok := params["delimiter"]; ok {
+		if err := checkDelimiter(d); err != nil {
+			return nil, err
+		}
+		writer.delimiter = delimiter(d)
+	}
+	if f, ok := params["time_format"]; ok {
+		if err := checkTimeFormat(f); err != nil {
+			return nil, err
+		}
+		writer.timeFormat = f
+	}
+	writer.files = newRotatingFiles(cfg, writer.header)
+	log.Infof("successfully created new CSV writer with path %s", cfg.path)
+	go writer.Ingest()
+	return writer, nil
+}
+
+func (w *CsvStorage) Write(d interface{}) {
+	w.dataChan <- d
+}
+
+func (w *CsvStorage) Ingest() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.period)
+	defer ticker.Stop()
+	for {
+		select {
+		case d := <-w.dataChan:
+			w.write(d)
+		case <-ticker.C:
+			w.files.flush()
+		case <-w.closeChan:
+			for len(w.dataChan) > 0 {
+				w.write(<-w.dataChan)
+			}
+			w.files.close()
+			return
+		}
+	}
+}
+
+func (w *CsvStorage) write(d interface{}) {
+	bits, err := w.row(d)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	if err := w.files.write(d, bits); err != nil {
+		log.Error(err)
+	}
+}
+
+func (w *CsvStorage) header(d interface{}) []byte {
+	m, _ := attributes(d)
+	return w.encode(csvColumns[m.meta])
+}
+
+// row encodes the columns of the meta of d, in header order
+func (w *CsvStorage) row(d interface{}) ([]byte, error) {
+	m, ok := attributes(d)
+	if !ok || csvColumns[m.meta] == nil {
+		return nil, fmt.Errorf("csv writer can't write %T", d)
+	}
+	bits, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(bits, &fields); err != nil {
+		return nil, err
+	}
+	columns := csvColumns[m.meta]
+	record := make([]string, len(columns))
+	for i, c := range columns {
+		// like in influx, projections keep the time
+		if c == "time" {
+			record[i] = w.formatTime(m.time)
+			continue
+		}
+		v, ok := fields[c]
+		if !ok {
+			continue
+		}
+		var s string
+		if json.Unmarshal(v, &s) == nil {
+			record[i] = s
+		} else {
+			record[i] = string(v)
+		}
+	}
+	return w.encode(record), nil
+}
+
+func (w *CsvStorage) encode(record []string) []byte {
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	cw.Comma = w.delimiter
+	cw.Write(record)
+	cw.Flush()
+	return buf.Bytes()
+}
+
+func (w *CsvStorage) formatTime(ms int64) string {
+	switch w.timeFormat {
+	case Millis:
+		return strconv.FormatInt(ms, 10)
+	case RFC3339:
+		return crawler.FromMillis(ms).UTC().Format("2006-01-02T15:04:05.000Z07:00")
+	}
+	return crawler.FromMillis(ms).UTC().Format(w.timeFormat)
+}
+
+// Close flushes and rotates the open files, it returns once they are compressed
+func (w *CsvStorage) Close() {
+	w.closeChan <- true
+	<-w.done
+}
+
+// delimiter reads a delimiter param, tab can be given as \t
+func delimiter(v string) rune {
+	if v == `\t` {
+		return '\t'
+	}
+	r, _ := utf8.DecodeRuneInString(v)
+	return r
+}
+
+func checkDelimiter(v string) error {
+	r := delimiter(v)
+	if v != `\t` && utf8.RuneCountInString(v) != 1 || r == '"' || r == '\r' || r == '\n' || r == utf8.RuneError {
+		return fmt.Errorf("delimiter should be a single character other than a quote or a newline, got %q", v)
+	}
+	return nil
+}
+
+func checkTimeFormat(v string) error {
+	if v != Millis && v != RFC3339 && !strings.Contains(v, "2006") {
+		return fmt.Errorf("time format should be %s, %s or a go layout like 2006-01-02 15:04:05, got %q", Millis, RFC3339, v)
+	}
+	return nil
+}
+
+func checkCsvPath(v string) error {
+	if !strings.Contains(v, "{meta}") {
+		return errCsvMeta
+	}
+	return checkPathTemplate(v)
+}
@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"cryptoCrawl/crawler"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCsvStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	w, err := NewCsvStorage(map[string]string{"path": filepath.Join(dir, "{meta}.csv"), "delimiter": ";", "time_format": RFC3339})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(crawler.TradeMeasurement{Meta: "trade", Platform: "binance", Pair: "BTCUSD", TransactionType: "buy", Amount: 0.5, Price: 10000, Timestamp: 1517443200000, TradeID: "a;b"})
+	w.Write(Projection{Measurement: crawler.TradeMeasurement{Meta: "trade", Platform: "bitstamp", Price: 1, Timestamp: 1517443200123}, Fields: []string{"price"}})
+	w.Write(crawler.CancelMeasurement{Meta: "cancel", Platform: "poloniex", Pair: "ETHBTC", Type: "sell", Price: 0.1, TimeStamp: 1517443200000})
+	w.(Closer).Close()
+
+	bits, err := ioutil.ReadFile(filepath.Join(dir, "trade.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "time;platform;pair;trade_type;type;amount;price;trade_id\n" +
+		"2018-02-01T00:00:00.000Z;binance;BTCUSD;;buy;0.5;10000;\"a;b\"\n" +
+		"2018-02-01T00:00:00.123Z;bitstamp;;;;;1;\n"
+	if string(bits) != expected {
+		t.Errorf("expected %q, got %q", expected, bits)
+	}
+	bits, _ = ioutil.ReadFile(filepath.Join(dir, "cancel.csv"))
+	if expected := "time;platform;pair;type;amount;price\n2018-02-01T00:00:00.000Z;poloniex;ETHBTC;sell;;0.1\n"; string(bits) != expected {
+		t.Errorf("expected %q, got %q", expected, bits)
+	}
+}
+
+func TestCsvParams(t *testing.T) {
+	for _, d := range []string{",", `\t`, ";", "|"} {
+		if err := checkDelimiter(d); err != nil {
+			t.Errorf("expected %q to be accepted, got %s", d, err)
+		}
+	}
+	for _, d := range []string{"", ";;", `"`, "\n"} {
+		if err := checkDelimiter(d); err == nil {
+			t.Errorf("expected %q to be rejected", d)
+		}
+	}
+	if err := checkTimeFormat("15:04"); err == nil {
+		t.Error("expected a layout without a year to be rejected")
+	}
+	if _, err := NewCsvStorage(map[string]string{"path": "/tmp/{platform}.csv"}); err == nil {
+		t.Error("expected a path without {meta} to be rejected")
+	}
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"crypto/sha1"
+	"cryptoCrawl/crawler"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+)
+
+// DocID identifies a measurement so that writing it again overwrites it,
+// trades are keyed by their exchange id and everything else by a hash of
+// the content, projections share the id of the full measurement. Orders and
+// cancels are stamped locally when received, their time is left out of the
+// hash so that crawling the same book again overwrites it
+func DocID(d interface{}) string {
+	if p, ok := d.(Projection); ok {
+		d = p.Measurement
+	}
+	switch v := d.(type) {
+	case crawler.TradeMeasurement:
+		if v.TradeID != "" {
+			return strings.Join([]string{v.Platform, v.Pair, v.Meta, v.TradeID}, "-")
+		}
+	case crawler.OrderMeasurement:
+		v.Timestamp = 0
+		d = v
+	case crawler.CancelMeasurement:
+		v.TimeStamp = 0
+		d = v
+	}
+	bits, err := json.Marshal(d)
+	if err != nil {
+		return ""
+	}
+	sum := sha1.Sum(bits)
+	m, ok := attributes(d)
+	if !ok {
+		return hex.EncodeToString(sum[:])
+	}
+	return strings.Join([]string{m.platform, m.pair, m.meta, hex.EncodeToString(sum[:])}, "-")
+}
@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"cryptoCrawl/crawler"
+	"testing"
+)
+
+func TestDocID(t *testing.T) {
+	tr := crawler.TradeMeasurement{Meta: "trade", Platform: "binance", Pair: "BTCUSD", Price: 1, TradeID: "42"}
+	if id := DocID(tr); id != "binance-BTCUSD-trade-42" {
+		t.Errorf("expected the trade to be keyed by its exchange id, got %s", id)
+	}
+	if DocID(Projection{Measurement: tr, Fields: []string{"price"}}) != DocID(tr) {
+		t.Error("expected a projection to share the id of the measurement")
+	}
+	o := crawler.OrderMeasurement{Meta: "order", Platform: "kraken", Pair: "BTCUSD", Price: 1, Amount: 2, Timestamp: 1000}
+	same, other := o, o
+	other.Amount = 3
+	if DocID(o) != DocID(same) || DocID(o) == DocID(other) {
+		t.Errorf("expected ids to follow the content, got %s %s %s", DocID(o), DocID(same), DocID(other))
+	}
+	same.Timestamp = 2000
+	if DocID(o) != DocID(same) {
+		t.Error("expected the local time of an order to be left out of its id")
+	}
+	c := crawler.CancelMeasurement{Meta: "cancel", Platform: "poloniex", Pair: "BTCUSD", Price: 1, TimeStamp: 1000}
+	later := c
+	later.TimeStamp = 2000
+	if DocID(c) != DocID(later) {
+		t.Error("expected the local time of a cancel to be left out of its id")
+	}
+	tr.TradeID = ""
+	if id := DocID(tr); id == "" || id == DocID(crawler.TradeMeasurement{Meta: "trade", Platform: "binance", Pair: "BTCUSD", Price: 2}) {
+		t.Errorf("expected a trade without id to be hashed, got %s", id)
+	}
+}
@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"cryptoCrawl/crawler"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SingleIndex = "none"
+	Daily       = "daily"
+	Monthly     = "monthly"
+)
+
+var (
+	indexLayouts = map[string]string{Daily: "2006.01.02", Monthly: "2006.01"}
+	esVersions   = []string{"5", "6", "7", "8"}
+
+	// mapping used when no mapping file is given
+	defaultProperties = map[string]interface{}{
+		"platform":   map[string]interface{}{"type": "keyword"},
+		"meta":       map[string]interface{}{"type": "keyword"},
+		"type":       map[string]interface{}{"type": "keyword"},
+		"trade_type": map[string]interface{}{"type": "keyword"},
+		"trade_id":   map[string]interface{}{"type": "keyword"},
+		docIDField:   map[string]interface{}{"type": "keyword"},
+		"pair":       map[string]interface{}{"type": "keyword"},
+		"amount":     map[string]interface{}{"type": "float"},
+		"price":      map[string]interface{}{"type": "float"},
+		"time":       map[string]interface{}{"type": "date", "format": "epoch_millis"},
+	}
+)
+
+// esIndex names the indices of an ES writer and the template they get
+// created from
+type esIndex struct {
+	prefix string
+	// time layout appended to the prefix, empty for a single index
+	layout  string
+	period  string
+	version int
+	// time based indices older than that are deleted
+	retention time.Duration
+	ilmPolicy string
+}
+
+func parseIndex(params map[string]string) (esIndex, error) {
+	x := esIndex{prefix: indexName, version: 5, period: params["index_period"], ilmPolicy: params["ilm_policy"]}
+	if p, ok := params["index"]; ok {
+		x.prefix = p
+	}
+	switch x.period {
+	case "", SingleIndex:
+	case Daily, Monthly:
+		x.layout = indexLayouts[x.period]
+	default:
+		return x, fmt.Errorf("unknown index period %s", x.period)
+	}
+	if v, ok := params["version"]; ok {
+		if err := checkVersion(v); err != nil {
+			return x, err
+		}
+		x.version, _ = strconv.Atoi(v)
+	}
+	if r, ok := params["retention"]; ok {
+		d, err := time.ParseDuration(r)
+		if err != nil {
+			return x, err
+		}
+		x.retention = d
+	}
+	return x, nil
+}
+
+// name is the index a measurement goes to, by the time of the measurement
+func (x esIndex) name(d interface{}) string {
+	if x.layout == "" {
+		return x.prefix
+	}
+	m, ok := attributes(d)
+	t := time.Now()
+	if ok && m.time > 0 {
+		t = crawler.FromMillis(m.time)
+	}
+	return x.prefix + "-" + t.UTC().Format(x.layout)
+}
+
+// docType is empty from ES 7 on as types are gone
+func (x esIndex) docType() string {
+	switch {
+	case x.version < 6:
+		return defaultType
+	case x.version == 6:
+		return "_doc"
+	}
+	return ""
+}
+
+// template builds the index template from a mapping file with a settings
+// and a mappings object, typed or not
+func (x esIndex) template(mapping []byte) (map[string]interface{}, error) {
+	var src struct {
+		Settings map[string]interface{} `json:"settings"`
+		Mappings map[string]interface{} `json:"mappings"`
+	}
+	properties := defaultProperties
+	if len(mapping) > 0 {
+		if err := json.Unmarshal(mapping, &src); err != nil {
+			return nil, fmt.Errorf("invalid mapping: %s", err)
+		}
+		p, err := mappingProperties(src.Mappings)
+		if err != nil {
+			return nil, err
+		}
+		properties = p
+	}
+	settings := src.Settings
+	if settings == nil {
+		settings = map[string]interface{}{}
+	}
+	if x.ilmPolicy != "" {
+		settings["index.lifecycle.name"] = x.ilmPolicy
+	}
+	var mappings interface{} = map[string]interface{}{"properties": properties}
+	if t := x.docType(); t != "" {
+		mappings = map[string]interface{}{t: mappings}
+	}
+	res := map[string]interface{}{"settings": settings, "mappings": mappings}
+	if x.version < 6 {
+		res["template"] = x.prefix + "*"
+	} else {
+		res["index_patterns"] = []string{x.prefix, x.prefix + "-*"}
+	}
+	return res, nil
+}
+
+func mappingProperties(mappings map[string]interface{}) (map[string]interface{}, error) {
+	if p, ok := mappings["properties"].(map[string]interface{}); ok {
+		return p, nil
+	}
+	// typed mapping, only one type is supported
+	if len(mappings) == 1 {
+		for _, t := range mappings {
+			if m, ok := t.(map[string]interface{}); ok {
+				if p, ok := m["properties"].(map[string]interface{}); ok {
+					return p, nil
+				}
+			}
+		}
+	}
+	return nil, fmt.Errorf("mapping should have properties, either typeless or under a single type")
+}
+
+// expired returns the time based indices past the retention
+func (x esIndex) expired(names []string, now time.Time) []string {
+	if x.layout == "" || x.retention == 0 {
+		return nil
+	}
+	var res []string
+	for _, n := range names {
+		if !strings.HasPrefix(n, x.prefix+"-") {
+			continue
+		}
+		start, err := time.Parse(x.layout, strings.TrimPrefix(n, x.prefix+"-"))
+		if err != nil {
+			continue
+		}
+		end := start.AddDate(0, 0, 1)
+		if x.period == Monthly {
+			end = start.AddDate(0, 1, 0)
+		}
+		if end.Add(x.retention).Before(now) {
+			res = append(res, n)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+func checkVersion(v string) error {
+	if !contains(esVersions, v) {
+		return fmt.Errorf("unsupported version %s, use one of %v", v, esVersions)
+	}
+	return nil
+}
+
+func checkIndexPeriod(v string) error {
+	if v != SingleIndex && indexLayouts[v] == "" {
+		return fmt.Errorf("unknown index period %s, use %s, %s or %s", v, SingleIndex, Daily, Monthly)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"cryptoCrawl/crawler"
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestIndexNames(t *testing.T) {
+	tr := crawler.TradeMeasurement{Meta: "trade", Timestamp: 1517443200000} // 2018-02-01
+	for period, expected := range map[string]string{"": "crypto", SingleIndex: "crypto", Daily: "crypto-2018.02.01", Monthly: "crypto-2018.02"} {
+		x, err := parseIndex(map[string]string{"index_period": period})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := x.name(tr); n != expected {
+			t.Errorf("%s: expected index %s, got %s", period, expected, n)
+		}
+	}
+
+	x, _ := parseIndex(map[string]string{"index": "trades", "index_period": Daily, "retention": "48h"})
+	now := time.Date(2018, 2, 10, 12, 0, 0, 0, time.UTC)
+	expired := x.expired([]string{"trades-2018.02.07", "trades-2018.02.08", "trades-2018.02.10", "trades", "crypto-2018.01.01"}, now)
+	if !reflect.DeepEqual(expired, []string{"trades-2018.02.07"}) {
+		t.Errorf("expected only the index past the retention, got %v", expired)
+	}
+}
+
+func TestIndexTemplate(t *testing.T) {
+	bits, err := ioutil.ReadFile("../mapping.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for version, mappingKey := range map[string]string{"5": defaultType, "6": "_doc", "7": "properties"} {
+		x, _ := parseIndex(map[string]string{"version": version, "ilm_policy": "crypto"})
+		tpl, err := x.template(bits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mappings := tpl["mappings"].(map[string]interface{})
+		if _, ok := mappings[mappingKey]; !ok {
+			t.Errorf("version %s: expected %s in the mappings, got %v", version, mappingKey, mappings)
+		}
+		if tpl["settings"].(map[string]interface{})["index.lifecycle.name"] != "crypto" {
+			t.Errorf("version %s: expected the ilm policy in the settings", version)
+		}
+	}
+	// typed mappings keep working
+	x, _ := parseIndex(map[string]string{"version": "7"})
+	tpl, err := x.template([]byte(`{"mappings": {"crypto": {"properties": {"time": {"type": "date"}}}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tpl["mappings"].(map[string]interface{})["properties"].(map[string]interface{})["time"]; !ok {
+		t.Errorf("expected the typed mapping to be made typeless, got %v", tpl)
+	}
+}
+
+func TestDocIDField(t *testing.T) {
+	for version, expected := range map[string]string{"6": "_uid", "7": docIDField, "8": docIDField} {
+		x, err := parseIndex(map[string]string{"version": version})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f := x.tiebreaker(); f != expected {
+			t.Errorf("version %s: expected to sort on %s, got %s", version, expected, f)
+		}
+	}
+	tr := crawler.TradeMeasurement{Meta: "trade", Platform: "kraken", Pair: "BTCUSD", Price: 1, Timestamp: 1000}
+	src, err := withDocID(tr, DocID(tr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(src, &doc); err != nil {
+		t.Fatalf("invalid source %s: %s", src, err)
+	}
+	if doc[docIDField] != DocID(tr) || doc["price"] != 1.0 || doc["platform"] != "kraken" {
+		t.Errorf("expected the trade with its id, got %s", src)
+	}
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"context"
+	"cryptoCrawl/crawler"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/olivere/elastic.v5"
+	"time"
+)
+
+// ElasticReader reads the trades written by the elasticsearch writer, going
+// through the pages with search_after
+type ElasticReader struct {
+	client *elastic.Client
+	index  esIndex
+}
+
+func NewElasticReader(params map[string]string) (Reader, error) {
+	host, ok := params["host"]
+	if !ok {
+		return nil, fmt.Errorf("parameter 'host' should be present")
+	}
+	index, err := parseIndex(params)
+	if err != nil {
+		return nil, err
+	}
+	cli, err := elastic.NewClient(elastic.SetSniff(false), elastic.SetURL(host))
+	if err != nil {
+		return nil, err
+	}
+	return &ElasticReader{client: cli, index: index}, nil
+}
+
+// pattern matches every index of the writer
+func (x esIndex) pattern() string {
+	if x.layout == "" {
+		return x.prefix
+	}
+	return x.prefix + "-*"
+}
+
+// tiebreaker orders the docs sharing a time, _uid is gone from ES 7 on and
+// sorting on _id is disabled by default from ES 8 on, the id is also written
+// in a keyword field for that
+func (x esIndex) tiebreaker() string {
+	if x.version < 7 {
+		return "_uid"
+	}
+	return docIDField
+}
+
+func (r *ElasticReader) Trades(q Query, page func([]crawler.TradeMeasurement) error) error {
+	if err := checkQuery(q); err != nil {
+		return err
+	}
+	query := elastic.NewBoolQuery().Filter(
+		elastic.NewTermQuery("meta", "trade"),
+		elastic.NewTermQuery("platform", q.Platform),
+		elastic.NewTermQuery("pair", q.Pair),
+		elastic.NewRangeQuery("time").Gte(q.From.UnixNano()/int64(time.Millisecond)).Lt(q.To.UnixNano()/int64(time.Millisecond)),
+	)
+	var after []interface{}
+	for {
+		s := r.client.Search(r.index.pattern()).
+			IgnoreUnavailable(true).
+			Query(query).
+			Sort("time", true).
+			Sort(r.index.tiebreaker(), true).
+			Size(q.pageSize())
+		if after != nil {
+			s = s.SearchAfter(after...)
+		}
+		res, err := s.Do(context.Background())
+		if err != nil {
+			return err
+		}
+		if res.Hits == nil || len(res.Hits.Hits) == 0 {
+			return nil
+		}
+		trades := make([]crawler.TradeMeasurement, 0, len(res.Hits.Hits))
+		for _, h := range res.Hits.Hits {
+			if h.Source == nil {
+				continue
+			}
+			var t crawler.TradeMeasurement
+			if err := json.Unmarshal(*h.Source, &t); err != nil {
+				return fmt.Errorf("invalid doc %s: %s", h.Id, err)
+			}
+			trades = append(trades, t)
+		}
+		if err := page(trades); err != nil {
+			return err
+		}
+		if len(res.Hits.Hits) < q.pageSize() {
+			return nil
+		}
+		after = res.Hits.Hits[len(res.Hits.Hits)-1].Sort
+	}
+}
@@ -2,10 +2,12 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/olivere/elastic.v5"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"time"
 )
@@ -13,13 +15,30 @@ import (
 const (
 	defaultType = "default"
 	indexName   = "crypto"
+	// keyword field the doc id is copied to, readers sort on it
+	docIDField = "doc_id"
+	// attempts at the docs of a bulk request rejected with a retryable status
+	bulkRetries = 3
+	// how often indices past the retention get deleted
+	retentionCheck = time.Hour
 )
 
 type ElasticStorageService struct {
-	client       *elastic.Client
-	ctx          context.Context
-	dataChan     chan interface{}
-	tickDuration time.Duration
+	client    *elastic.Client
+	ctx       context.Context
+	index     esIndex
+	dataChan  chan interface{}
+	batch     batchConfig
+	closeChan chan bool
+	spool     *Spool
+	// rejected docs come back here once their backoff is over
+	retries chan esRetry
+	done    chan struct{}
+}
+
+type esRetry struct {
+	docs    []interface{}
+	attempt int
 }
 
 func NewESStorage(params map[string]string) (DataWriter, error) {
@@ -28,47 +47,68 @@ func NewESStorage(params map[string]string) (DataWriter, error) {
 	if !ok {
 		return nil, fmt.Errorf("param 'host' should be present")
 	}
-	mappingFile, ok := params["mapping"]
-	if !ok {
-		return nil, fmt.Errorf("param 'mapping' should be present")
-	}
-	cli, err := elastic.NewClient(elastic.SetSniff(false), elastic.SetURL(host))
+	index, err := parseIndex(params)
 	if err != nil {
 		return nil, err
 	}
-
-	if fi, err := os.Stat(mappingFile); err != nil {
-		return nil, err
-	} else {
-		if fi.IsDir() {
+	var bits []byte
+	if mappingFile, ok := params["mapping"]; ok {
+		if fi, err := os.Stat(mappingFile); err != nil {
+			return nil, err
+		} else if fi.IsDir() {
 			return nil, fmt.Errorf("%s points to a directory", mappingFile)
 		}
+		bits, err = ioutil.ReadFile(mappingFile)
+		if err != nil {
+			return nil, err
+		}
 	}
-	bits, err := ioutil.ReadFile(mappingFile)
+	template, err := index.template(bits)
+	if err != nil {
+		return nil, err
+	}
+	cli, err := elastic.NewClient(elastic.SetSniff(false), elastic.SetURL(host))
 	if err != nil {
 		return nil, err
 	}
 	ctx := context.Background()
 	c := &ElasticStorageService{
-		client:       cli,
-		ctx:          ctx,
-		dataChan:     make(chan interface{}, 10000),
-		tickDuration: parsePeriod(params),
-	}
-	exists, err := cli.IndexExists(indexName).Do(ctx)
-	if exists {
-		log.Warnf("index %s already exists, skipping", indexName)
-		go c.Ingest()
-		return c, nil
-	} else {
-		log.Debugf("creating new index %s from mapping file %s", indexName, mappingFile)
-		_, err = cli.CreateIndex(indexName).Body(string(bits)).Do(ctx)
-		if err != nil {
-			return nil, err
-		} else {
-			go c.Ingest()
-			return c, nil
-		}
+		client:    cli,
+		ctx:       ctx,
+		index:     index,
+		dataChan:  make(chan interface{}, 10000),
+		batch:     parseBatchConfig(params),
+		closeChan: make(chan bool),
+		retries:   make(chan esRetry),
+		done:      make(chan struct{}),
+	}
+	// indices are created by ES on the first write, from the template
+	log.Debugf("installing index template %s", index.prefix)
+	if _, err := cli.IndexPutTemplate(index.prefix).BodyJson(template).Do(ctx); err != nil {
+		return nil, err
+	}
+	c.deleteExpired()
+	go c.Ingest()
+	return c, nil
+}
+
+// deleteExpired drops the time based indices past the retention
+func (c *ElasticStorageService) deleteExpired() {
+	if c.index.retention == 0 {
+		return
+	}
+	names, err := c.client.IndexNames()
+	if err != nil {
+		log.Errorf("error listing indices: %s", err)
+		return
+	}
+	expired := c.index.expired(names, time.Now())
+	if len(expired) == 0 {
+		return
+	}
+	log.Infof("deleting indices past the retention: %v", expired)
+	if _, err := c.client.DeleteIndex(expired...).Do(c.ctx); err != nil {
+		log.Errorf("error deleting indices %v: %s", expired, err)
 	}
 }
 
@@ -77,31 +117,158 @@ func (c *ElasticStorageService) Write(data interface{}) {
 }
 
 func (c *ElasticStorageService) Ingest() {
-	var data []interface{}
-	tick := time.Tick(c.tickDuration)
+	b := newBatcher(c.batch, docSize, c.push)
+	b.serial = c.spooled
+	var retention <-chan time.Time
+	if c.index.retention > 0 {
+		ticker := time.NewTicker(retentionCheck)
+		defer ticker.Stop()
+		retention = ticker.C
+	}
 	for {
 		select {
+		case <-retention:
+			c.deleteExpired()
 		case d := <-c.dataChan:
-			data = append(data, d)
-		case <-tick:
-			go c.push(data)
-			data = []interface{}{}
+			b.add(d)
+		case <-b.expired():
+			b.send()
+		case r := <-c.retries:
+			b.run(func() { c.deliver(r.docs, r.attempt) })
+		case <-c.closeChan:
+			close(c.done)
+			b.close()
+			if c.spool != nil {
+				c.spool.Close()
+			}
+			return
 		}
 	}
 }
 
+func (c *ElasticStorageService) Close() {
+	c.closeChan <- true
+}
+
+func (c *ElasticStorageService) setSpool(s *Spool) {
+	c.spool = s
+}
+
+func (c *ElasticStorageService) spooled() bool {
+	return c.spool != nil
+}
+
 func (c *ElasticStorageService) push(data []interface{}) {
 	if len(data) == 0 {
 		return
 	}
+	c.deliver(data, 1)
+}
+
+// deliver writes the docs, the ones rejected with a retryable status are
+// handed back to Ingest after a backoff rather than waited for here, so that
+// they do not hold a flush slot. With a spool they are spooled instead
+func (c *ElasticStorageService) deliver(data []interface{}, attempt int) {
+	if c.spool != nil {
+		c.spool.Deliver(data)
+		return
+	}
+	err := writeBatch("elasticsearch", c, data)
+	if err == nil {
+		return
+	}
+	pe, ok := err.(*PartialError)
+	if !ok {
+		log.Error(err)
+		return
+	}
+	if attempt >= bulkRetries {
+		log.Errorf("dropping %d docs still rejected by ES after %d attempts", len(pe.Failed), attempt)
+		return
+	}
+	log.Warnf("retrying %d docs rejected by ES", len(pe.Failed))
+	time.AfterFunc(time.Duration(attempt)*time.Second, func() {
+		select {
+		case c.retries <- esRetry{docs: pe.Failed, attempt: attempt + 1}:
+		case <-c.done:
+			log.Errorf("dropping %d docs rejected by ES, the writer is closed", len(pe.Failed))
+		}
+	})
+}
+
+func docSize(d interface{}) int {
+	bits, err := json.Marshal(d)
+	if err != nil {
+		return 0
+	}
+	return len(bits)
+}
+
+// WriteBatch indexes the docs in bulk, the docs ES rejects with a retryable
+// status are returned in a PartialError for the caller to send again
+func (c *ElasticStorageService) WriteBatch(data []interface{}) error {
+	failed, err := c.bulk(data)
+	if err != nil {
+		return fmt.Errorf("error indexing %d docs: %s", len(data), err)
+	}
+	if len(failed) > 0 {
+		return &PartialError{Failed: failed, Err: fmt.Errorf("%d docs rejected by ES", len(failed))}
+	}
+	return nil
+}
+
+// withDocID adds the id to the source of the doc
+func withDocID(d interface{}, id string) (json.RawMessage, error) {
+	bits, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	if len(bits) < 2 || bits[0] != '{' {
+		return nil, fmt.Errorf("expected an object, got %s", bits)
+	}
+	field, _ := json.Marshal(id)
+	res := append([]byte(`{"`+docIDField+`":`), field...)
+	if len(bits) > 2 {
+		res = append(res, ',')
+	}
+	return append(res, bits[1:]...), nil
+}
+
+// bulk returns the docs that failed with a retryable status, the others are
+// dropped as they would fail again
+func (c *ElasticStorageService) bulk(data []interface{}) ([]interface{}, error) {
 	var requests []elastic.BulkableRequest
 	for _, i := range data {
-		requests = append(requests, elastic.NewBulkIndexRequest().Doc(i))
+		r := elastic.NewBulkIndexRequest().Index(c.index.name(i)).Type(c.index.docType())
+		var doc interface{} = i
+		// ES generates an id when there is none
+		if id := DocID(i); id != "" {
+			r = r.Id(id)
+			if src, err := withDocID(i, id); err == nil {
+				doc = src
+			}
+		}
+		r = r.Doc(doc)
+		requests = append(requests, r)
 	}
-	_, err := c.client.Bulk().Index(indexName).Type(defaultType).Add(requests...).Do(c.ctx)
+	res, err := c.client.Bulk().Add(requests...).Do(c.ctx)
 	if err != nil {
-		log.Errorf("error indexing %d docs: %s", len(data), err)
-	} else {
-		log.Infof("successfully pushed %d bulk datapoints in ES", len(data))
+		return nil, err
+	}
+	var failed []interface{}
+	if res.Errors {
+		for i, item := range res.Items {
+			for _, r := range item {
+				switch {
+				case r.Status >= 200 && r.Status < 300:
+				case r.Status == http.StatusTooManyRequests || r.Status >= 500:
+					failed = append(failed, data[i])
+				default:
+					log.Errorf("dropping doc rejected by ES with status %d: %+v", r.Status, r.Error)
+				}
+			}
+		}
 	}
+	log.Infof("successfully pushed %d bulk datapoints in ES", len(data)-len(failed))
+	return failed, nil
 }
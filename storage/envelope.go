@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// EnvelopeVersion is bumped whenever the envelope or the measurements in it
+// change in a way consumers have to know about
+const EnvelopeVersion = 1
+
+// Envelope wraps the measurements published to other services, the type
+// tells how to decode the data and the fields are set for projections
+type Envelope struct {
+	Version int             `json:"version"`
+	Type    string          `json:"type"`
+	Fields  []string        `json:"fields,omitempty"`
+	Data    json.RawMessage `json:"data"`
+}
+
+func NewEnvelope(d interface{}) (Envelope, error) {
+	s, err := encodeSpilled(d)
+	if err != nil {
+		return Envelope{}, err
+	}
+	return Envelope{Version: EnvelopeVersion, Type: s.Type, Fields: s.Fields, Data: s.Data}, nil
+}
+
+// Measurement decodes the measurement back, envelopes of a later version
+// are refused
+func (e Envelope) Measurement() (interface{}, error) {
+	if e.Version < 1 || e.Version > EnvelopeVersion {
+		return nil, fmt.Errorf("unsupported envelope version %d", e.Version)
+	}
+	return spilled{Type: e.Type, Fields: e.Fields, Data: e.Data}.decode()
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"sync"
+)
+
+// Closer is implemented by writers buffering data, Close flushes what is
+// pending and stops them
+type Closer interface {
+	Close()
+}
+
+// Fanout hands every measurement to a set of writers that can change while
+// the crawlers are running
+type Fanout struct {
+	locker  sync.RWMutex
+	keys    []string
+	writers map[string]DataWriter
+	// rebuilt on every change, Write calls the writers without holding the
+	// lock so that a blocked writer does not hold up Add or Remove
+	list []DataWriter
+}
+
+func NewFanout() *Fanout {
+	return &Fanout{writers: map[string]DataWriter{}}
+}
+
+func (f *Fanout) Write(d interface{}) {
+	f.locker.RLock()
+	list := f.list
+	f.locker.RUnlock()
+	for _, w := range list {
+		w.Write(d)
+	}
+}
+
+func (f *Fanout) update() {
+	list := make([]DataWriter, len(f.keys))
+	for i, k := range f.keys {
+		list[i] = f.writers[k]
+	}
+	f.list = list
+}
+
+// Add registers a writer under key and returns the writer it replaces, if any
+func (f *Fanout) Add(key string, w DataWriter) DataWriter {
+	f.locker.Lock()
+	defer f.locker.Unlock()
+	old, ok := f.writers[key]
+	if !ok {
+		f.keys = append(f.keys, key)
+	}
+	f.writers[key] = w
+	f.update()
+	return old
+}
+
+func (f *Fanout) Remove(key string) DataWriter {
+	f.locker.Lock()
+	defer f.locker.Unlock()
+	old, ok := f.writers[key]
+	if !ok {
+		return nil
+	}
+	delete(f.writers, key)
+	for i, k := range f.keys {
+		if k == key {
+			f.keys = append(f.keys[:i], f.keys[i+1:]...)
+			break
+		}
+	}
+	f.update()
+	return old
+}
+
+// Close removes a writer with its key, flushing it if it buffers data
+func (f *Fanout) Close(key string) {
+	if c, ok := f.Remove(key).(Closer); ok {
+		c.Close()
+	}
+}
@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFanoutBlockedWriter(t *testing.T) {
+	f := NewFanout()
+	blocked := &gatedWriter{gate: make(chan struct{})}
+	f.Add("blocked", blocked)
+	go f.Write(1)
+	time.Sleep(10 * time.Millisecond)
+
+	// a writer stuck in Write should not hold up changes nor other writes
+	done := make(chan struct{})
+	w := &sliceWriter{}
+	go func() {
+		f.Remove("blocked")
+		f.Add("other", w)
+		f.Write(2)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("fanout blocked behind a writer")
+	}
+	close(blocked.gate)
+	if len(*w) != 1 {
+		t.Errorf("expected the write to reach the new writer, got %v", *w)
+	}
+}
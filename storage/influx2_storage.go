@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"bytes"
+	"cryptoCrawl/crawler"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// InfluxV2StorageService writes to the v2 http api of InfluxDB 2.x
+type InfluxV2StorageService struct {
+	client      *http.Client
+	writeURL    string
+	token       string
+	precision   string
+	batch       batchConfig
+	dataChannel chan crawler.InfluxIngestable
+	closeChan   chan bool
+	spool       *Spool
+}
+
+func NewInfluxV2Storage(params map[string]string) (DataWriter, error) {
+	for _, p := range []string{"host", "org", "bucket", "token"} {
+		if _, ok := params[p]; !ok {
+			return nil, fmt.Errorf("parameter '%s' should be present", p)
+		}
+	}
+	precision := "ms"
+	if p, ok := params["precision"]; ok {
+		if err := checkPrecision(p); err != nil {
+			return nil, err
+		}
+		precision = p
+	}
+	q := url.Values{}
+	q.Set("org", params["org"])
+	q.Set("bucket", params["bucket"])
+	q.Set("precision", precision)
+	host := strings.TrimSuffix(params["host"], "/")
+	res := &InfluxV2StorageService{
+		client:      &http.Client{Timeout: time.Second * 5},
+		writeURL:    host + "/api/v2/write?" + q.Encode(),
+		token:       params["token"],
+		precision:   precision,
+		batch:       parseBatchConfig(params),
+		dataChannel: make(chan crawler.InfluxIngestable, 10000),
+		closeChan:   make(chan bool),
+	}
+	resp, err := res.client.Get(host + "/health")
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	go res.Ingest()
+	return res, nil
+}
+
+func (i *InfluxV2StorageService) Write(data interface{}) {
+	if ii, ok := data.(crawler.InfluxIngestable); ok {
+		i.dataChannel <- ii
+	} else {
+		log.Errorf("%+v could not be converted to InfluxIngestable", data)
+	}
+}
+
+func (i *InfluxV2StorageService) Ingest() {
+	b := newBatcher(i.batch, pointSize, i.process)
+	b.serial = i.spooled
+	for {
+		select {
+		case d := <-i.dataChannel:
+			b.add(d)
+		case <-b.expired():
+			b.send()
+		case <-i.closeChan:
+			b.close()
+			if i.spool != nil {
+				i.spool.Close()
+			}
+			return
+		}
+	}
+}
+
+func (i *InfluxV2StorageService) Close() {
+	i.closeChan <- true
+}
+
+func (i *InfluxV2StorageService) setSpool(s *Spool) {
+	i.spool = s
+}
+
+func (i *InfluxV2StorageService) spooled() bool {
+	return i.spool != nil
+}
+
+func (i *InfluxV2StorageService) process(data []interface{}) {
+	if len(data) == 0 {
+		return
+	}
+	if i.spool != nil {
+		i.spool.Deliver(data)
+	} else if err := writeBatch("influxdb2", i, data); err != nil {
+		log.Error(err)
+	}
+}
+
+func (i *InfluxV2StorageService) WriteBatch(data []interface{}) error {
+	body := encodeLines(data, i.precision)
+	req, err := http.NewRequest(http.MethodPost, i.writeURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+i.token)
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error writing %d points to influx: %s", len(data), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("error writing %d points to influx: %s %s", len(data), resp.Status, bytes.TrimSpace(msg))
+	}
+	log.Infof("successfully written %d bulk points to influx", len(data))
+	return nil
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"cryptoCrawl/crawler"
+	"encoding/json"
+	"fmt"
+	"github.com/influxdata/influxdb/client/v2"
+	"strings"
+	"time"
+)
+
+// InfluxReader reads the trades written by the influxdb writer
+type InfluxReader struct {
+	cli    client.Client
+	schema influxSchema
+}
+
+func NewInfluxReader(params map[string]string) (Reader, error) {
+	host, ok := params["host"]
+	if !ok {
+		return nil, fmt.Errorf("parameter 'host' should be present")
+	}
+	schema, err := parseInfluxSchema(params)
+	if err != nil {
+		return nil, err
+	}
+	cli, err := client.NewHTTPClient(client.HTTPConfig{
+		Timeout:  time.Second * 30,
+		Addr:     host,
+		Username: params["username"],
+		Password: params["password"],
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := cli.Ping(time.Second * 5); err != nil {
+		return nil, err
+	}
+	return &InfluxReader{cli: cli, schema: schema}, nil
+}
+
+// query selects a page of trades, ordered by time
+func (r *InfluxReader) query(q Query, offset int) string {
+	return fmt.Sprintf("SELECT amount, price, \"type\", trade_type FROM %s WHERE platform = %s AND pair = %s "+
+		"AND time >= %dms AND time < %dms ORDER BY time ASC LIMIT %d OFFSET %d",
+		r.schema.measurement(r.schema.rp, "trade"), quoteString(q.Platform), quoteString(q.Pair),
+		q.From.UnixNano()/int64(time.Millisecond), q.To.UnixNano()/int64(time.Millisecond), q.pageSize(), offset)
+}
+
+func (r *InfluxReader) Trades(q Query, page func([]crawler.TradeMeasurement) error) error {
+	if err := checkQuery(q); err != nil {
+		return err
+	}
+	for offset := 0; ; offset += q.pageSize() {
+		res, err := r.cli.Query(client.NewQuery(r.query(q, offset), r.schema.db, "ms"))
+		if err != nil {
+			return err
+		}
+		if err := res.Error(); err != nil {
+			return err
+		}
+		var trades []crawler.TradeMeasurement
+		for _, result := range res.Results {
+			for _, s := range result.Series {
+				for _, v := range s.Values {
+					t, err := influxTrade(s.Columns, v)
+					if err != nil {
+						return err
+					}
+					t.Platform, t.Pair = q.Platform, q.Pair
+					trades = append(trades, t)
+				}
+			}
+		}
+		if len(trades) > 0 {
+			if err := page(trades); err != nil {
+				return err
+			}
+		}
+		if len(trades) < q.pageSize() {
+			return nil
+		}
+	}
+}
+
+// influxTrade reads a row of the trade query, times are in milliseconds
+func influxTrade(columns []string, values []interface{}) (crawler.TradeMeasurement, error) {
+	t := crawler.TradeMeasurement{Meta: "trade"}
+	for i, c := range columns {
+		if i >= len(values) || values[i] == nil {
+			continue
+		}
+		n, _ := values[i].(json.Number)
+		var err error
+		switch c {
+		case "time":
+			t.Timestamp, err = n.Int64()
+		case "amount":
+			t.Amount, err = n.Float64()
+		case "price":
+			t.Price, err = n.Float64()
+		case "type":
+			t.TransactionType, _ = values[i].(string)
+		case "trade_type":
+			t.TradeType, _ = values[i].(string)
+		}
+		if err != nil {
+			return t, fmt.Errorf("invalid %s %v: %s", c, values[i], err)
+		}
+	}
+	return t, nil
+}
+
+func quoteString(s string) string {
+	return "'" + strings.Replace(strings.Replace(s, `\`, `\\`, -1), "'", `\'`, -1) + "'"
+}
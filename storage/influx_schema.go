@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var precisions = []string{"ns", "us", "ms", "s"}
+
+// influxSchema is where an influx writer writes and what it creates at startup
+type influxSchema struct {
+	db        string
+	rp        string
+	precision string
+	// the retention policy is created when given a duration
+	rpDuration time.Duration
+	// intervals trades get downsampled to with continuous queries
+	downsample []time.Duration
+	// retention policy of the downsampled measurements, the default one when empty
+	downsampleRP string
+}
+
+func parseInfluxSchema(params map[string]string) (influxSchema, error) {
+	s := influxSchema{
+		db:           dbName,
+		rp:           params["retention_policy"],
+		precision:    "ms",
+		downsampleRP: params["downsample_policy"],
+	}
+	if db, ok := params["db"]; ok {
+		s.db = db
+	}
+	if p, ok := params["precision"]; ok {
+		if err := checkPrecision(p); err != nil {
+			return s, err
+		}
+		s.precision = p
+	}
+	if d, ok := params["retention_duration"]; ok {
+		if s.rp == "" {
+			return s, fmt.Errorf("retention_duration needs a retention_policy")
+		}
+		dur, err := time.ParseDuration(d)
+		if err != nil {
+			return s, err
+		}
+		s.rpDuration = dur
+	}
+	if ds, ok := params["downsample"]; ok {
+		for _, i := range strings.Split(ds, ",") {
+			dur, err := time.ParseDuration(strings.TrimSpace(i))
+			if err != nil {
+				return s, err
+			}
+			s.downsample = append(s.downsample, dur)
+		}
+	}
+	return s, nil
+}
+
+// setup returns the statements creating the database, retention policy and
+// continuous queries, every one of them can be run again
+func (s influxSchema) setup() []string {
+	res := []string{fmt.Sprintf("CREATE DATABASE %s", quoteIdent(s.db))}
+	if s.rpDuration > 0 {
+		res = append(res, fmt.Sprintf("CREATE RETENTION POLICY %s ON %s DURATION %s REPLICATION 1",
+			quoteIdent(s.rp), quoteIdent(s.db), influxDuration(s.rpDuration)))
+	}
+	for _, i := range s.downsample {
+		name := "trade_" + influxDuration(i)
+		res = append(res, fmt.Sprintf("CREATE CONTINUOUS QUERY %s ON %s BEGIN "+
+			"SELECT first(price) AS open, max(price) AS high, min(price) AS low, last(price) AS close, sum(amount) AS volume "+
+			"INTO %s FROM %s GROUP BY time(%s), * END",
+			quoteIdent(name), quoteIdent(s.db), s.measurement(s.downsampleRP, name), s.measurement(s.rp, "trade"), influxDuration(i)))
+	}
+	return res
+}
+
+// alterRP updates the duration of a retention policy that already exists
+func (s influxSchema) alterRP() string {
+	return fmt.Sprintf("ALTER RETENTION POLICY %s ON %s DURATION %s", quoteIdent(s.rp), quoteIdent(s.db), influxDuration(s.rpDuration))
+}
+
+func (s influxSchema) measurement(rp, name string) string {
+	return fmt.Sprintf("%s.%s.%s", quoteIdent(s.db), quoteIdent(rp), quoteIdent(name))
+}
+
+func quoteIdent(s string) string {
+	if s == "" {
+		return ""
+	}
+	return `"` + strings.Replace(s, `"`, `\"`, -1) + `"`
+}
+
+// influxDuration formats d as an influxql duration literal
+func influxDuration(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	case d%time.Second == 0:
+		return fmt.Sprintf("%ds", d/time.Second)
+	}
+	return fmt.Sprintf("%dms", d/time.Millisecond)
+}
+
+func checkPrecision(v string) error {
+	if !contains(precisions, v) {
+		return fmt.Errorf("unknown precision %s, use one of %v", v, precisions)
+	}
+	return nil
+}
+
+func checkDurations(v string) error {
+	for _, d := range strings.Split(v, ",") {
+		if _, err := time.ParseDuration(strings.TrimSpace(d)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInfluxSetup(t *testing.T) {
+	s, err := parseInfluxSchema(map[string]string{
+		"db":                 "markets",
+		"retention_policy":   "raw",
+		"retention_duration": "720h",
+		"downsample":         "1m, 1h",
+		"downsample_policy":  "forever",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		`CREATE DATABASE "markets"`,
+		`CREATE RETENTION POLICY "raw" ON "markets" DURATION 720h REPLICATION 1`,
+		`CREATE CONTINUOUS QUERY "trade_1m" ON "markets" BEGIN SELECT first(price) AS open, max(price) AS high, min(price) AS low, last(price) AS close, sum(amount) AS volume INTO "markets"."forever"."trade_1m" FROM "markets"."raw"."trade" GROUP BY time(1m), * END`,
+		`CREATE CONTINUOUS QUERY "trade_1h" ON "markets" BEGIN SELECT first(price) AS open, max(price) AS high, min(price) AS low, last(price) AS close, sum(amount) AS volume INTO "markets"."forever"."trade_1h" FROM "markets"."raw"."trade" GROUP BY time(1h), * END`,
+	}
+	if setup := s.setup(); !reflect.DeepEqual(setup, expected) {
+		t.Errorf("unexpected setup:\n%s", setup)
+	}
+
+	s, _ = parseInfluxSchema(map[string]string{})
+	if setup := s.setup(); !reflect.DeepEqual(setup, []string{`CREATE DATABASE "crypto"`}) || s.precision != "ms" {
+		t.Errorf("unexpected defaults %+v: %s", s, setup)
+	}
+	if _, err := parseInfluxSchema(map[string]string{"retention_duration": "1h"}); err == nil {
+		t.Error("expected an error for a duration without a retention policy")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/influxdata/influxdb/client/v2"
 	log "github.com/sirupsen/logrus"
+	"strings"
 	"time"
 )
 
@@ -13,9 +14,12 @@ const (
 )
 
 type InfluxStorageService struct {
-	cli          client.Client
-	tickDuration time.Duration
-	dataChannel  chan crawler.InfluxIngestable
+	cli         client.Client
+	schema      influxSchema
+	batch       batchConfig
+	dataChannel chan crawler.InfluxIngestable
+	closeChan   chan bool
+	spool       *Spool
 }
 
 func NewInfluxStorage(params map[string]string) (DataWriter, error) {
@@ -23,9 +27,15 @@ func NewInfluxStorage(params map[string]string) (DataWriter, error) {
 	if !ok {
 		return nil, fmt.Errorf("parameter 'host' should be present")
 	}
+	schema, err := parseInfluxSchema(params)
+	if err != nil {
+		return nil, err
+	}
 	influxCfg := client.HTTPConfig{
-		Timeout: time.Second * 5,
-		Addr:    host,
+		Timeout:  time.Second * 5,
+		Addr:     host,
+		Username: params["username"],
+		Password: params["password"],
 	}
 	cli, err := client.NewHTTPClient(influxCfg)
 	if err != nil {
@@ -36,14 +46,39 @@ func NewInfluxStorage(params map[string]string) (DataWriter, error) {
 		return nil, err
 	}
 	res := &InfluxStorageService{
-		cli:          cli,
-		dataChannel:  make(chan crawler.InfluxIngestable, 10000),
-		tickDuration: parsePeriod(params),
+		cli:         cli,
+		schema:      schema,
+		dataChannel: make(chan crawler.InfluxIngestable, 10000),
+		batch:       parseBatchConfig(params),
+		closeChan:   make(chan bool),
 	}
+	res.setup()
 	go res.Ingest()
 	return res, nil
 }
 
+// setup creates the database, retention policy and continuous queries, it
+// only logs errors as the user may lack the rights while everything exists
+func (i *InfluxStorageService) setup() {
+	for _, q := range i.schema.setup() {
+		err := i.exec(q)
+		if err != nil && strings.Contains(err.Error(), "already exists") && strings.HasPrefix(q, "CREATE RETENTION POLICY") {
+			err = i.exec(i.schema.alterRP())
+		}
+		if err != nil {
+			log.Errorf("error running %s: %s", q, err)
+		}
+	}
+}
+
+func (i *InfluxStorageService) exec(q string) error {
+	res, err := i.cli.Query(client.NewQuery(q, i.schema.db, ""))
+	if err != nil {
+		return err
+	}
+	return res.Error()
+}
+
 func (c *InfluxStorageService) Write(data interface{}) {
 	if ii, ok := data.(crawler.InfluxIngestable); ok {
 		c.dataChannel <- ii
@@ -53,29 +88,70 @@ func (c *InfluxStorageService) Write(data interface{}) {
 }
 
 func (i *InfluxStorageService) Ingest() {
-	var data []crawler.InfluxMeasurement
-	ticker := time.Tick(i.tickDuration)
+	b := newBatcher(i.batch, pointSize, i.process)
+	b.serial = i.spooled
 	for {
 		select {
 		case d := <-i.dataChannel:
-			data = append(data, d.AsInfluxMeasurement())
-		case <-ticker:
-			go i.process(data)
-			data = []crawler.InfluxMeasurement{}
+			b.add(d)
+		case <-b.expired():
+			b.send()
+		case <-i.closeChan:
+			b.close()
+			if i.spool != nil {
+				i.spool.Close()
+			}
+			i.cli.Close()
+			return
 		}
 	}
 }
 
-func (i *InfluxStorageService) process(data []crawler.InfluxMeasurement) {
+func (i *InfluxStorageService) Close() {
+	i.closeChan <- true
+}
+
+func (i *InfluxStorageService) setSpool(s *Spool) {
+	i.spool = s
+}
+
+func (i *InfluxStorageService) spooled() bool {
+	return i.spool != nil
+}
+
+func (i *InfluxStorageService) process(data []interface{}) {
+	if len(data) == 0 {
+		return
+	}
+	if i.spool != nil {
+		i.spool.Deliver(data)
+	} else if err := writeBatch("influxdb", i, data); err != nil {
+		log.Error(err)
+	}
+}
+
+// pointSize is the length of the line protocol of a measurement
+func pointSize(d interface{}) int {
+	m := d.(crawler.InfluxIngestable).AsInfluxMeasurement()
+	p, err := client.NewPoint(m.Measurement, m.Tags, m.Fields, m.Timestamp)
+	if err != nil {
+		return 0
+	}
+	return len(p.String())
+}
+
+func (i *InfluxStorageService) WriteBatch(data []interface{}) error {
 	bp, err := client.NewBatchPoints(client.BatchPointsConfig{
-		Database: dbName,
+		Database:        i.schema.db,
+		RetentionPolicy: i.schema.rp,
+		Precision:       i.schema.precision,
 	})
 	if err != nil {
-		log.Error(err)
-		return
+		return err
 	}
 	var points []*client.Point
-	for _, d := range data {
+	for _, v := range data {
+		d := v.(crawler.InfluxIngestable).AsInfluxMeasurement()
 		p, err := client.NewPoint(d.Measurement, d.Tags, d.Fields, d.Timestamp)
 		if err != nil {
 			log.Errorf("error making a point out of %+v: %s", d, err)
@@ -86,8 +162,8 @@ func (i *InfluxStorageService) process(data []crawler.InfluxMeasurement) {
 	bp.AddPoints(points)
 	err = i.cli.Write(bp)
 	if err != nil {
-		log.Errorf("error writing %d points to influx: %s", len(points), err)
-	} else {
-		log.Infof("successfully written %d bulk points to influx", len(points))
+		return fmt.Errorf("error writing %d points to influx: %s", len(points), err)
 	}
+	log.Infof("successfully written %d bulk points to influx", len(points))
+	return nil
 }
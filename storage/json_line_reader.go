@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"compress/gzip"
+	"cryptoCrawl/crawler"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// JsonLineReader reads the trades written by the jline writer, from the
+// open, rotated and compressed files of its path template
+type JsonLineReader struct {
+	path     string
+	encoding string
+}
+
+func NewJsonLineReader(params map[string]string) (Reader, error) {
+	path, ok := params["path"]
+	if !ok {
+		return nil, fmt.Errorf("parameter 'path' should be present")
+	}
+	encoding := JSONEncoding
+	if e, ok := params["encoding"]; ok {
+		if err := checkEncoding(e); err != nil {
+			return nil, err
+		}
+		encoding = e
+	}
+	return &JsonLineReader{path: path, encoding: encoding}, nil
+}
+
+// files returns the files that may hold trades of the query, by name
+func (r *JsonLineReader) files(q Query) ([]string, error) {
+	base := placeholders.ReplaceAllStringFunc(r.path, func(p string) string {
+		switch p {
+		case "{platform}":
+			return q.Platform
+		case "{pair}":
+			return q.Pair
+		case "{meta}":
+			return "trade"
+		}
+		return "*"
+	})
+	ext := filepath.Ext(base)
+	seen := map[string]bool{}
+	var res []string
+	for _, pattern := range []string{base, strings.TrimSuffix(base, ext) + ".*" + ext + "*"} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range matches {
+			if !seen[m] {
+				seen[m] = true
+				res = append(res, m)
+			}
+		}
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// Trades reads the files one by one, in name order, streaming their trades in
+// the order they were written. That is only roughly in time order, crawlers
+// writing with different delays, and files of a path template without a date
+// overlap in time
+func (r *JsonLineReader) Trades(q Query, page func([]crawler.TradeMeasurement) error) error {
+	if err := checkQuery(q); err != nil {
+		return err
+	}
+	files, err := r.files(q)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := r.read(f, q, page); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// read hands the trades of a file to page as they are decoded, errors from
+// page are returned as is
+func (r *JsonLineReader) read(path string, q Query, page func([]crawler.TradeMeasurement) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %s", path, err)
+	}
+	defer file.Close()
+	var in io.Reader = file
+	switch filepath.Ext(path) {
+	case ".gz":
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %s", path, err)
+		}
+		defer gz.Close()
+		in = gz
+	case ".zst":
+		zr, err := zstd.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %s", path, err)
+		}
+		defer zr.Close()
+		in = zr
+	}
+	dec, err := NewDecoder(in, r.encoding)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %s", path, err)
+	}
+	var trades []crawler.TradeMeasurement
+	for {
+		d, err := dec.Decode()
+		// the last line of a file being written may be cut
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if _, ok := err.(unknownTypeError); ok {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error reading %s: %s", path, err)
+		}
+		if p, ok := d.(Projection); ok {
+			d = p.Measurement
+		}
+		t, ok := d.(crawler.TradeMeasurement)
+		if !ok || !q.matches(t) {
+			continue
+		}
+		trades = append(trades, t)
+		if len(trades) == q.pageSize() {
+			if err := page(trades); err != nil {
+				return err
+			}
+			trades = nil
+		}
+	}
+	if len(trades) > 0 {
+		return page(trades)
+	}
+	return nil
+}
@@ -1,33 +1,40 @@
 package storage
 
 import (
-	"encoding/json"
-	"fmt"
-	"os"
-
 	log "github.com/sirupsen/logrus"
 	"time"
 )
 
 type JsonLineStorage struct {
-	file     os.File
-	dataChan chan interface{}
+	files     *rotatingFiles
+	encoding  string
+	period    time.Duration
+	dataChan  chan interface{}
+	closeChan chan bool
+	done      chan struct{}
 }
 
 func NewJsonLineStorage(params map[string]string) (DataWriter, error) {
-	path, ok := params["path"]
-	if !ok {
-		return nil, fmt.Errorf("parameter 'path' not found in param list: %+v", params)
-	}
-	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	cfg, err := parseRotateConfig(params)
 	if err != nil {
 		return nil, err
 	}
+	encoding := JSONEncoding
+	if e, ok := params["encoding"]; ok {
+		if err := checkEncoding(e); err != nil {
+			return nil, err
+		}
+		encoding = e
+	}
 	writer := &JsonLineStorage{
-		file:     *file,
-		dataChan: make(chan interface{}, 10000),
+		files:     newRotatingFiles(cfg, nil),
+		encoding:  encoding,
+		period:    parsePeriod(params),
+		dataChan:  make(chan interface{}, 10000),
+		closeChan: make(chan bool),
+		done:      make(chan struct{}),
 	}
-	log.Infof("successfully created new JL writer with file %s", path)
+	log.Infof("successfully created new JL writer with path %s", cfg.path)
 	go writer.Ingest()
 	return writer, nil
 }
@@ -37,39 +44,38 @@ func (w *JsonLineStorage) Write(d interface{}) {
 }
 
 func (w *JsonLineStorage) Ingest() {
-	var bData []byte
-	ticker := time.NewTicker(10 * time.Second)
-	defer w.Close()
-	sigChan := make(chan os.Signal, 1)
+	defer close(w.done)
+	ticker := time.NewTicker(w.period)
+	defer ticker.Stop()
 	for {
 		select {
 		case l := <-w.dataChan:
-			byts, err := json.Marshal(l)
-			if err != nil {
-				log.Error(err)
-				continue
-			}
-			bData = append(bData, byts...)
-			bData = append(bData, '\n')
+			w.write(l)
 		case <-ticker.C:
-			wr, err := w.file.Write(bData)
-			if err != nil {
-				log.Error(err)
-				continue
-			}
-			if wr != len(bData) {
-				log.Errorf("could not write all data")
-				continue
+			w.files.flush()
+		case <-w.closeChan:
+			for len(w.dataChan) > 0 {
+				w.write(<-w.dataChan)
 			}
-			log.Infof("successfully written %d bytes to %+v", len(bData), w.file)
-			bData = []byte{}
-		case <-sigChan:
-			log.Infof("closing down app")
-			break
+			w.files.close()
+			return
 		}
 	}
 }
 
+func (w *JsonLineStorage) write(l interface{}) {
+	byts, err := marshalRecord(l, w.encoding)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	if err := w.files.write(l, byts); err != nil {
+		log.Error(err)
+	}
+}
+
+// Close flushes and rotates the open files, it returns once they are compressed
 func (w *JsonLineStorage) Close() {
-	w.file.Close()
+	w.closeChan <- true
+	<-w.done
 }
@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"context"
+	"cryptoCrawl/crawler"
+	"fmt"
+	"github.com/segmentio/kafka-go"
+	log "github.com/sirupsen/logrus"
+	"strconv"
+	"time"
+)
+
+var kafkaAcks = map[string]kafka.RequiredAcks{
+	"none": kafka.RequireNone,
+	"one":  kafka.RequireOne,
+	"all":  kafka.RequireAll,
+}
+
+// messageWriter is what KafkaStorage needs from a kafka.Writer
+type messageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// KafkaStorage publishes enveloped measurements to topics templated from
+// their attributes, keyed by platform and pair so that a pair keeps its order
+type KafkaStorage struct {
+	writer      messageWriter
+	bus         busConfig
+	timeout     time.Duration
+	batch       batchConfig
+	dataChannel chan interface{}
+	closeChan   chan bool
+	spool       *Spool
+}
+
+func NewKafkaStorage(params map[string]string) (DataWriter, error) {
+	brokers := splitList(params["brokers"])
+	if len(brokers) == 0 {
+		return nil, fmt.Errorf("parameter 'brokers' should be present")
+	}
+	bus, err := parseBusConfig(params, "topic")
+	if err != nil {
+		return nil, err
+	}
+	acks := kafka.RequireAll
+	if a, ok := params["acks"]; ok {
+		if err := checkAcks(a); err != nil {
+			return nil, err
+		}
+		acks = kafkaAcks[a]
+	}
+	batch := parseBatchConfig(params)
+	w := &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: acks,
+		BatchSize:    batch.maxItems,
+		BatchBytes:   int64(batch.maxBytes),
+		// batches are made by the writer, kafka-go should not wait for more
+		BatchTimeout: 10 * time.Millisecond,
+	}
+	w.AllowAutoTopicCreation, _ = strconv.ParseBool(params["create_topics"])
+	log.Infof("successfully created new kafka writer for %v", brokers)
+	return newKafkaStorage(w, bus, batch), nil
+}
+
+func newKafkaStorage(w messageWriter, bus busConfig, batch batchConfig) *KafkaStorage {
+	res := &KafkaStorage{
+		writer:      w,
+		bus:         bus,
+		timeout:     30 * time.Second,
+		batch:       batch,
+		dataChannel: make(chan interface{}, 10000),
+		closeChan:   make(chan bool),
+	}
+	// batches are published one at a time to keep the order of a pair
+	res.batch.maxInFlight = 1
+	go res.Ingest()
+	return res
+}
+
+func (k *KafkaStorage) Write(d interface{}) {
+	k.dataChannel <- d
+}
+
+func (k *KafkaStorage) Ingest() {
+	b := newBatcher(k.batch, docSize, k.process)
+	b.serial = k.spooled
+	for {
+		select {
+		case d := <-k.dataChannel:
+			b.add(d)
+		case <-b.expired():
+			b.send()
+		case <-k.closeChan:
+			b.close()
+			if k.spool != nil {
+				k.spool.Close()
+			}
+			if err := k.writer.Close(); err != nil {
+				log.Errorf("error closing kafka writer: %s", err)
+			}
+			return
+		}
+	}
+}
+
+func (k *KafkaStorage) Close() {
+	k.closeChan <- true
+}
+
+func (k *KafkaStorage) setSpool(s *Spool) {
+	k.spool = s
+}
+
+func (k *KafkaStorage) spooled() bool {
+	return k.spool != nil
+}
+
+func (k *KafkaStorage) process(data []interface{}) {
+	if len(data) == 0 {
+		return
+	}
+	if k.spool != nil {
+		k.spool.Deliver(data)
+	} else if err := writeBatch("kafka", k, data); err != nil {
+		log.Error(err)
+	}
+}
+
+// WriteBatch publishes the batch, the messages kafka refused are returned
+// in a PartialError
+func (k *KafkaStorage) WriteBatch(data []interface{}) error {
+	msgs, errs := k.bus.messages(data)
+	for _, err := range errs {
+		log.Errorf("dropping measurement: %s", err)
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	headers := []kafka.Header{
+		{Key: "content-type", Value: []byte(k.bus.contentType())},
+		{Key: "schema-version", Value: []byte(strconv.Itoa(EnvelopeVersion))},
+	}
+	kmsgs := make([]kafka.Message, len(msgs))
+	for i, m := range msgs {
+		kmsgs[i] = kafka.Message{Topic: m.topic, Key: m.key, Value: m.value, Headers: headers}
+		if a, ok := attributes(m.item); ok && a.time > 0 {
+			kmsgs[i].Time = crawler.FromMillis(a.time)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), k.timeout)
+	defer cancel()
+	err := k.writer.WriteMessages(ctx, kmsgs...)
+	if err == nil {
+		log.Infof("successfully published %d messages to kafka", len(kmsgs))
+		return nil
+	}
+	if werrs, ok := err.(kafka.WriteErrors); ok && len(werrs) == len(msgs) {
+		var failed []interface{}
+		for i, e := range werrs {
+			if e != nil {
+				failed = append(failed, msgs[i].item)
+			}
+		}
+		if len(failed) < len(msgs) {
+			return &PartialError{Failed: failed, Err: err}
+		}
+	}
+	return fmt.Errorf("error publishing %d messages to kafka: %s", len(kmsgs), err)
+}
+
+func checkAcks(v string) error {
+	if _, ok := kafkaAcks[v]; !ok {
+		return fmt.Errorf("unknown acks %s, use none, one or all", v)
+	}
+	return nil
+}
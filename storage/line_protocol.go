@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"cryptoCrawl/crawler"
+	"github.com/influxdata/influxdb/client/v2"
+	log "github.com/sirupsen/logrus"
+)
+
+// encodeLine is the line protocol of an InfluxIngestable with its timestamp
+// in the given precision, newline included
+func encodeLine(d interface{}, precision string) ([]byte, error) {
+	m := d.(crawler.InfluxIngestable).AsInfluxMeasurement()
+	p, err := client.NewPoint(m.Measurement, m.Tags, m.Fields, m.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(p.PrecisionString(precision) + "\n"), nil
+}
+
+// encodeLines skips the items that can not be made into points
+func encodeLines(data []interface{}, precision string) []byte {
+	var res []byte
+	for _, d := range data {
+		line, err := encodeLine(d, precision)
+		if err != nil {
+			log.Errorf("error making a point out of %+v: %s", d, err)
+			continue
+		}
+		res = append(res, line...)
+	}
+	return res
+}
@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"cryptoCrawl/crawler"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// keeps udp datagrams under the usual MTU
+	defaultMaxPacket = 1400
+	dialTimeout      = 5 * time.Second
+)
+
+// LineStorageService sends raw line protocol over udp, fire and forget, or tcp
+type LineStorageService struct {
+	protocol  string
+	address   string
+	precision string
+	maxPacket int
+	// batches share the connection
+	locker      sync.Mutex
+	conn        net.Conn
+	batch       batchConfig
+	dataChannel chan crawler.InfluxIngestable
+	closeChan   chan bool
+	spool       *Spool
+}
+
+func NewLineStorage(params map[string]string) (DataWriter, error) {
+	address, ok := params["address"]
+	if !ok {
+		return nil, fmt.Errorf("parameter 'address' should be present")
+	}
+	protocol := "udp"
+	if p, ok := params["protocol"]; ok {
+		protocol = p
+	}
+	if err := checkProtocol(protocol); err != nil {
+		return nil, err
+	}
+	// the udp listener of influx takes nanoseconds unless configured otherwise
+	precision := "ns"
+	if p, ok := params["precision"]; ok {
+		if err := checkPrecision(p); err != nil {
+			return nil, err
+		}
+		precision = p
+	}
+	res := &LineStorageService{
+		protocol:    protocol,
+		address:     address,
+		precision:   precision,
+		maxPacket:   parseInt(params, "max_packet", defaultMaxPacket),
+		batch:       parseBatchConfig(params),
+		dataChannel: make(chan crawler.InfluxIngestable, 10000),
+		closeChan:   make(chan bool),
+	}
+	if err := res.dial(); err != nil {
+		return nil, err
+	}
+	go res.Ingest()
+	return res, nil
+}
+
+func (l *LineStorageService) dial() error {
+	conn, err := net.DialTimeout(l.protocol, l.address, dialTimeout)
+	if err != nil {
+		return err
+	}
+	l.conn = conn
+	return nil
+}
+
+func (l *LineStorageService) Write(data interface{}) {
+	if ii, ok := data.(crawler.InfluxIngestable); ok {
+		l.dataChannel <- ii
+	} else {
+		log.Errorf("%+v could not be converted to InfluxIngestable", data)
+	}
+}
+
+func (l *LineStorageService) Ingest() {
+	b := newBatcher(l.batch, pointSize, l.process)
+	b.serial = l.spooled
+	for {
+		select {
+		case d := <-l.dataChannel:
+			b.add(d)
+		case <-b.expired():
+			b.send()
+		case <-l.closeChan:
+			b.close()
+			if l.spool != nil {
+				l.spool.Close()
+			}
+			l.locker.Lock()
+			if l.conn != nil {
+				l.conn.Close()
+			}
+			l.locker.Unlock()
+			return
+		}
+	}
+}
+
+func (l *LineStorageService) Close() {
+	l.closeChan <- true
+}
+
+func (l *LineStorageService) setSpool(s *Spool) {
+	l.spool = s
+}
+
+func (l *LineStorageService) spooled() bool {
+	return l.spool != nil
+}
+
+func (l *LineStorageService) process(data []interface{}) {
+	if len(data) == 0 {
+		return
+	}
+	if l.spool != nil {
+		l.spool.Deliver(data)
+	} else if err := writeBatch("line", l, data); err != nil {
+		log.Error(err)
+	}
+}
+
+// WriteBatch sends the lines in packets of at most max_packet bytes over udp,
+// tcp connections are dialed again after an error
+func (l *LineStorageService) WriteBatch(data []interface{}) error {
+	l.locker.Lock()
+	defer l.locker.Unlock()
+	if l.conn == nil {
+		if err := l.dial(); err != nil {
+			return err
+		}
+	}
+	var packet []byte
+	for _, d := range data {
+		line, err := encodeLine(d, l.precision)
+		if err != nil {
+			log.Errorf("error making a point out of %+v: %s", d, err)
+			continue
+		}
+		if l.protocol == "udp" && len(packet) > 0 && len(packet)+len(line) > l.maxPacket {
+			if err := l.send(packet); err != nil {
+				return err
+			}
+			packet = nil
+		}
+		packet = append(packet, line...)
+	}
+	if len(packet) > 0 {
+		if err := l.send(packet); err != nil {
+			return err
+		}
+	}
+	log.Infof("successfully sent %d points to %s://%s", len(data), l.protocol, l.address)
+	return nil
+}
+
+func (l *LineStorageService) send(packet []byte) error {
+	if _, err := l.conn.Write(packet); err != nil {
+		l.conn.Close()
+		l.conn = nil
+		return fmt.Errorf("error sending points to %s://%s: %s", l.protocol, l.address, err)
+	}
+	return nil
+}
+
+func checkProtocol(v string) error {
+	if v != "udp" && v != "tcp" {
+		return fmt.Errorf("unknown protocol %s, use udp or tcp", v)
+	}
+	return nil
+}
+
+func checkAddress(v string) error {
+	_, port, err := net.SplitHostPort(v)
+	if err != nil {
+		return err
+	}
+	if _, err := strconv.Atoi(port); err != nil {
+		return fmt.Errorf("invalid port %s", port)
+	}
+	return nil
+}
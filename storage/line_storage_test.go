@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"cryptoCrawl/crawler"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var lineTrade = crawler.TradeMeasurement{Meta: "trade", Pair: "BTCUSD", Platform: "binance", Price: 2, Amount: 1, Timestamp: 1517443200123}
+
+func TestLineUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	w, err := NewLineStorage(map[string]string{"address": conn.LocalAddr().String(), "precision": "ms", "max_packet": "100"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.(Closer).Close()
+	if err := w.(BatchWriter).WriteBatch([]interface{}{lineTrade, lineTrade}); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1500)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	// each line goes in its own packet as two do not fit in 100 bytes
+	for i := 0; i < 2; i++ {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := "trade,pair=BTCUSD,platform=binance amount=1,price=2 1517443200123\n"
+		if string(buf[:n]) != expected {
+			t.Errorf("expected %q, got %q", expected, buf[:n])
+		}
+	}
+}
+
+func TestInfluxV2(t *testing.T) {
+	bodies := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			return
+		}
+		if r.Header.Get("Authorization") != "Token secret" || r.URL.Query().Get("bucket") != "crypto" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		bits, _ := ioutil.ReadAll(r.Body)
+		bodies <- string(bits)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	params := map[string]string{"host": srv.URL, "org": "me", "bucket": "crypto", "token": "secret"}
+	w, err := NewInfluxV2Storage(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.(Closer).Close()
+	if err := w.(BatchWriter).WriteBatch([]interface{}{lineTrade}); err != nil {
+		t.Fatal(err)
+	}
+	if b := <-bodies; !strings.HasSuffix(b, " 1517443200123\n") {
+		t.Errorf("expected a point in milliseconds, got %q", b)
+	}
+
+	params["token"] = "wrong"
+	w, err = NewInfluxV2Storage(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.(Closer).Close()
+	if err := w.(BatchWriter).WriteBatch([]interface{}{lineTrade}); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected the rejected write to fail, got %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+var (
+	batchSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "cryptocrawl",
+		Subsystem: "writer",
+		Name:      "batch_size",
+		Help:      "Measurements per batch handed to a writer.",
+		Buckets:   prometheus.ExponentialBuckets(1, 4, 8),
+	}, []string{"writer"})
+	flushSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "cryptocrawl",
+		Subsystem: "writer",
+		Name:      "flush_seconds",
+		Help:      "Time taken to write a batch.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"writer"})
+	flushFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cryptocrawl",
+		Subsystem: "writer",
+		Name:      "flush_failures_total",
+		Help:      "Batches a writer failed to write, at least partly.",
+	}, []string{"writer"})
+	queueDepth = prometheus.NewDesc("cryptocrawl_writer_queue_depth",
+		"Measurements waiting in memory in front of a writer.", []string{"writer"}, nil)
+	queueDropped = prometheus.NewDesc("cryptocrawl_writer_dropped_total",
+		"Measurements a full or closed writer queue dropped.", []string{"writer"}, nil)
+	queueEnqueued = prometheus.NewDesc("cryptocrawl_writer_enqueued_total",
+		"Measurements put in the queue of a writer.", []string{"writer"}, nil)
+	queueWritten = prometheus.NewDesc("cryptocrawl_writer_written_total",
+		"Measurements a writer queue handed to its writer.", []string{"writer"}, nil)
+	queueSpilled = prometheus.NewDesc("cryptocrawl_writer_spilled_total",
+		"Measurements a full writer queue spilled to disk.", []string{"writer"}, nil)
+	spoolSpooled = prometheus.NewDesc("cryptocrawl_spool_spooled_total",
+		"Measurements a writer failed to deliver and spooled to disk.", []string{"writer"}, nil)
+	spoolDelivered = prometheus.NewDesc("cryptocrawl_spool_delivered_total",
+		"Spooled measurements delivered on a retry.", []string{"writer"}, nil)
+	spoolDropped = prometheus.NewDesc("cryptocrawl_spool_dropped_total",
+		"Spooled measurements dropped past the disk cap of the spool.", []string{"writer"}, nil)
+	spoolBytes = prometheus.NewDesc("cryptocrawl_spool_bytes",
+		"Bytes of spooled measurements waiting on disk.", []string{"writer"}, nil)
+)
+
+func init() {
+	prometheus.MustRegister(batchSize, flushSeconds, flushFailures, queueCollector{}, spoolCollector{})
+}
+
+// queueCollector reports the queues running when scraped
+type queueCollector struct{}
+
+func (c queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueDepth
+	ch <- queueDropped
+	ch <- queueEnqueued
+	ch <- queueWritten
+	ch <- queueSpilled
+}
+
+func (c queueCollector) Collect(ch chan<- prometheus.Metric) {
+	for name, s := range QueuesStats() {
+		ch <- prometheus.MustNewConstMetric(queueDepth, prometheus.GaugeValue, float64(s.Depth), name)
+		ch <- prometheus.MustNewConstMetric(queueDropped, prometheus.CounterValue, float64(s.Dropped), name)
+		ch <- prometheus.MustNewConstMetric(queueEnqueued, prometheus.CounterValue, float64(s.Enqueued), name)
+		ch <- prometheus.MustNewConstMetric(queueWritten, prometheus.CounterValue, float64(s.Written), name)
+		ch <- prometheus.MustNewConstMetric(queueSpilled, prometheus.CounterValue, float64(s.Spilled), name)
+	}
+}
+
+// spoolCollector reports the spools open when scraped
+type spoolCollector struct{}
+
+func (c spoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- spoolSpooled
+	ch <- spoolDelivered
+	ch <- spoolDropped
+	ch <- spoolBytes
+}
+
+func (c spoolCollector) Collect(ch chan<- prometheus.Metric) {
+	for name, s := range SpoolsStats() {
+		ch <- prometheus.MustNewConstMetric(spoolSpooled, prometheus.CounterValue, float64(s.Spooled), name)
+		ch <- prometheus.MustNewConstMetric(spoolDelivered, prometheus.CounterValue, float64(s.Delivered), name)
+		ch <- prometheus.MustNewConstMetric(spoolDropped, prometheus.CounterValue, float64(s.Dropped), name)
+		ch <- prometheus.MustNewConstMetric(spoolBytes, prometheus.GaugeValue, float64(s.Bytes), name)
+	}
+}
+
+// writeBatch writes a batch through w, observing its size, how long it took
+// and whether it failed
+func writeBatch(name string, w BatchWriter, batch []interface{}) error {
+	start := time.Now()
+	err := w.WriteBatch(batch)
+	batchSize.WithLabelValues(name).Observe(float64(len(batch)))
+	flushSeconds.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	if err != nil {
+		flushFailures.WithLabelValues(name).Inc()
+	}
+	return err
+}
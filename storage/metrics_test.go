@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteBatchMetrics(t *testing.T) {
+	batchSize.DeleteLabelValues("metrics")
+	flushFailures.DeleteLabelValues("metrics")
+	w := &flakyWriter{}
+	batch := tradeBatch(1, 2)
+	if err := writeBatch("metrics", w, batch); err != nil {
+		t.Fatal(err)
+	}
+	w.setDown(true)
+	if err := writeBatch("metrics", w, batch); err == nil {
+		t.Fatal("expected the batch to fail")
+	}
+	if n := testutil.ToFloat64(flushFailures.WithLabelValues("metrics")); n != 1 {
+		t.Errorf("expected 1 failure, got %v", n)
+	}
+	expected := `
+# HELP cryptocrawl_writer_batch_size Measurements per batch handed to a writer.
+# TYPE cryptocrawl_writer_batch_size histogram
+cryptocrawl_writer_batch_size_bucket{writer="metrics",le="1"} 0
+cryptocrawl_writer_batch_size_bucket{writer="metrics",le="4"} 2
+cryptocrawl_writer_batch_size_bucket{writer="metrics",le="16"} 2
+cryptocrawl_writer_batch_size_bucket{writer="metrics",le="64"} 2
+cryptocrawl_writer_batch_size_bucket{writer="metrics",le="256"} 2
+cryptocrawl_writer_batch_size_bucket{writer="metrics",le="1024"} 2
+cryptocrawl_writer_batch_size_bucket{writer="metrics",le="4096"} 2
+cryptocrawl_writer_batch_size_bucket{writer="metrics",le="16384"} 2
+cryptocrawl_writer_batch_size_bucket{writer="metrics",le="+Inf"} 2
+cryptocrawl_writer_batch_size_sum{writer="metrics"} 4
+cryptocrawl_writer_batch_size_count{writer="metrics"} 2
+`
+	if err := testutil.CollectAndCompare(batchSize, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQueueMetrics(t *testing.T) {
+	w := &gatedWriter{gate: make(chan struct{})}
+	q, err := NewQueue("metrics", w, QueueConfig{Size: 2, Policy: DropNewest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first item is held by the writer, two fit in the queue
+	trades(q, 1)
+	deadline := time.Now().Add(5 * time.Second)
+	for q.Stats().Depth > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	trades(q, 3)
+	expected := `
+# HELP cryptocrawl_writer_dropped_total Measurements a full or closed writer queue dropped.
+# TYPE cryptocrawl_writer_dropped_total counter
+cryptocrawl_writer_dropped_total{writer="metrics"} 1
+# HELP cryptocrawl_writer_enqueued_total Measurements put in the queue of a writer.
+# TYPE cryptocrawl_writer_enqueued_total counter
+cryptocrawl_writer_enqueued_total{writer="metrics"} 3
+# HELP cryptocrawl_writer_queue_depth Measurements waiting in memory in front of a writer.
+# TYPE cryptocrawl_writer_queue_depth gauge
+cryptocrawl_writer_queue_depth{writer="metrics"} 2
+# HELP cryptocrawl_writer_spilled_total Measurements a full writer queue spilled to disk.
+# TYPE cryptocrawl_writer_spilled_total counter
+cryptocrawl_writer_spilled_total{writer="metrics"} 0
+# HELP cryptocrawl_writer_written_total Measurements a writer queue handed to its writer.
+# TYPE cryptocrawl_writer_written_total counter
+cryptocrawl_writer_written_total{writer="metrics"} 0
+`
+	if err := testutil.CollectAndCompare(queueCollector{}, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+	close(w.gate)
+	q.Close()
+}
+
+func TestSpoolMetrics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := NewSpool("metrics", &flakyWriter{down: true}, SpoolConfig{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Deliver(tradeBatch(1, 3))
+	expected := `
+# HELP cryptocrawl_spool_delivered_total Spooled measurements delivered on a retry.
+# TYPE cryptocrawl_spool_delivered_total counter
+cryptocrawl_spool_delivered_total{writer="metrics"} 0
+# HELP cryptocrawl_spool_spooled_total Measurements a writer failed to deliver and spooled to disk.
+# TYPE cryptocrawl_spool_spooled_total counter
+cryptocrawl_spool_spooled_total{writer="metrics"} 3
+`
+	err = testutil.CollectAndCompare(spoolCollector{}, strings.NewReader(expected),
+		"cryptocrawl_spool_spooled_total", "cryptocrawl_spool_delivered_total")
+	if err != nil {
+		t.Error(err)
+	}
+}
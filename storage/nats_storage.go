@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/nats-io/nats.go"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// publisher is what NatsStorage needs from a nats.Conn
+type publisher interface {
+	Publish(subject string, data []byte) error
+	FlushTimeout(timeout time.Duration) error
+	Close()
+}
+
+// NatsStorage publishes enveloped measurements to subjects templated from
+// their attributes, a batch is confirmed by a flush to the server
+type NatsStorage struct {
+	conn        publisher
+	bus         busConfig
+	timeout     time.Duration
+	batch       batchConfig
+	dataChannel chan interface{}
+	closeChan   chan bool
+	spool       *Spool
+}
+
+func NewNatsStorage(params map[string]string) (DataWriter, error) {
+	url, ok := params["url"]
+	if !ok {
+		return nil, fmt.Errorf("parameter 'url' should be present")
+	}
+	bus, err := parseBusConfig(params, "subject")
+	if err != nil {
+		return nil, err
+	}
+	conn, err := nats.Connect(url,
+		nats.Name("cryptoCrawl"),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			log.Warnf("disconnected from nats: %v", err)
+		}),
+		nats.ReconnectHandler(func(c *nats.Conn) {
+			log.Infof("reconnected to nats at %s", c.ConnectedUrl())
+		}))
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("successfully created new nats writer for %s", url)
+	return newNatsStorage(conn, bus, parseBatchConfig(params)), nil
+}
+
+func newNatsStorage(conn publisher, bus busConfig, batch batchConfig) *NatsStorage {
+	res := &NatsStorage{
+		conn:        conn,
+		bus:         bus,
+		timeout:     10 * time.Second,
+		batch:       batch,
+		dataChannel: make(chan interface{}, 10000),
+		closeChan:   make(chan bool),
+	}
+	// batches are published one at a time to keep the order of a pair
+	res.batch.maxInFlight = 1
+	go res.Ingest()
+	return res
+}
+
+func (n *NatsStorage) Write(d interface{}) {
+	n.dataChannel <- d
+}
+
+func (n *NatsStorage) Ingest() {
+	b := newBatcher(n.batch, docSize, n.process)
+	b.serial = n.spooled
+	for {
+		select {
+		case d := <-n.dataChannel:
+			b.add(d)
+		case <-b.expired():
+			b.send()
+		case <-n.closeChan:
+			b.close()
+			if n.spool != nil {
+				n.spool.Close()
+			}
+			n.conn.Close()
+			return
+		}
+	}
+}
+
+func (n *NatsStorage) Close() {
+	n.closeChan <- true
+}
+
+func (n *NatsStorage) setSpool(s *Spool) {
+	n.spool = s
+}
+
+func (n *NatsStorage) spooled() bool {
+	return n.spool != nil
+}
+
+func (n *NatsStorage) process(data []interface{}) {
+	if len(data) == 0 {
+		return
+	}
+	if n.spool != nil {
+		n.spool.Deliver(data)
+	} else if err := writeBatch("nats", n, data); err != nil {
+		log.Error(err)
+	}
+}
+
+// WriteBatch publishes the batch then waits for the server to have it all,
+// when publishing stops halfway the rest is returned in a PartialError
+func (n *NatsStorage) WriteBatch(data []interface{}) error {
+	msgs, errs := n.bus.messages(data)
+	for _, err := range errs {
+		log.Errorf("dropping measurement: %s", err)
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	for i, m := range msgs {
+		if err := n.conn.Publish(m.topic, m.value); err != nil {
+			if i == 0 {
+				return fmt.Errorf("error publishing %d messages to nats: %s", len(msgs), err)
+			}
+			failed := make([]interface{}, 0, len(msgs)-i)
+			for _, f := range msgs[i:] {
+				failed = append(failed, f.item)
+			}
+			// what got published is flushed with the next batch
+			return &PartialError{Failed: failed, Err: err}
+		}
+	}
+	if err := n.conn.FlushTimeout(n.timeout); err != nil {
+		return fmt.Errorf("error flushing %d messages to nats: %s", len(msgs), err)
+	}
+	log.Infof("successfully published %d messages to nats", len(msgs))
+	return nil
+}
@@ -0,0 +1,285 @@
+package storage
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ParamError points at the writer param that failed validation
+type ParamError struct {
+	Param string
+	Err   error
+}
+
+func (e *ParamError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Param, e.Err)
+}
+
+type paramCheck func(v string) error
+
+type writerSpec struct {
+	required []string
+	// every accepted param, a nil check accepts any value
+	params map[string]paramCheck
+	// failed batches can be spooled
+	spools bool
+}
+
+var (
+	writerSpecs = map[string]writerSpec{
+		"elasticsearch": {
+			required: []string{"host"},
+			params: map[string]paramCheck{
+				"host":          checkURL,
+				"mapping":       checkFile,
+				"index":         nil,
+				"index_period":  checkIndexPeriod,
+				"retention":     checkDuration,
+				"ilm_policy":    nil,
+				"version":       checkVersion,
+				"period":        checkDuration,
+				"batch_size":    checkPositive,
+				"batch_bytes":   checkPositive,
+				"max_in_flight": checkPositive,
+			},
+			spools: true,
+		},
+		"influxdb": {
+			required: []string{"host"},
+			params: map[string]paramCheck{
+				"host":               checkURL,
+				"db":                 nil,
+				"retention_policy":   nil,
+				"retention_duration": checkDuration,
+				"precision":          checkPrecision,
+				"username":           nil,
+				"password":           nil,
+				"downsample":         checkDurations,
+				"downsample_policy":  nil,
+				"period":             checkDuration,
+				"batch_size":         checkPositive,
+				"batch_bytes":        checkPositive,
+				"max_in_flight":      checkPositive,
+			},
+			spools: true,
+		},
+		"influxdb2": {
+			required: []string{"host", "org", "bucket", "token"},
+			params: map[string]paramCheck{
+				"host":          checkURL,
+				"org":           nil,
+				"bucket":        nil,
+				"token":         nil,
+				"precision":     checkPrecision,
+				"period":        checkDuration,
+				"batch_size":    checkPositive,
+				"batch_bytes":   checkPositive,
+				"max_in_flight": checkPositive,
+			},
+			spools: true,
+		},
+		"line": {
+			required: []string{"address"},
+			params: map[string]paramCheck{
+				"address":       checkAddress,
+				"protocol":      checkProtocol,
+				"precision":     checkPrecision,
+				"max_packet":    checkPositive,
+				"period":        checkDuration,
+				"batch_size":    checkPositive,
+				"batch_bytes":   checkPositive,
+				"max_in_flight": checkPositive,
+			},
+			spools: true,
+		},
+		"jline": {
+			required: []string{"path"},
+			params: map[string]paramCheck{
+				"path":         checkPathTemplate,
+				"encoding":     checkEncoding,
+				"period":       checkDuration,
+				"rotate_size":  checkPositive,
+				"rotate_every": checkDuration,
+				"rotate_idle":  checkDuration,
+				"compress":     checkCompression,
+				"fsync":        checkSyncPolicy,
+				"retain":       checkPositive,
+			},
+		},
+		"postgres": {
+			required: []string{"dsn"},
+			params: map[string]paramCheck{
+				"dsn":           nil,
+				"period":        checkDuration,
+				"batch_size":    checkPositive,
+				"batch_bytes":   checkPositive,
+				"max_in_flight": checkPositive,
+			},
+			spools: true,
+		},
+		"sqlite": {
+			required: []string{"path"},
+			params: map[string]paramCheck{
+				"path":        checkDir,
+				"period":      checkDuration,
+				"batch_size":  checkPositive,
+				"batch_bytes": checkPositive,
+			},
+			spools: true,
+		},
+		"kafka": {
+			required: []string{"brokers"},
+			params: map[string]paramCheck{
+				"brokers":       nil,
+				"topic":         nil,
+				"encoding":      checkEncoding,
+				"acks":          checkAcks,
+				"create_topics": checkBool,
+				"period":        checkDuration,
+				"batch_size":    checkPositive,
+				"batch_bytes":   checkPositive,
+			},
+			spools: true,
+		},
+		"nats": {
+			required: []string{"url"},
+			params: map[string]paramCheck{
+				"url":         nil,
+				"subject":     nil,
+				"encoding":    checkEncoding,
+				"period":      checkDuration,
+				"batch_size":  checkPositive,
+				"batch_bytes": checkPositive,
+			},
+			spools: true,
+		},
+		"csv": {
+			required: []string{"path"},
+			params: map[string]paramCheck{
+				"path":         checkCsvPath,
+				"delimiter":    checkDelimiter,
+				"time_format":  checkTimeFormat,
+				"period":       checkDuration,
+				"rotate_size":  checkPositive,
+				"rotate_every": checkDuration,
+				"rotate_idle":  checkDuration,
+				"compress":     checkCompression,
+				"fsync":        checkSyncPolicy,
+				"retain":       checkPositive,
+			},
+		},
+		"parquet": {
+			required: []string{"path"},
+			params: map[string]paramCheck{
+				"path":           checkDir,
+				"period":         checkDuration,
+				"row_group_size": checkPositive,
+				"compress":       checkParquetCompression,
+				"rotate_every":   checkDuration,
+				"rotate_idle":    checkDuration,
+			},
+		},
+		"websocket": {
+			required: []string{"address"},
+			params: map[string]paramCheck{
+				"address": checkAddress,
+				"path":    nil,
+				"buffer":  checkPositive,
+			},
+		},
+	}
+)
+
+// ValidateParams checks the params of a writer without connecting anywhere,
+// problems with a single param are reported as *ParamError
+func ValidateParams(name string, params map[string]string) []error {
+	spec, ok := writerSpecs[name]
+	if !ok {
+		return []error{fmt.Errorf("unknown writer %s", name)}
+	}
+	var errs []error
+	for _, r := range spec.required {
+		if _, ok := params[r]; !ok {
+			errs = append(errs, &ParamError{Param: r, Err: fmt.Errorf("should be present")})
+		}
+	}
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		check, ok := spec.params[k]
+		if !ok {
+			errs = append(errs, &ParamError{Param: k, Err: fmt.Errorf("unknown param for writer %s", name)})
+			continue
+		}
+		if check == nil {
+			continue
+		}
+		if err := check(params[k]); err != nil {
+			errs = append(errs, &ParamError{Param: k, Err: err})
+		}
+	}
+	return errs
+}
+
+func checkURL(v string) error {
+	u, err := url.Parse(v)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("%q should be an absolute url like http://localhost:9200", v)
+	}
+	return nil
+}
+
+func checkDuration(v string) error {
+	_, err := time.ParseDuration(v)
+	return err
+}
+
+func checkPositive(v string) error {
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("expected an integer, got %q", v)
+	}
+	if i <= 0 {
+		return fmt.Errorf("should be positive")
+	}
+	return nil
+}
+
+func checkBool(v string) error {
+	_, err := strconv.ParseBool(v)
+	return err
+}
+
+func checkFile(v string) error {
+	fi, err := os.Stat(v)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return fmt.Errorf("%s points to a directory", v)
+	}
+	return nil
+}
+
+// checkDir makes sure the file can be created
+func checkDir(v string) error {
+	fi, err := os.Stat(filepath.Dir(v))
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", filepath.Dir(v))
+	}
+	return nil
+}
@@ -0,0 +1,320 @@
+package storage
+
+import (
+	"cryptoCrawl/crawler"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/source"
+	"github.com/xitongsys/parquet-go/writer"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	Snappy = "snappy"
+
+	defaultRowGroupSize   = 10000
+	defaultParquetRotate  = time.Hour
+	parquetWriterParallel = 4
+)
+
+var parquetCodecs = map[string]parquet.CompressionCodec{
+	NoCompression: parquet.CompressionCodec_UNCOMPRESSED,
+	Snappy:        parquet.CompressionCodec_SNAPPY,
+	Gzip:          parquet.CompressionCodec_GZIP,
+	Zstd:          parquet.CompressionCodec_ZSTD,
+}
+
+// rows of the parquet files, one schema per meta so that every file of a
+// partition has the same columns
+
+type parquetTrade struct {
+	Platform        string  `parquet:"name=platform, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Pair            string  `parquet:"name=pair, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	TradeType       string  `parquet:"name=trade_type, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	TransactionType string  `parquet:"name=type, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	TradeID         string  `parquet:"name=trade_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Amount          float64 `parquet:"name=amount, type=DOUBLE"`
+	Price           float64 `parquet:"name=price, type=DOUBLE"`
+	Time            int64   `parquet:"name=time, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+}
+
+type parquetOrder struct {
+	Platform string  `parquet:"name=platform, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Pair     string  `parquet:"name=pair, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Type     string  `parquet:"name=type, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Amount   float64 `parquet:"name=amount, type=DOUBLE"`
+	Price    float64 `parquet:"name=price, type=DOUBLE"`
+	Time     int64   `parquet:"name=time, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+}
+
+// cancels come as orders from some platforms, the amount is zero otherwise
+type parquetCancel parquetOrder
+
+var parquetSchemas = map[string]func() interface{}{
+	"trade":  func() interface{} { return new(parquetTrade) },
+	"order":  func() interface{} { return new(parquetOrder) },
+	"cancel": func() interface{} { return new(parquetCancel) },
+}
+
+// parquetRow converts a measurement to the row of its meta schema
+func parquetRow(d interface{}) (string, interface{}, bool) {
+	if p, ok := d.(Projection); ok {
+		d = p.Measurement
+	}
+	switch v := d.(type) {
+	case crawler.TradeMeasurement:
+		if v.Meta != "trade" {
+			break
+		}
+		return v.Meta, &parquetTrade{v.Platform, v.Pair, v.TradeType, v.TransactionType, v.TradeID, v.Amount, v.Price, v.Timestamp}, true
+	case crawler.OrderMeasurement:
+		row := parquetOrder{v.Platform, v.Pair, v.Type, v.Amount, v.Price, v.Timestamp}
+		switch v.Meta {
+		case "order":
+			return v.Meta, &row, true
+		case "cancel":
+			c := parquetCancel(row)
+			return v.Meta, &c, true
+		}
+	case crawler.CancelMeasurement:
+		if v.Meta != "cancel" {
+			break
+		}
+		return v.Meta, &parquetCancel{Platform: v.Platform, Pair: v.Pair, Type: v.Type, Price: v.Price, Time: v.TimeStamp}, true
+	}
+	return "", nil, false
+}
+
+// parquetPartition is the hive style directory of a measurement, like
+// meta=trade/platform=binance/date=2018-02-01
+func parquetPartition(meta string, d interface{}) string {
+	m, _ := attributes(d)
+	t := time.Now()
+	if m.time > 0 {
+		t = crawler.FromMillis(m.time)
+	}
+	platform := m.platform
+	if platform == "" {
+		platform = "unknown"
+	}
+	return filepath.Join("meta="+meta, "platform="+platform, "date="+t.UTC().Format("2006-01-02"))
+}
+
+type parquetFile struct {
+	fw source.ParquetFile
+	pw *writer.ParquetWriter
+	// written to tmp, renamed to path once complete
+	tmp, path string
+	// rows not yet flushed as a row group
+	pending int
+	opened  time.Time
+	written time.Time
+}
+
+// ParquetStorage writes measurements to parquet files partitioned by meta,
+// platform and date, a file is readable once rotated
+type ParquetStorage struct {
+	dir          string
+	codec        parquet.CompressionCodec
+	rowGroupSize int
+	maxAge       time.Duration
+	idle         time.Duration
+	period       time.Duration
+	files        map[string]*parquetFile
+	dataChan     chan interface{}
+	closeChan    chan bool
+	done         chan struct{}
+}
+
+func NewParquetStorage(params map[string]string) (DataWriter, error) {
+	dir, ok := params["path"]
+	if !ok {
+		return nil, fmt.Errorf("parameter 'path' should be present")
+	}
+	w := &ParquetStorage{
+		dir:          dir,
+		codec:        parquet.CompressionCodec_SNAPPY,
+		rowGroupSize: parseInt(params, "row_group_size", defaultRowGroupSize),
+		maxAge:       defaultParquetRotate,
+		idle:         defaultRotateIdle,
+		period:       parsePeriod(params),
+		files:        map[string]*parquetFile{},
+		dataChan:     make(chan interface{}, 10000),
+		closeChan:    make(chan bool),
+		done:         make(chan struct{}),
+	}
+	if c, ok := params["compress"]; ok {
+		if err := checkParquetCompression(c); err != nil {
+			return nil, err
+		}
+		w.codec = parquetCodecs[c]
+	}
+	var err error
+	if v, ok := params["rotate_every"]; ok {
+		if w.maxAge, err = time.ParseDuration(v); err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := params["rotate_idle"]; ok {
+		if w.idle, err = time.ParseDuration(v); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	log.Infof("successfully created new parquet writer with path %s", dir)
+	go w.Ingest()
+	return w, nil
+}
+
+func (w *ParquetStorage) Write(d interface{}) {
+	w.dataChan <- d
+}
+
+func (w *ParquetStorage) Ingest() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.period)
+	defer ticker.Stop()
+	for {
+		select {
+		case d := <-w.dataChan:
+			if err := w.write(d); err != nil {
+				log.Error(err)
+			}
+		case <-ticker.C:
+			w.rotateExpired()
+		case <-w.closeChan:
+			for len(w.dataChan) > 0 {
+				if err := w.write(<-w.dataChan); err != nil {
+					log.Error(err)
+				}
+			}
+			for _, p := range w.partitions() {
+				w.finish(p)
+			}
+			return
+		}
+	}
+}
+
+func (w *ParquetStorage) write(d interface{}) error {
+	meta, row, ok := parquetRow(d)
+	if !ok {
+		return fmt.Errorf("parquet writer can't write %T", d)
+	}
+	partition := parquetPartition(meta, d)
+	f, ok := w.files[partition]
+	if !ok {
+		var err error
+		if f, err = w.open(partition, meta); err != nil {
+			return err
+		}
+	}
+	if err := f.pw.Write(row); err != nil {
+		return fmt.Errorf("error writing to %s: %s", f.tmp, err)
+	}
+	f.written = time.Now()
+	f.pending++
+	if f.pending >= w.rowGroupSize {
+		f.pending = 0
+		if err := f.pw.Flush(true); err != nil {
+			return fmt.Errorf("error flushing row group of %s: %s", f.tmp, err)
+		}
+	}
+	return nil
+}
+
+func (w *ParquetStorage) open(partition, meta string) (*parquetFile, error) {
+	dir := filepath.Join(w.dir, partition)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	path := partFile(dir, now)
+	// readers skip hidden files, the file shows up once complete
+	tmp := filepath.Join(dir, "."+filepath.Base(path)+".tmp")
+	fw, err := local.NewLocalFileWriter(tmp)
+	if err != nil {
+		return nil, err
+	}
+	pw, err := writer.NewParquetWriter(fw, parquetSchemas[meta](), parquetWriterParallel)
+	if err != nil {
+		fw.Close()
+		os.Remove(tmp)
+		return nil, err
+	}
+	pw.CompressionType = w.codec
+	f := &parquetFile{fw: fw, pw: pw, tmp: tmp, path: path, opened: now, written: now}
+	w.files[partition] = f
+	log.Infof("opened %s", tmp)
+	return f, nil
+}
+
+// partFile is a part file name no other file of dir has
+func partFile(dir string, t time.Time) string {
+	for {
+		path := filepath.Join(dir, "part-"+t.UTC().Format(rotatedLayout)+".parquet")
+		if !exists(path) {
+			return path
+		}
+		t = t.Add(time.Millisecond)
+	}
+}
+
+// rotateExpired finishes the files too old or idle
+func (w *ParquetStorage) rotateExpired() {
+	now := time.Now()
+	for _, p := range w.partitions() {
+		f := w.files[p]
+		if now.Sub(f.opened) >= w.maxAge || now.Sub(f.written) >= w.idle {
+			w.finish(p)
+		}
+	}
+}
+
+// finish writes the footer of a file and moves it in place
+func (w *ParquetStorage) finish(partition string) {
+	f := w.files[partition]
+	delete(w.files, partition)
+	err := f.pw.WriteStop()
+	if cerr := f.fw.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		log.Errorf("error closing %s: %s", f.tmp, err)
+		return
+	}
+	if err := os.Rename(f.tmp, f.path); err != nil {
+		log.Errorf("error renaming %s: %s", f.tmp, err)
+		return
+	}
+	log.Infof("wrote %s", f.path)
+}
+
+func (w *ParquetStorage) partitions() []string {
+	res := make([]string, 0, len(w.files))
+	for p := range w.files {
+		res = append(res, p)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Close finishes every open file, they are all readable once it returns
+func (w *ParquetStorage) Close() {
+	w.closeChan <- true
+	<-w.done
+}
+
+func checkParquetCompression(v string) error {
+	if _, ok := parquetCodecs[v]; !ok {
+		return fmt.Errorf("unknown compression %s, use %s, %s, %s or %s", v, NoCompression, Snappy, Gzip, Zstd)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"cryptoCrawl/crawler"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParquetRow(t *testing.T) {
+	cancel := crawler.OrderMeasurement{Meta: "cancel", Platform: "poloniex", Amount: 2, Timestamp: 1517443200000}
+	meta, row, ok := parquetRow(Projection{Measurement: cancel, Fields: []string{"price"}})
+	if !ok || meta != "cancel" {
+		t.Fatalf("expected a cancel row, got %s %v", meta, ok)
+	}
+	if c, ok := row.(*parquetCancel); !ok || c.Amount != 2 || c.Time != 1517443200000 {
+		t.Errorf("unexpected row %+v", row)
+	}
+	if p := parquetPartition(meta, cancel); p != filepath.Join("meta=cancel", "platform=poloniex", "date=2018-02-01") {
+		t.Errorf("unexpected partition %s", p)
+	}
+	if _, _, ok := parquetRow("nope"); ok {
+		t.Error("expected no row for an unknown measurement")
+	}
+}
+
+func TestParquetClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	w, err := NewParquetStorage(map[string]string{"path": dir, "row_group_size": "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		w.Write(crawler.TradeMeasurement{Meta: "trade", Platform: "binance", Price: float64(i), Timestamp: 1517443200000})
+	}
+	w.(Closer).Close()
+	partition := filepath.Join(dir, "meta=trade", "platform=binance", "date=2018-02-01")
+	files, _ := ioutil.ReadDir(partition)
+	if len(files) != 1 || filepath.Ext(files[0].Name()) != ".parquet" {
+		t.Fatalf("expected a single complete part file, got %v", files)
+	}
+	fr, err := local.NewLocalFileReader(filepath.Join(partition, files[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fr.Close()
+	pr, err := reader.NewParquetReader(fr, new(parquetTrade), 1)
+	if err != nil {
+		t.Fatalf("the part file should be readable: %s", err)
+	}
+	defer pr.ReadStop()
+	if n := pr.GetNumRows(); n != 5 {
+		t.Errorf("expected 5 rows, got %d", n)
+	}
+	if n := len(pr.Footer.RowGroups); n != 3 {
+		t.Errorf("expected 3 row groups of at most 2 rows, got %d", n)
+	}
+}
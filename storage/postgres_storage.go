@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+	"sort"
+)
+
+var postgresTypes = map[int]string{
+	textColumn:  "TEXT",
+	floatColumn: "DOUBLE PRECISION",
+	timeColumn:  "TIMESTAMPTZ",
+}
+
+// PostgresStorage copies batches of measurements to a table per meta, the
+// tables are turned into hypertables when TimescaleDB is installed
+type PostgresStorage struct {
+	db          *sql.DB
+	batch       batchConfig
+	dataChannel chan interface{}
+	closeChan   chan bool
+	spool       *Spool
+}
+
+func NewPostgresStorage(params map[string]string) (DataWriter, error) {
+	dsn, ok := params["dsn"]
+	if !ok {
+		return nil, fmt.Errorf("parameter 'dsn' should be present")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	res, err := newPostgresStorage(db, params)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	log.Infof("successfully created new postgres writer")
+	return res, nil
+}
+
+func newPostgresStorage(db *sql.DB, params map[string]string) (*PostgresStorage, error) {
+	if err := db.Ping(); err != nil {
+		return nil, err
+	}
+	res := &PostgresStorage{
+		db:          db,
+		batch:       parseBatchConfig(params),
+		dataChannel: make(chan interface{}, 10000),
+		closeChan:   make(chan bool),
+	}
+	if err := res.setup(); err != nil {
+		return nil, err
+	}
+	go res.Ingest()
+	return res, nil
+}
+
+// setup creates the tables and their index, every statement can be run again
+func (p *PostgresStorage) setup() error {
+	var timescale bool
+	err := p.db.QueryRow("SELECT count(*) > 0 FROM pg_extension WHERE extname = 'timescaledb'").Scan(&timescale)
+	if err != nil {
+		return err
+	}
+	for _, t := range sortedTables() {
+		stmts := []string{t.create(postgresTypes), t.index()}
+		if timescale {
+			stmts = append(stmts, fmt.Sprintf("SELECT create_hypertable('%s', 'time', if_not_exists => TRUE, migrate_data => TRUE)", t.name))
+		}
+		for _, s := range stmts {
+			if _, err := p.db.Exec(s); err != nil {
+				return fmt.Errorf("error setting up table %s: %s", t.name, err)
+			}
+		}
+	}
+	if timescale {
+		log.Info("created timescale hypertables")
+	}
+	return nil
+}
+
+func sortedTables() []sqlTable {
+	res := make([]sqlTable, 0, len(sqlTables))
+	seen := map[string]bool{}
+	for _, t := range sqlTables {
+		if !seen[t.name] {
+			seen[t.name] = true
+			res = append(res, t)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].name < res[j].name })
+	return res
+}
+
+func (p *PostgresStorage) Write(d interface{}) {
+	p.dataChannel <- d
+}
+
+func (p *PostgresStorage) Ingest() {
+	b := newBatcher(p.batch, docSize, p.process)
+	b.serial = p.spooled
+	for {
+		select {
+		case d := <-p.dataChannel:
+			b.add(d)
+		case <-b.expired():
+			b.send()
+		case <-p.closeChan:
+			b.close()
+			if p.spool != nil {
+				p.spool.Close()
+			}
+			p.db.Close()
+			return
+		}
+	}
+}
+
+func (p *PostgresStorage) Close() {
+	p.closeChan <- true
+}
+
+func (p *PostgresStorage) setSpool(s *Spool) {
+	p.spool = s
+}
+
+func (p *PostgresStorage) spooled() bool {
+	return p.spool != nil
+}
+
+func (p *PostgresStorage) process(data []interface{}) {
+	if len(data) == 0 {
+		return
+	}
+	if p.spool != nil {
+		p.spool.Deliver(data)
+	} else if err := writeBatch("postgres", p, data); err != nil {
+		log.Error(err)
+	}
+}
+
+// WriteBatch copies every table of the batch in a single transaction
+func (p *PostgresStorage) WriteBatch(data []interface{}) error {
+	tables, rows, skipped := groupRows(data)
+	if skipped > 0 {
+		log.Errorf("postgres writer skipped %d measurements without a table", skipped)
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, t := range tables {
+		if err := copyRows(tx, t, rows[t.name]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("error copying %d rows to %s: %s", len(rows[t.name]), t.name, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing %d rows: %s", len(data)-skipped, err)
+	}
+	log.Infof("successfully copied %d rows to postgres", len(data)-skipped)
+	return nil
+}
+
+func copyRows(tx *sql.Tx, t sqlTable, rows [][]interface{}) error {
+	stmt, err := tx.Prepare(pq.CopyIn(t.name, t.columnNames()...))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, r := range rows {
+		if _, err := stmt.Exec(r...); err != nil {
+			return err
+		}
+	}
+	// an exec without args ends the copy
+	_, err = stmt.Exec()
+	return err
+}
@@ -0,0 +1,428 @@
+package storage
+
+import (
+	"bufio"
+	"cryptoCrawl/crawler"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	Block      = "block"
+	DropOldest = "drop-oldest"
+	DropNewest = "drop-newest"
+	Spill      = "spill"
+
+	defaultQueueSize = 10000
+	// items read back from a spill file at once
+	unspillBatch = 1000
+)
+
+var (
+	policies = []string{Block, DropOldest, DropNewest, Spill}
+
+	queueLock sync.Mutex
+	queues    = map[string]*Queue{}
+)
+
+// QueueConfig sizes the queue sitting in front of a writer and tells what to
+// do once it is full
+type QueueConfig struct {
+	Size int `json:"size"`
+	// block, drop-oldest, drop-newest or spill, block by default
+	Policy string `json:"policy"`
+	// directory the spill policy writes overflowing items to
+	SpillDir string `json:"spill_dir"`
+}
+
+type QueueStats struct {
+	Enqueued int64 `json:"enqueued"`
+	Dropped  int64 `json:"dropped"`
+	Written  int64 `json:"written"`
+	Spilled  int64 `json:"spilled"`
+	// items waiting in memory
+	Depth int `json:"depth"`
+}
+
+// Queue decouples a writer from the crawlers, each writer drains its own
+// bounded queue so a slow one does not stall the others
+type Queue struct {
+	name      string
+	writer    DataWriter
+	policy    string
+	items     chan interface{}
+	spill     *spillFile
+	spillChan chan bool
+	closeChan chan bool
+	done      chan struct{}
+	closed    int32
+	enqueued  int64
+	dropped   int64
+	written   int64
+	spilled   int64
+}
+
+func NewQueue(name string, w DataWriter, cfg QueueConfig) (*Queue, error) {
+	size := cfg.Size
+	if size == 0 {
+		size = defaultQueueSize
+	}
+	policy := cfg.Policy
+	if policy == "" {
+		policy = Block
+	}
+	q := &Queue{
+		name:      name,
+		writer:    w,
+		policy:    policy,
+		items:     make(chan interface{}, size),
+		spillChan: make(chan bool, 1),
+		closeChan: make(chan bool),
+		done:      make(chan struct{}),
+	}
+	if policy == Spill {
+		s, err := openSpill(filepath.Join(cfg.SpillDir, name+".spill"))
+		if err != nil {
+			return nil, err
+		}
+		q.spill = s
+		if !s.empty() {
+			log.Infof("replaying %d spilled bytes for writer %s", s.size-s.offset, name)
+			q.spillChan <- true
+		}
+	}
+	queueLock.Lock()
+	queues[name] = q
+	queueLock.Unlock()
+	go q.loop()
+	return q, nil
+}
+
+// QueuesStats returns the counters of every running queue by writer
+func QueuesStats() map[string]QueueStats {
+	queueLock.Lock()
+	defer queueLock.Unlock()
+	res := make(map[string]QueueStats, len(queues))
+	for k, q := range queues {
+		res[k] = q.Stats()
+	}
+	return res
+}
+
+func (q *Queue) Stats() QueueStats {
+	return QueueStats{
+		Enqueued: atomic.LoadInt64(&q.enqueued),
+		Dropped:  atomic.LoadInt64(&q.dropped),
+		Written:  atomic.LoadInt64(&q.written),
+		Spilled:  atomic.LoadInt64(&q.spilled),
+		Depth:    len(q.items),
+	}
+}
+
+func (q *Queue) Write(d interface{}) {
+	if atomic.LoadInt32(&q.closed) == 1 {
+		atomic.AddInt64(&q.dropped, 1)
+		return
+	}
+	// once something is spilled newer items follow it to keep the order
+	if q.spill != nil && !q.spill.empty() {
+		q.toSpill(d)
+		return
+	}
+	select {
+	case q.items <- d:
+		atomic.AddInt64(&q.enqueued, 1)
+		return
+	default:
+	}
+	switch q.policy {
+	case Block:
+		select {
+		case q.items <- d:
+			atomic.AddInt64(&q.enqueued, 1)
+		case <-q.done:
+			atomic.AddInt64(&q.dropped, 1)
+		}
+	case DropNewest:
+		atomic.AddInt64(&q.dropped, 1)
+	case DropOldest:
+		for {
+			select {
+			case q.items <- d:
+				atomic.AddInt64(&q.enqueued, 1)
+				return
+			default:
+			}
+			select {
+			case <-q.items:
+				atomic.AddInt64(&q.dropped, 1)
+			default:
+			}
+		}
+	case Spill:
+		q.toSpill(d)
+	}
+}
+
+func (q *Queue) toSpill(d interface{}) {
+	if err := q.spill.append(d); err != nil {
+		log.Errorf("error spilling to %s, dropping: %s", q.spill.path, err)
+		atomic.AddInt64(&q.dropped, 1)
+		return
+	}
+	atomic.AddInt64(&q.spilled, 1)
+	select {
+	case q.spillChan <- true:
+	default:
+	}
+}
+
+func (q *Queue) loop() {
+	defer close(q.done)
+	for {
+		select {
+		case d := <-q.items:
+			q.write(d)
+		case <-q.spillChan:
+			q.drain()
+			q.unspill()
+		case <-q.closeChan:
+			q.drain()
+			if q.spill != nil {
+				q.spill.close()
+			}
+			if c, ok := q.writer.(Closer); ok {
+				c.Close()
+			}
+			return
+		}
+	}
+}
+
+func (q *Queue) write(d interface{}) {
+	q.writer.Write(d)
+	atomic.AddInt64(&q.written, 1)
+}
+
+// drain writes what is already queued in memory, it is older than anything spilled
+func (q *Queue) drain() {
+	for {
+		select {
+		case d := <-q.items:
+			q.write(d)
+		default:
+			return
+		}
+	}
+}
+
+func (q *Queue) unspill() {
+	items, err := q.spill.read(unspillBatch)
+	if err != nil {
+		log.Errorf("error reading back %s: %s", q.spill.path, err)
+	}
+	for _, d := range items {
+		q.write(d)
+	}
+	if !q.spill.empty() {
+		select {
+		case q.spillChan <- true:
+		default:
+		}
+	}
+}
+
+// Close writes what is queued in memory and closes the writer, spilled items
+// stay on disk and are replayed by the next queue using the same file
+func (q *Queue) Close() {
+	if !atomic.CompareAndSwapInt32(&q.closed, 0, 1) {
+		return
+	}
+	queueLock.Lock()
+	if queues[q.name] == q {
+		delete(queues, q.name)
+	}
+	queueLock.Unlock()
+	q.closeChan <- true
+	<-q.done
+}
+
+func ValidateQueue(cfg QueueConfig) []error {
+	var errs []error
+	if cfg.Size < 0 {
+		errs = append(errs, fmt.Errorf("queue.size: can not be negative"))
+	}
+	if cfg.Policy != "" && !contains(policies, cfg.Policy) {
+		errs = append(errs, fmt.Errorf("queue.policy: unknown policy %s, use one of %v", cfg.Policy, policies))
+	}
+	if cfg.Policy == Spill {
+		if cfg.SpillDir == "" {
+			errs = append(errs, fmt.Errorf("queue.spill_dir: required by the spill policy"))
+		} else if fi, err := os.Stat(cfg.SpillDir); err != nil || !fi.IsDir() {
+			errs = append(errs, fmt.Errorf("queue.spill_dir: %s is not a directory", cfg.SpillDir))
+		}
+	}
+	return errs
+}
+
+// spilled is the disk representation of a measurement, the type lets it be
+// decoded back into what the writers expect
+type spilled struct {
+	Type   string          `json:"type"`
+	Fields []string        `json:"fields,omitempty"`
+	Data   json.RawMessage `json:"data"`
+}
+
+func encodeSpilled(d interface{}) (spilled, error) {
+	s := spilled{}
+	if p, ok := d.(Projection); ok {
+		s.Fields = p.Fields
+		d = p.Measurement
+	}
+	switch d.(type) {
+	case crawler.TradeMeasurement:
+		s.Type = "trade"
+	case crawler.OrderMeasurement:
+		s.Type = "order"
+	case crawler.CancelMeasurement:
+		s.Type = "cancel"
+	default:
+		return s, fmt.Errorf("%T can not be spilled", d)
+	}
+	bits, err := json.Marshal(d)
+	s.Data = bits
+	return s, err
+}
+
+func (s spilled) decode() (interface{}, error) {
+	return decodeTyped(s.Type, s.Fields, func(v interface{}) error { return json.Unmarshal(s.Data, v) })
+}
+
+// unknownTypeError is returned for records that are no measurement
+type unknownTypeError string
+
+func (e unknownTypeError) Error() string {
+	return fmt.Sprintf("unknown measurement type %q", string(e))
+}
+
+// decodeTyped decodes a measurement of the type given by encodeSpilled with
+// unmarshal, wrapped in a projection when fields are given
+func decodeTyped(typ string, fields []string, unmarshal func(v interface{}) error) (interface{}, error) {
+	var d interface{}
+	var err error
+	switch typ {
+	case "trade":
+		m := crawler.TradeMeasurement{}
+		err = unmarshal(&m)
+		d = m
+	case "order":
+		m := crawler.OrderMeasurement{}
+		err = unmarshal(&m)
+		d = m
+	case "cancel":
+		m := crawler.CancelMeasurement{}
+		err = unmarshal(&m)
+		d = m
+	default:
+		return nil, unknownTypeError(typ)
+	}
+	if len(fields) > 0 {
+		d = Projection{Measurement: d, Fields: fields}
+	}
+	return d, err
+}
+
+// spillFile is an append only json lines file read back from offset, it is
+// truncated once everything has been read
+type spillFile struct {
+	locker sync.Mutex
+	path   string
+	file   *os.File
+	size   int64
+	offset int64
+}
+
+func openSpill(path string) (*spillFile, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &spillFile{path: path, file: f, size: fi.Size()}, nil
+}
+
+func (s *spillFile) empty() bool {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	return s.offset == s.size
+}
+
+func (s *spillFile) append(d interface{}) error {
+	e, err := encodeSpilled(d)
+	if err != nil {
+		return err
+	}
+	bits, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	n, err := s.file.Write(append(bits, '\n'))
+	s.size += int64(n)
+	return err
+}
+
+func (s *spillFile) read(max int) ([]interface{}, error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	r := bufio.NewReader(io.NewSectionReader(s.file, s.offset, s.size-s.offset))
+	var res []interface{}
+	for len(res) < max {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				// cut short by a crash
+				log.Errorf("skipping truncated line at the end of %s", s.path)
+				s.offset += int64(len(line))
+			}
+			break
+		}
+		if err != nil {
+			return res, err
+		}
+		s.offset += int64(len(line))
+		e := spilled{}
+		if err := json.Unmarshal(line, &e); err != nil {
+			log.Errorf("skipping corrupted line in %s: %s", s.path, err)
+			continue
+		}
+		d, err := e.decode()
+		if err != nil {
+			log.Errorf("skipping line in %s: %s", s.path, err)
+			continue
+		}
+		res = append(res, d)
+	}
+	if s.offset == s.size {
+		s.offset, s.size = 0, 0
+		return res, s.file.Truncate(0)
+	}
+	return res, nil
+}
+
+func (s *spillFile) close() {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	s.file.Close()
+}
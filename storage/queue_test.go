@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"cryptoCrawl/crawler"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gatedWriter blocks every write until the gate is opened
+type gatedWriter struct {
+	locker sync.Mutex
+	gate   chan struct{}
+	items  []interface{}
+}
+
+func (w *gatedWriter) Write(d interface{}) {
+	<-w.gate
+	w.locker.Lock()
+	defer w.locker.Unlock()
+	w.items = append(w.items, d)
+}
+
+func (w *gatedWriter) prices() []float64 {
+	w.locker.Lock()
+	defer w.locker.Unlock()
+	var res []float64
+	for _, d := range w.items {
+		res = append(res, d.(crawler.TradeMeasurement).Price)
+	}
+	return res
+}
+
+func trades(q DataWriter, n int) {
+	for i := 1; i <= n; i++ {
+		q.Write(crawler.TradeMeasurement{Meta: "trade", Price: float64(i)})
+	}
+}
+
+func waitWritten(t *testing.T, q *Queue, n int64) {
+	deadline := time.Now().Add(5 * time.Second)
+	for q.Stats().Written < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d written items, got %+v", n, q.Stats())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestQueuePolicies(t *testing.T) {
+	for _, tc := range []struct {
+		policy   string
+		expected []float64
+	}{
+		// the first item is held by the writer, two fit in the queue
+		{DropNewest, []float64{1, 2, 3}},
+		{DropOldest, []float64{1, 4, 5}},
+	} {
+		w := &gatedWriter{gate: make(chan struct{})}
+		q, err := NewQueue("test-"+tc.policy, w, QueueConfig{Size: 2, Policy: tc.policy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		q.Write(crawler.TradeMeasurement{Price: 1})
+		// let the loop pick up the first item
+		for q.Stats().Depth != 0 {
+			time.Sleep(time.Millisecond)
+		}
+		for i := 2; i <= 5; i++ {
+			q.Write(crawler.TradeMeasurement{Price: float64(i)})
+		}
+		if s := q.Stats(); s.Dropped != 2 || s.Depth != 2 {
+			t.Errorf("%s: unexpected stats %+v", tc.policy, s)
+		}
+		if _, ok := QueuesStats()["test-"+tc.policy]; !ok {
+			t.Errorf("%s: queue stats not exported", tc.policy)
+		}
+		close(w.gate)
+		waitWritten(t, q, 3)
+		q.Close()
+		got := w.prices()
+		if len(got) != len(tc.expected) {
+			t.Fatalf("%s: expected %v, got %v", tc.policy, tc.expected, got)
+		}
+		for i := range got {
+			if got[i] != tc.expected[i] {
+				t.Errorf("%s: expected %v, got %v", tc.policy, tc.expected, got)
+				break
+			}
+		}
+	}
+}
+
+func TestQueueSpill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	w := &gatedWriter{gate: make(chan struct{})}
+	q, err := NewQueue("spilling", w, QueueConfig{Size: 2, Policy: Spill, SpillDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	trades(q, 10)
+	if s := q.Stats(); s.Dropped != 0 || s.Spilled == 0 {
+		t.Errorf("expected spilled items, got %+v", s)
+	}
+	close(w.gate)
+	waitWritten(t, q, 10)
+	q.Close()
+	for i, p := range w.prices() {
+		if p != float64(i+1) {
+			t.Fatalf("spilled items written out of order: %v", w.prices())
+		}
+	}
+	if fi, err := os.Stat(dir + "/spilling.spill"); err != nil || fi.Size() != 0 {
+		t.Errorf("expected an empty spill file once everything is written")
+	}
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"cryptoCrawl/analysis"
+	"cryptoCrawl/crawler"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const defaultPageSize = 10000
+
+// Query selects the trades of a pair on a platform within [From, To)
+type Query struct {
+	Platform string
+	Pair     string
+	From     time.Time
+	To       time.Time
+	// trades handed over at once, defaultPageSize when zero
+	PageSize int
+}
+
+func (q Query) pageSize() int {
+	if q.PageSize <= 0 {
+		return defaultPageSize
+	}
+	return q.PageSize
+}
+
+func (q Query) matches(t crawler.TradeMeasurement) bool {
+	ts := crawler.FromMillis(t.Timestamp)
+	return t.Meta == "trade" && t.Platform == q.Platform && t.Pair == q.Pair && !ts.Before(q.From) && ts.Before(q.To)
+}
+
+// Reader reads back what a writer stored
+type Reader interface {
+	// Trades hands the trades of the query to page a page at a time, in time
+	// order unless the reader says otherwise, an error returned by page stops
+	// the reading
+	Trades(q Query, page func([]crawler.TradeMeasurement) error) error
+}
+
+// ReaderFactory creates a reader from the params of the matching writer
+type ReaderFactory func(params map[string]string) (Reader, error)
+
+// ReadTrades loads every trade of the query, in time order
+func ReadTrades(r Reader, q Query) ([]crawler.TradeMeasurement, error) {
+	var res []crawler.TradeMeasurement
+	err := r.Trades(q, func(trades []crawler.TradeMeasurement) error {
+		res = append(res, trades...)
+		return nil
+	})
+	// the jline reader gives the trades in the order they were written
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Timestamp < res[j].Timestamp })
+	return res, err
+}
+
+// ReadSeries loads the trades of the query as a price and volume series
+func ReadSeries(r Reader, q Query) (analysis.Series, error) {
+	var res analysis.Series
+	err := r.Trades(q, func(trades []crawler.TradeMeasurement) error {
+		for _, t := range trades {
+			res = append(res, analysis.Sample{Value: t.Price, Volume: t.Amount, Time: crawler.FromMillis(t.Timestamp)})
+		}
+		return nil
+	})
+	// the jline reader gives the trades in the order they were written
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Time.Before(res[j].Time) })
+	return res, err
+}
+
+func checkQuery(q Query) error {
+	if q.Platform == "" || q.Pair == "" {
+		return fmt.Errorf("query should have a platform and a pair")
+	}
+	if !q.From.Before(q.To) {
+		return fmt.Errorf("query should end after it starts")
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"cryptoCrawl/crawler"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var readerQuery = Query{
+	Platform: "binance",
+	Pair:     "BTCUSD",
+	From:     time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC),
+	To:       time.Date(2018, 2, 2, 0, 0, 0, 0, time.UTC),
+	PageSize: 2,
+}
+
+func TestInfluxReader(t *testing.T) {
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ping" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		q := r.URL.Query().Get("q")
+		queries = append(queries, q)
+		values := `[1517443200000, 0.5, 10000, "buy", null], [1517443201000, 1, 10001, "sell", null]`
+		if strings.HasSuffix(q, "OFFSET 2") {
+			values = `[1517443202000, 2, 10002, "buy", "market"]`
+		}
+		fmt.Fprintf(w, `{"results": [{"statement_id": 0, "series": [{"name": "trade", "columns": ["time", "amount", "price", "type", "trade_type"], "values": [%s]}]}]}`, values)
+	}))
+	defer server.Close()
+
+	r, err := NewInfluxReader(map[string]string{"host": server.URL, "retention_policy": "raw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	series, err := ReadSeries(r, readerQuery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(series) != 3 || series[2].Value != 10002 || series[2].Volume != 2 || !series[2].Time.Equal(crawler.FromMillis(1517443202000)) {
+		t.Errorf("unexpected series %+v", series)
+	}
+	expected := `SELECT amount, price, "type", trade_type FROM "crypto"."raw"."trade" WHERE platform = 'binance' AND pair = 'BTCUSD' ` +
+		`AND time >= 1517443200000ms AND time < 1517529600000ms ORDER BY time ASC LIMIT 2 OFFSET 0`
+	if len(queries) != 2 || queries[0] != expected {
+		t.Errorf("expected 2 pages starting with %s, got %v", expected, queries)
+	}
+}
+
+func TestJsonLineReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	params := map[string]string{"path": filepath.Join(dir, "{platform}/{date}.jsonl"), "compress": Gzip, "encoding": MsgpackEncoding}
+	w, err := NewJsonLineStorage(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, ts := range []int64{1517443205000, 1517443201000, 1517529600000, 1517443203000} {
+		w.Write(crawler.TradeMeasurement{Meta: "trade", Platform: "binance", Pair: "BTCUSD", Price: float64(i), Timestamp: ts})
+	}
+	w.Write(crawler.TradeMeasurement{Meta: "trade", Platform: "binance", Pair: "ETHBTC", Timestamp: 1517443201000})
+	w.Write(crawler.OrderMeasurement{Meta: "order", Platform: "binance", Pair: "BTCUSD", Timestamp: 1517443201000})
+	w.(Closer).Close()
+
+	r, err := NewJsonLineReader(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pages [][]crawler.TradeMeasurement
+	err = r.Trades(readerQuery, func(trades []crawler.TradeMeasurement) error {
+		pages = append(pages, trades)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the trade of the next day is out of range
+	if len(pages) != 2 || len(pages[0]) != 2 || len(pages[1]) != 1 {
+		t.Fatalf("expected pages of 2 and 1 trades, got %v", pages)
+	}
+	if pages[0][0].Price != 0 || pages[0][1].Price != 1 || pages[1][0].Price != 3 {
+		t.Errorf("expected the trades in the order they were written, got %v", pages)
+	}
+	trades, err := ReadTrades(r, readerQuery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trades) != 3 || trades[0].Price != 1 || trades[1].Price != 3 || trades[2].Price != 0 {
+		t.Errorf("expected the trades in time order, got %v", trades)
+	}
+}
@@ -0,0 +1,405 @@
+package storage
+
+import (
+	"bufio"
+	"compress/gzip"
+	"cryptoCrawl/crawler"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	NoCompression = "none"
+	Gzip          = "gzip"
+	Zstd          = "zstd"
+
+	// fsync policies
+	SyncNever  = "never"
+	SyncFlush  = "flush"
+	SyncRotate = "rotate"
+
+	defaultRotateIdle = 10 * time.Minute
+	// appended to the name of a closed file, before the extension
+	rotatedLayout = "20060102-150405.000"
+)
+
+var (
+	compressions  = []string{NoCompression, Gzip, Zstd}
+	syncPolicies  = []string{SyncNever, SyncFlush, SyncRotate}
+	placeholders  = regexp.MustCompile(`\{(platform|pair|meta|date|month|hour)\}`)
+	rotatedSuffix = regexp.MustCompile(`\.(\d{8}-\d{6}\.\d{3})(\.[^.]*)?(\.gz|\.zst)?$`)
+)
+
+// rotateConfig tells where a file writer writes and when it moves on to a
+// new file, shared by the writers producing files
+type rotateConfig struct {
+	// template like {platform}/{date}.jsonl
+	path string
+	// rotate a file past that size or age, never when zero
+	maxBytes int64
+	maxAge   time.Duration
+	// files nothing got written to for that long are closed
+	idle     time.Duration
+	compress string
+	fsync    string
+	// closed files kept, all of them when zero
+	retain int
+}
+
+func parseRotateConfig(params map[string]string) (rotateConfig, error) {
+	cfg := rotateConfig{path: params["path"], idle: defaultRotateIdle, compress: NoCompression, fsync: SyncRotate}
+	if cfg.path == "" {
+		return cfg, fmt.Errorf("parameter 'path' should be present")
+	}
+	var err error
+	if v, ok := params["rotate_size"]; ok {
+		if cfg.maxBytes, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return cfg, err
+		}
+	}
+	if v, ok := params["rotate_every"]; ok {
+		if cfg.maxAge, err = time.ParseDuration(v); err != nil {
+			return cfg, err
+		}
+	}
+	if v, ok := params["rotate_idle"]; ok {
+		if cfg.idle, err = time.ParseDuration(v); err != nil {
+			return cfg, err
+		}
+	}
+	if v, ok := params["compress"]; ok {
+		if err := checkCompression(v); err != nil {
+			return cfg, err
+		}
+		cfg.compress = v
+	}
+	if v, ok := params["fsync"]; ok {
+		if err := checkSyncPolicy(v); err != nil {
+			return cfg, err
+		}
+		cfg.fsync = v
+	}
+	cfg.retain = parseInt(params, "retain", 0)
+	return cfg, nil
+}
+
+// render fills the path template with the attributes of a measurement
+func (cfg rotateConfig) render(d interface{}) string {
+	return renderTemplate(cfg.path, d)
+}
+
+// renderTemplate replaces the placeholders of a template with the attributes
+// of a measurement, unknown when missing
+func renderTemplate(template string, d interface{}) string {
+	m, ok := attributes(d)
+	t := time.Now()
+	if ok && m.time > 0 {
+		t = crawler.FromMillis(m.time)
+	}
+	t = t.UTC()
+	return placeholders.ReplaceAllStringFunc(template, func(p string) string {
+		var v string
+		switch p {
+		case "{platform}":
+			v = m.platform
+		case "{pair}":
+			v = m.pair
+		case "{meta}":
+			v = m.meta
+		case "{date}":
+			v = t.Format("2006-01-02")
+		case "{month}":
+			v = t.Format("2006-01")
+		case "{hour}":
+			v = t.Format("15")
+		}
+		if v == "" {
+			return "unknown"
+		}
+		return strings.Replace(v, string(filepath.Separator), "-", -1)
+	})
+}
+
+// rotates is false when files are only ever appended to
+func (cfg rotateConfig) rotates() bool {
+	return cfg.maxBytes > 0 || cfg.maxAge > 0 || cfg.compress != NoCompression || cfg.retain > 0
+}
+
+// glob matches every closed file of the template
+func (cfg rotateConfig) glob() string {
+	ext := filepath.Ext(cfg.path)
+	return placeholders.ReplaceAllString(strings.TrimSuffix(cfg.path, ext), "*") + ".*" + ext + "*"
+}
+
+type openFile struct {
+	file    *os.File
+	buf     *bufio.Writer
+	size    int64
+	opened  time.Time
+	written time.Time
+}
+
+// rotatingFiles keeps a file open per rendered path, closed files are
+// renamed with the time they got closed at then compressed
+type rotatingFiles struct {
+	cfg rotateConfig
+	// written at the top of every new file, given the measurement opening it
+	header func(d interface{}) []byte
+	files  map[string]*openFile
+	// pending compressions
+	wg sync.WaitGroup
+}
+
+func newRotatingFiles(cfg rotateConfig, header func(interface{}) []byte) *rotatingFiles {
+	return &rotatingFiles{cfg: cfg, header: header, files: map[string]*openFile{}}
+}
+
+// write appends bits to the file of d, rotating it first if it is full
+func (r *rotatingFiles) write(d interface{}, bits []byte) error {
+	path := r.cfg.render(d)
+	f, ok := r.files[path]
+	if ok && r.cfg.maxBytes > 0 && f.size > 0 && f.size+int64(len(bits)) > r.cfg.maxBytes {
+		r.rotate(path)
+		ok = false
+	}
+	if !ok {
+		var err error
+		if f, err = r.open(path, d); err != nil {
+			return err
+		}
+	}
+	n, err := f.buf.Write(bits)
+	f.size += int64(n)
+	f.written = time.Now()
+	return err
+}
+
+func (r *rotatingFiles) open(path string, d interface{}) (*openFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	now := time.Now()
+	f := &openFile{file: file, buf: bufio.NewWriter(file), size: fi.Size(), opened: now, written: now}
+	if f.size == 0 && r.header != nil {
+		n, _ := f.buf.Write(r.header(d))
+		f.size += int64(n)
+	}
+	r.files[path] = f
+	log.Infof("opened %s", path)
+	return f, nil
+}
+
+// flush writes the buffers out and rotates the files too old or idle
+func (r *rotatingFiles) flush() {
+	now := time.Now()
+	for _, path := range r.paths() {
+		f := r.files[path]
+		if err := f.buf.Flush(); err != nil {
+			log.Errorf("error writing to %s: %s", path, err)
+		}
+		if r.cfg.fsync == SyncFlush {
+			if err := f.file.Sync(); err != nil {
+				log.Errorf("error syncing %s: %s", path, err)
+			}
+		}
+		if (r.cfg.maxAge > 0 && now.Sub(f.opened) >= r.cfg.maxAge) || now.Sub(f.written) >= r.cfg.idle {
+			r.rotate(path)
+		}
+	}
+}
+
+func (r *rotatingFiles) paths() []string {
+	res := make([]string, 0, len(r.files))
+	for p := range r.files {
+		res = append(res, p)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// rotate closes the file and moves it aside, unless rotation is disabled
+func (r *rotatingFiles) rotate(path string) {
+	f := r.files[path]
+	delete(r.files, path)
+	if err := f.buf.Flush(); err != nil {
+		log.Errorf("error writing to %s: %s", path, err)
+	}
+	if r.cfg.fsync != SyncNever {
+		if err := f.file.Sync(); err != nil {
+			log.Errorf("error syncing %s: %s", path, err)
+		}
+	}
+	f.file.Close()
+	if !r.cfg.rotates() {
+		return
+	}
+	closed := rotatedName(path, time.Now())
+	if err := os.Rename(path, closed); err != nil {
+		log.Errorf("error rotating %s: %s", path, err)
+		return
+	}
+	log.Infof("rotated %s to %s", path, closed)
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		if err := compressFile(closed, r.cfg.compress); err != nil {
+			log.Errorf("error compressing %s: %s", closed, err)
+		}
+		r.prune()
+	}()
+}
+
+// rotatedName is a name no other closed file of path has, made of the
+// rotation time
+func rotatedName(path string, t time.Time) string {
+	ext := filepath.Ext(path)
+	for {
+		closed := strings.TrimSuffix(path, ext) + "." + t.UTC().Format(rotatedLayout) + ext
+		if !exists(closed) && !exists(closed+".gz") && !exists(closed+".zst") {
+			return closed
+		}
+		t = t.Add(time.Millisecond)
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// prune removes the oldest closed files past the number to retain
+func (r *rotatingFiles) prune() {
+	if r.cfg.retain == 0 {
+		return
+	}
+	matches, err := filepath.Glob(r.cfg.glob())
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	type closedFile struct {
+		path    string
+		rotated time.Time
+	}
+	var closed []closedFile
+	seen := map[string]bool{}
+	for _, m := range matches {
+		parts := rotatedSuffix.FindStringSubmatch(m)
+		if parts == nil {
+			continue
+		}
+		t, err := time.Parse(rotatedLayout, parts[1])
+		if err != nil {
+			continue
+		}
+		// a file being compressed exists twice
+		m = strings.TrimSuffix(m, parts[3])
+		if !seen[m] {
+			seen[m] = true
+			closed = append(closed, closedFile{m, t})
+		}
+	}
+	sort.Slice(closed, func(i, j int) bool { return closed[i].rotated.Before(closed[j].rotated) })
+	for len(closed) > r.cfg.retain {
+		for _, ext := range []string{"", ".gz", ".zst"} {
+			if err := os.Remove(closed[0].path + ext); err != nil && !os.IsNotExist(err) {
+				log.Errorf("error removing %s: %s", closed[0].path+ext, err)
+			}
+		}
+		closed = closed[1:]
+	}
+}
+
+// close rotates every open file and waits for them to be compressed
+func (r *rotatingFiles) close() {
+	for _, path := range r.paths() {
+		r.rotate(path)
+	}
+	r.wg.Wait()
+}
+
+// compressFile replaces path with its compressed version
+func compressFile(path, compression string) error {
+	var ext string
+	switch compression {
+	case Gzip:
+		ext = ".gz"
+	case Zstd:
+		ext = ".zst"
+	default:
+		return nil
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(path + ext)
+	if err != nil {
+		return err
+	}
+	var w io.WriteCloser
+	if compression == Gzip {
+		w = gzip.NewWriter(dst)
+	} else if w, err = zstd.NewWriter(dst); err != nil {
+		dst.Close()
+		return err
+	}
+	_, err = io.Copy(w, src)
+	if err == nil {
+		err = w.Close()
+	}
+	if err == nil {
+		err = dst.Sync()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path + ext)
+		return err
+	}
+	return os.Remove(path)
+}
+
+func checkCompression(v string) error {
+	if !contains(compressions, v) {
+		return fmt.Errorf("unknown compression %s, use one of %v", v, compressions)
+	}
+	return nil
+}
+
+func checkSyncPolicy(v string) error {
+	if !contains(syncPolicies, v) {
+		return fmt.Errorf("unknown fsync policy %s, use one of %v", v, syncPolicies)
+	}
+	return nil
+}
+
+// checkPathTemplate makes sure the directory the template starts from
+// exists, directories made of placeholders are created on the fly
+func checkPathTemplate(v string) error {
+	if i := strings.Index(v, "{"); i >= 0 {
+		v = v[:i] + "file"
+	}
+	return checkDir(v)
+}
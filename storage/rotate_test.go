@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"compress/gzip"
+	"cryptoCrawl/crawler"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg, err := parseRotateConfig(map[string]string{
+		"path":        filepath.Join(dir, "{platform}/{date}.jsonl"),
+		"rotate_size": "10",
+		"compress":    Gzip,
+		"retain":      "2",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newRotatingFiles(cfg, func(interface{}) []byte { return []byte("header\n") })
+	tr := crawler.TradeMeasurement{Platform: "binance", Timestamp: 1517443200000}
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n"} {
+		if err := r.write(tr, []byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "binance", "*"))
+	if len(files) != 2 {
+		t.Fatalf("expected the 2 newest files to be kept, got %v", files)
+	}
+	var contents []string
+	for _, f := range files {
+		if !strings.HasPrefix(filepath.Base(f), "2018-02-01.") || !strings.HasSuffix(f, ".jsonl.gz") {
+			t.Errorf("unexpected file name %s", f)
+		}
+		in, err := os.Open(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gz, err := gzip.NewReader(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bits, _ := ioutil.ReadAll(gz)
+		in.Close()
+		contents = append(contents, string(bits))
+	}
+	// header and one line fill a file
+	if contents[0] != "header\nthree\n" || contents[1] != "header\nfour\n" {
+		t.Errorf("unexpected contents %q", contents)
+	}
+}
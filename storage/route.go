@@ -0,0 +1,215 @@
+package storage
+
+import (
+	"cryptoCrawl/crawler"
+	"encoding/json"
+	"fmt"
+	"path"
+)
+
+var (
+	metas = []string{"trade", "order", "cancel", "quote"}
+	// json names of the measurement fields a projection can keep
+	projectable = []string{"meta", "pair", "platform", "type", "trade_type", "price", "amount", "time", "trade_id"}
+	// fields kept by every projection, the readers and the time based
+	// indices need them
+	alwaysKept = []string{"time", "meta", "platform", "pair"}
+	// fields of the influx points, a point needs at least one
+	influxFields = []string{"price", "amount"}
+	// writers turning measurements into influx points
+	influxWriters = []string{"influxdb", "influxdb2", "line"}
+)
+
+// Filter selects the measurements a writer gets, empty fields match anything
+type Filter struct {
+	// trade, order, cancel or quote, quote being another name for order like
+	// the quotes channels of the websocket writer
+	Meta     []string `json:"meta"`
+	Platform []string `json:"platform"`
+	// globs on the normalized pair e.g. BTC*
+	Pair []string `json:"pair"`
+	// price * amount, measurements without an amount like cancels always pass
+	MinNotional float64 `json:"min_notional"`
+}
+
+func (f Filter) empty() bool {
+	return len(f.Meta) == 0 && len(f.Platform) == 0 && len(f.Pair) == 0 && f.MinNotional == 0
+}
+
+func (f Filter) Match(d interface{}) bool {
+	if f.empty() {
+		return true
+	}
+	m, ok := attributes(d)
+	if !ok {
+		return false
+	}
+	if len(f.Meta) > 0 && !contains(f.Meta, m.meta) && !(m.meta == "order" && contains(f.Meta, "quote")) {
+		return false
+	}
+	if len(f.Platform) > 0 && !contains(f.Platform, m.platform) {
+		return false
+	}
+	if len(f.Pair) > 0 {
+		matched := false
+		for _, g := range f.Pair {
+			if ok, _ := path.Match(g, m.pair); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return !m.hasAmount || m.price*m.amount >= f.MinNotional
+}
+
+type measurement struct {
+	meta, platform, pair string
+	price, amount        float64
+	hasAmount            bool
+	// epoch millis
+	time int64
+}
+
+func attributes(d interface{}) (measurement, bool) {
+	switch v := d.(type) {
+	case crawler.TradeMeasurement:
+		return measurement{v.Meta, v.Platform, v.Pair, v.Price, v.Amount, true, v.Timestamp}, true
+	case crawler.OrderMeasurement:
+		return measurement{v.Meta, v.Platform, v.Pair, v.Price, v.Amount, true, v.Timestamp}, true
+	case crawler.CancelMeasurement:
+		return measurement{meta: v.Meta, platform: v.Platform, pair: v.Pair, price: v.Price, time: v.TimeStamp}, true
+	case Projection:
+		return attributes(v.Measurement)
+	}
+	return measurement{}, false
+}
+
+// Projection is a measurement reduced to some of its fields, its time, meta,
+// platform and pair are always kept
+type Projection struct {
+	Measurement interface{}
+	Fields      []string
+}
+
+func (p Projection) MarshalJSON() ([]byte, error) {
+	bits, err := json.Marshal(p.Measurement)
+	if err != nil {
+		return nil, err
+	}
+	all := map[string]json.RawMessage{}
+	if err := json.Unmarshal(bits, &all); err != nil {
+		return nil, err
+	}
+	kept := map[string]json.RawMessage{}
+	for _, f := range p.fields() {
+		if v, ok := all[f]; ok {
+			kept[f] = v
+		}
+	}
+	return json.Marshal(kept)
+}
+
+func (p Projection) AsInfluxMeasurement() crawler.InfluxMeasurement {
+	ii, ok := p.Measurement.(crawler.InfluxIngestable)
+	if !ok {
+		return crawler.InfluxMeasurement{}
+	}
+	m := ii.AsInfluxMeasurement()
+	tags := map[string]string{}
+	fields := map[string]interface{}{}
+	for _, f := range p.fields() {
+		if v, ok := m.Tags[f]; ok {
+			tags[f] = v
+		}
+		if v, ok := m.Fields[f]; ok {
+			fields[f] = v
+		}
+	}
+	m.Tags, m.Fields = tags, fields
+	return m
+}
+
+func (p Projection) fields() []string {
+	return append(append([]string{}, alwaysKept...), p.Fields...)
+}
+
+// Route sits between the crawlers and a writer, dropping what the writer
+// is not interested in and projecting the rest
+type Route struct {
+	writer DataWriter
+	filter Filter
+	fields []string
+}
+
+// NewRoute returns the writer itself when its config has no routing rules
+func NewRoute(w DataWriter, cfg WriterConfig) DataWriter {
+	if cfg.Filter.empty() && len(cfg.Fields) == 0 {
+		return w
+	}
+	return &Route{writer: w, filter: cfg.Filter, fields: cfg.Fields}
+}
+
+func (r *Route) Write(d interface{}) {
+	if !r.filter.Match(d) {
+		return
+	}
+	if len(r.fields) > 0 {
+		if _, ok := attributes(d); ok {
+			d = Projection{Measurement: d, Fields: r.fields}
+		}
+	}
+	r.writer.Write(d)
+}
+
+func (r *Route) Close() {
+	if c, ok := r.writer.(Closer); ok {
+		c.Close()
+	}
+}
+
+// ValidateRoute checks the filter and projection of a writer, errors are
+// prefixed with the path of the offending field
+func ValidateRoute(cfg WriterConfig) []error {
+	var errs []error
+	for i, m := range cfg.Filter.Meta {
+		if !contains(metas, m) {
+			errs = append(errs, fmt.Errorf("filter.meta[%d]: unknown meta %s, use one of %v", i, m, metas))
+		}
+	}
+	for i, p := range cfg.Filter.Platform {
+		if crawler.SupportedPairs(p) == nil {
+			errs = append(errs, fmt.Errorf("filter.platform[%d]: unknown platform %s", i, p))
+		}
+	}
+	for i, g := range cfg.Filter.Pair {
+		if _, err := path.Match(g, ""); err != nil {
+			errs = append(errs, fmt.Errorf("filter.pair[%d]: invalid glob %q", i, g))
+		}
+	}
+	if cfg.Filter.MinNotional < 0 {
+		errs = append(errs, fmt.Errorf("filter.min_notional: can not be negative"))
+	}
+	influx := false
+	for i, f := range cfg.Fields {
+		if !contains(projectable, f) {
+			errs = append(errs, fmt.Errorf("fields[%d]: unknown field %s, use one of %v", i, f, projectable))
+		}
+		influx = influx || contains(influxFields, f)
+	}
+	if len(cfg.Fields) > 0 && !influx && contains(influxWriters, cfg.Name) {
+		errs = append(errs, fmt.Errorf("fields: keep at least one of %v, influx points need a field", influxFields))
+	}
+	return errs
+}
+
+func contains(l []string, s string) bool {
+	for _, e := range l {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
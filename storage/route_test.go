@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"cryptoCrawl/crawler"
+	"encoding/json"
+	"testing"
+)
+
+type sliceWriter []interface{}
+
+func (w *sliceWriter) Write(d interface{}) {
+	*w = append(*w, d)
+}
+
+func TestRoute(t *testing.T) {
+	w := &sliceWriter{}
+	r := NewRoute(w, WriterConfig{
+		Filter: Filter{Meta: []string{"trade"}, Platform: []string{crawler.Binance}, Pair: []string{"BTC*"}, MinNotional: 100},
+		Fields: []string{"pair", "price", "time"},
+	})
+	r.Write(crawler.TradeMeasurement{Meta: "trade", Platform: crawler.Binance, Pair: crawler.BTCUSD, Price: 15000, Amount: 0.1, Timestamp: 1})
+	r.Write(crawler.TradeMeasurement{Meta: "trade", Platform: crawler.Binance, Pair: crawler.BTCUSD, Price: 15000, Amount: 0.001})
+	r.Write(crawler.TradeMeasurement{Meta: "trade", Platform: crawler.Binance, Pair: crawler.ETHUSD, Price: 1000, Amount: 1})
+	r.Write(crawler.TradeMeasurement{Meta: "trade", Platform: crawler.Kraken, Pair: crawler.BTCUSD, Price: 15000, Amount: 1})
+	r.Write(crawler.OrderMeasurement{Meta: "order", Platform: crawler.Binance, Pair: crawler.BTCUSD, Price: 15000, Amount: 1})
+	if len(*w) != 1 {
+		t.Fatalf("expected a single measurement to pass, got %+v", *w)
+	}
+	bits, err := json.Marshal((*w)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bits) != `{"meta":"trade","pair":"BTCUSD","platform":"binance","price":15000,"time":1}` {
+		t.Errorf("unexpected projection %s", bits)
+	}
+	m := (*w)[0].(crawler.InfluxIngestable).AsInfluxMeasurement()
+	if m.Measurement != "trade" || len(m.Tags) != 2 || len(m.Fields) != 1 || m.Tags["pair"] != crawler.BTCUSD {
+		t.Errorf("unexpected influx projection %+v", m)
+	}
+	if errs := ValidateRoute(WriterConfig{Name: "influxdb", Fields: []string{"time", "trade_id"}}); len(errs) != 1 {
+		t.Errorf("an influx projection without price or amount should be refused, got %v", errs)
+	}
+	if errs := ValidateRoute(WriterConfig{Name: "jline", Fields: []string{"time", "trade_id"}}); len(errs) != 0 {
+		t.Errorf("only influx points need price or amount, got %v", errs)
+	}
+	quotes := &sliceWriter{}
+	r = NewRoute(quotes, WriterConfig{Filter: Filter{Meta: []string{"quote"}}})
+	r.Write(crawler.OrderMeasurement{Meta: "order", Platform: crawler.Kraken, Pair: crawler.BTCUSD, Price: 15000, Amount: 1})
+	r.Write(crawler.TradeMeasurement{Meta: "trade", Platform: crawler.Kraken, Pair: crawler.BTCUSD, Price: 15000, Amount: 1})
+	if len(*quotes) != 1 {
+		t.Errorf("quote should match the orders only, got %+v", *quotes)
+	}
+	if NewRoute(w, WriterConfig{}) != DataWriter(w) {
+		t.Error("a writer without routing rules should not be wrapped")
+	}
+}
+
+func TestValidateRoute(t *testing.T) {
+	errs := ValidateRoute(WriterConfig{
+		Filter: Filter{Meta: []string{"trade", "book"}, Platform: []string{"mtgox"}, Pair: []string{"[BTC"}},
+		Fields: []string{"price", "volume"},
+	})
+	expected := []string{
+		"filter.meta[1]: unknown meta book",
+		"filter.platform[0]: unknown platform mtgox",
+		`filter.pair[0]: invalid glob "[BTC"`,
+		"fields[1]: unknown field volume",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, got %v", len(expected), errs)
+	}
+	for i, e := range expected {
+		if got := errs[i].Error(); len(got) < len(e) || got[:len(e)] != e {
+			t.Errorf("expected %q, got %q", e, got)
+		}
+	}
+}
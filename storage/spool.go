@@ -0,0 +1,538 @@
+package storage
+
+import (
+	"bufio"
+	"cryptoCrawl/crawler"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultSpoolBytes   = 1 << 30
+	defaultSegmentBytes = 16 << 20
+	defaultMaxBackoff   = time.Minute
+	minBackoff          = time.Second
+	segmentExt          = ".seg"
+	// holds the segment and offset of the oldest undelivered batch
+	ackFile = "ack"
+)
+
+var (
+	spoolLock sync.Mutex
+	spools    = map[string]*Spool{}
+)
+
+// SpoolConfig keeps the batches a writer failed to deliver on disk until the
+// backend is back, spooling is disabled without a dir
+type SpoolConfig struct {
+	Dir string `json:"dir"`
+	// disk usage cap, the oldest batches are dropped past it, 1GiB by default
+	MaxBytes int64 `json:"max_bytes"`
+	// size of a segment file, 16MiB by default
+	SegmentBytes int64 `json:"segment_bytes"`
+	// retries back off exponentially up to max_backoff, 1m by default
+	MaxBackoff crawler.Duration `json:"max_backoff"`
+}
+
+type SpoolStats struct {
+	Spooled   int64 `json:"spooled"`
+	Delivered int64 `json:"delivered"`
+	Dropped   int64 `json:"dropped"`
+	// bytes waiting on disk
+	Bytes int64 `json:"bytes"`
+}
+
+type segment struct {
+	id   int64
+	size int64
+}
+
+// Spool is a segmented log of failed batches, they are retried in order in
+// the background and replayed by the next spool opened on the same dir
+type Spool struct {
+	locker       sync.Mutex
+	name         string
+	dir          string
+	writer       BatchWriter
+	maxBytes     int64
+	segmentBytes int64
+	maxBackoff   time.Duration
+	segments     []segment
+	tail         *os.File
+	size         int64
+	// read offset in the first segment
+	offset    int64
+	wake      chan bool
+	closeChan chan bool
+	done      chan struct{}
+	closed    int32
+	spooled   int64
+	delivered int64
+	dropped   int64
+}
+
+// spoolable writers hand their failed batches to a spool
+type spoolable interface {
+	BatchWriter
+	setSpool(*Spool)
+}
+
+// AttachSpool puts a spool named name behind w, which has to deliver in
+// batches. Writers batching on their own hand their batches to the spool,
+// any other BatchWriter gets wrapped in a writer batching for it; the writer
+// to use in place of w is returned
+func AttachSpool(name string, w DataWriter, cfg SpoolConfig) (DataWriter, error) {
+	bw, ok := w.(BatchWriter)
+	if !ok {
+		return nil, fmt.Errorf("writer %s does not deliver in batches, it can not be spooled", name)
+	}
+	s, err := NewSpool(name, bw, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if sw, ok := w.(spoolable); ok {
+		sw.setSpool(s)
+		return w, nil
+	}
+	res := &spoolWriter{
+		writer:      w,
+		spool:       s,
+		batch:       batchConfig{maxItems: defaultBatchSize, maxBytes: defaultBatchBytes, maxAge: time.Second, maxInFlight: 1},
+		dataChannel: make(chan interface{}, 10000),
+		closeChan:   make(chan bool),
+	}
+	go res.Ingest()
+	return res, nil
+}
+
+// spoolWriter batches the measurements of a writer that does not batch on
+// its own and delivers them through a spool, one batch at a time
+type spoolWriter struct {
+	writer      DataWriter
+	spool       *Spool
+	batch       batchConfig
+	dataChannel chan interface{}
+	closeChan   chan bool
+}
+
+func (w *spoolWriter) Write(d interface{}) {
+	w.dataChannel <- d
+}
+
+func (w *spoolWriter) Ingest() {
+	b := newBatcher(w.batch, docSize, w.spool.Deliver)
+	for {
+		select {
+		case d := <-w.dataChannel:
+			b.add(d)
+		case <-b.expired():
+			b.send()
+		case <-w.closeChan:
+			b.close()
+			w.spool.Close()
+			if c, ok := w.writer.(Closer); ok {
+				c.Close()
+			}
+			return
+		}
+	}
+}
+
+func (w *spoolWriter) Close() {
+	w.closeChan <- true
+}
+
+func NewSpool(name string, w BatchWriter, cfg SpoolConfig) (*Spool, error) {
+	s := &Spool{
+		name:         name,
+		dir:          filepath.Join(cfg.Dir, name),
+		writer:       w,
+		maxBytes:     cfg.MaxBytes,
+		segmentBytes: cfg.SegmentBytes,
+		maxBackoff:   cfg.MaxBackoff.Duration,
+		wake:         make(chan bool, 1),
+		closeChan:    make(chan bool),
+		done:         make(chan struct{}),
+	}
+	if s.maxBytes == 0 {
+		s.maxBytes = defaultSpoolBytes
+	}
+	if s.segmentBytes == 0 {
+		s.segmentBytes = defaultSegmentBytes
+	}
+	if s.maxBackoff == 0 {
+		s.maxBackoff = defaultMaxBackoff
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return nil, err
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	if !s.empty() {
+		log.Infof("replaying %d spooled bytes for writer %s", s.pending(), name)
+		s.signal()
+	}
+	spoolLock.Lock()
+	spools[name] = s
+	spoolLock.Unlock()
+	go s.loop()
+	return s, nil
+}
+
+// open loads the segments left by a previous run and starts a new one to append to
+func (s *Spool) open() error {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range files {
+		var id int64
+		if !strings.HasSuffix(fi.Name(), segmentExt) {
+			continue
+		}
+		if _, err := fmt.Sscanf(fi.Name(), "%d"+segmentExt, &id); err != nil {
+			continue
+		}
+		s.segments = append(s.segments, segment{id: id, size: fi.Size()})
+		s.size += fi.Size()
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].id < s.segments[j].id })
+	var ackID, ackOffset int64
+	if bits, err := ioutil.ReadFile(filepath.Join(s.dir, ackFile)); err == nil {
+		fmt.Sscanf(string(bits), "%d %d", &ackID, &ackOffset)
+	}
+	// segments older than the acked one were delivered but not removed yet
+	for len(s.segments) > 0 && s.segments[0].id < ackID {
+		s.removeHead()
+	}
+	if len(s.segments) > 0 && s.segments[0].id == ackID && ackOffset <= s.segments[0].size {
+		s.offset = ackOffset
+	}
+	var next int64 = 1
+	if len(s.segments) > 0 {
+		next = s.segments[len(s.segments)-1].id + 1
+	}
+	return s.rotate(next)
+}
+
+func (s *Spool) segmentPath(id int64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%016d%s", id, segmentExt))
+}
+
+func (s *Spool) rotate(id int64) error {
+	f, err := os.OpenFile(s.segmentPath(id), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if s.tail != nil {
+		s.tail.Close()
+	}
+	s.tail = f
+	s.segments = append(s.segments, segment{id: id})
+	return nil
+}
+
+func (s *Spool) removeHead() {
+	head := s.segments[0]
+	if err := os.Remove(s.segmentPath(head.id)); err != nil {
+		log.Errorf("error removing spool segment: %s", err)
+	}
+	s.segments = s.segments[1:]
+	s.size -= head.size
+	s.offset = 0
+}
+
+func (s *Spool) empty() bool {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	return s.pending() == 0
+}
+
+func (s *Spool) pending() int64 {
+	return s.size - s.offset
+}
+
+func (s *Spool) signal() {
+	select {
+	case s.wake <- true:
+	default:
+	}
+}
+
+// Deliver writes the batch right away unless older batches are waiting,
+// failed and delayed batches are appended to the spool
+func (s *Spool) Deliver(batch []interface{}) {
+	if atomic.LoadInt32(&s.closed) == 0 && s.empty() {
+		err := writeBatch(s.name, s.writer, batch)
+		if err == nil {
+			atomic.AddInt64(&s.delivered, int64(len(batch)))
+			return
+		}
+		if pe, ok := err.(*PartialError); ok {
+			atomic.AddInt64(&s.delivered, int64(len(batch)-len(pe.Failed)))
+			batch = pe.Failed
+		}
+		log.Warnf("spooling %d items for writer %s: %s", len(batch), s.name, err)
+	}
+	if err := s.append(batch); err != nil {
+		log.Errorf("error spooling %d items for writer %s, dropping: %s", len(batch), s.name, err)
+		atomic.AddInt64(&s.dropped, int64(len(batch)))
+		return
+	}
+	atomic.AddInt64(&s.spooled, int64(len(batch)))
+	s.signal()
+}
+
+func (s *Spool) append(batch []interface{}) error {
+	var entries []spilled
+	for _, d := range batch {
+		e, err := encodeSpilled(d)
+		if err != nil {
+			log.Errorf("skipping item of writer %s: %s", s.name, err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	bits, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	bits = append(bits, '\n')
+	n := int64(len(bits))
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	if n > s.maxBytes {
+		return fmt.Errorf("batch of %d bytes is larger than the spool", n)
+	}
+	tail := &s.segments[len(s.segments)-1]
+	if tail.size > 0 && tail.size+n > s.segmentBytes {
+		if err := s.rotate(tail.id + 1); err != nil {
+			return err
+		}
+	}
+	for s.size+n > s.maxBytes && len(s.segments) > 1 {
+		log.Errorf("spool of writer %s is full, dropping %d bytes of the oldest batches", s.name, s.segments[0].size-s.offset)
+		s.removeHead()
+		s.writeAck()
+	}
+	if s.size+n > s.maxBytes {
+		return fmt.Errorf("spool is full")
+	}
+	written, err := s.tail.Write(bits)
+	s.segments[len(s.segments)-1].size += int64(written)
+	s.size += int64(written)
+	return err
+}
+
+// head reads the oldest batch, it returns the segment and offset following it
+func (s *Spool) head() ([]interface{}, int64, int64, error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	if s.pending() == 0 {
+		return nil, 0, 0, nil
+	}
+	s.compact()
+	head := s.segments[0]
+	f, err := os.Open(s.segmentPath(head.id))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer f.Close()
+	line, err := bufio.NewReader(io.NewSectionReader(f, s.offset, head.size-s.offset)).ReadBytes('\n')
+	next := s.offset + int64(len(line))
+	if err == io.EOF {
+		// cut short by a crash
+		log.Errorf("skipping truncated batch at the end of %s", f.Name())
+		return []interface{}{}, head.id, next, nil
+	}
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	var entries []spilled
+	if err := json.Unmarshal(line, &entries); err != nil {
+		log.Errorf("skipping corrupted batch in %s: %s", f.Name(), err)
+		return []interface{}{}, head.id, next, nil
+	}
+	batch := make([]interface{}, 0, len(entries))
+	for _, e := range entries {
+		d, err := e.decode()
+		if err != nil {
+			log.Errorf("skipping item in %s: %s", f.Name(), err)
+			continue
+		}
+		batch = append(batch, d)
+	}
+	return batch, head.id, next, nil
+}
+
+// ack marks the batch ending at offset of segment id as delivered, unless
+// the segment got dropped in the meantime
+func (s *Spool) ack(id, offset int64) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	if s.segments[0].id != id {
+		return
+	}
+	s.offset = offset
+	s.compact()
+	s.writeAck()
+}
+
+// compact removes the delivered segments and empties the tail once
+// everything got delivered
+func (s *Spool) compact() {
+	for len(s.segments) > 1 && s.offset >= s.segments[0].size {
+		s.removeHead()
+	}
+	if len(s.segments) == 1 && s.offset > 0 && s.pending() == 0 {
+		s.offset, s.size = 0, 0
+		s.segments[0].size = 0
+		if err := s.tail.Truncate(0); err != nil {
+			log.Errorf("error truncating spool of writer %s: %s", s.name, err)
+		}
+	}
+}
+
+func (s *Spool) writeAck() {
+	path := filepath.Join(s.dir, ackFile)
+	bits := []byte(fmt.Sprintf("%d %d\n", s.segments[0].id, s.offset))
+	err := ioutil.WriteFile(path+".tmp", bits, 0644)
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		log.Errorf("error saving spool position of writer %s: %s", s.name, err)
+	}
+}
+
+func (s *Spool) loop() {
+	defer close(s.done)
+	backoff := minBackoff
+	// what is left of the head batch after a failure, it stays at the head
+	// until delivered and is only acked then, a restart in between sends the
+	// whole batch again
+	var batch []interface{}
+	var id, next int64
+	for {
+		if batch == nil {
+			var err error
+			batch, id, next, err = s.head()
+			if err != nil {
+				log.Errorf("error reading spool of writer %s: %s", s.name, err)
+			}
+		}
+		if batch == nil {
+			select {
+			case <-s.wake:
+				continue
+			case <-s.closeChan:
+				return
+			}
+		}
+		if len(batch) > 0 {
+			err := writeBatch(s.name, s.writer, batch)
+			if pe, ok := err.(*PartialError); ok {
+				// what went through must not be sent again
+				atomic.AddInt64(&s.delivered, int64(len(batch)-len(pe.Failed)))
+				batch = pe.Failed
+			}
+			if err != nil {
+				log.Warnf("retrying %d spooled items of writer %s in %s: %s", len(batch), s.name, backoff, err)
+				select {
+				case <-time.After(backoff):
+				case <-s.closeChan:
+					return
+				}
+				if backoff *= 2; backoff > s.maxBackoff {
+					backoff = s.maxBackoff
+				}
+				continue
+			}
+			atomic.AddInt64(&s.delivered, int64(len(batch)))
+		}
+		backoff = minBackoff
+		batch = nil
+		s.ack(id, next)
+	}
+}
+
+// SpoolsStats returns the counters of every open spool by writer
+func SpoolsStats() map[string]SpoolStats {
+	spoolLock.Lock()
+	defer spoolLock.Unlock()
+	res := make(map[string]SpoolStats, len(spools))
+	for k, s := range spools {
+		res[k] = s.Stats()
+	}
+	return res
+}
+
+func (s *Spool) Stats() SpoolStats {
+	s.locker.Lock()
+	bytes := s.pending()
+	s.locker.Unlock()
+	return SpoolStats{
+		Spooled:   atomic.LoadInt64(&s.spooled),
+		Delivered: atomic.LoadInt64(&s.delivered),
+		Dropped:   atomic.LoadInt64(&s.dropped),
+		Bytes:     bytes,
+	}
+}
+
+// Close stops the retries, batches delivered after it are only spooled and
+// whatever is left on disk gets replayed by the next spool on the same dir
+func (s *Spool) Close() {
+	if !atomic.CompareAndSwapInt32(&s.closed, 0, 1) {
+		return
+	}
+	spoolLock.Lock()
+	if spools[s.name] == s {
+		delete(spools, s.name)
+	}
+	spoolLock.Unlock()
+	s.closeChan <- true
+	<-s.done
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	s.tail.Close()
+}
+
+func ValidateSpool(name string, cfg SpoolConfig) []error {
+	var errs []error
+	if cfg == (SpoolConfig{}) {
+		return nil
+	}
+	if spec, ok := writerSpecs[name]; ok && !spec.spools {
+		return []error{fmt.Errorf("spool: writer %s does not support spooling", name)}
+	}
+	if cfg.Dir == "" {
+		return []error{fmt.Errorf("spool.dir: required to enable spooling")}
+	}
+	if fi, err := os.Stat(cfg.Dir); err != nil || !fi.IsDir() {
+		errs = append(errs, fmt.Errorf("spool.dir: %s is not a directory", cfg.Dir))
+	}
+	if cfg.MaxBytes < 0 {
+		errs = append(errs, fmt.Errorf("spool.max_bytes: can not be negative"))
+	}
+	if cfg.SegmentBytes < 0 {
+		errs = append(errs, fmt.Errorf("spool.segment_bytes: can not be negative"))
+	}
+	if cfg.MaxBytes > 0 && cfg.SegmentBytes > cfg.MaxBytes {
+		errs = append(errs, fmt.Errorf("spool.segment_bytes: larger than max_bytes"))
+	}
+	if cfg.MaxBackoff.Duration < 0 {
+		errs = append(errs, fmt.Errorf("spool.max_backoff: can not be negative"))
+	}
+	return errs
+}
@@ -0,0 +1,228 @@
+package storage
+
+import (
+	"cryptoCrawl/crawler"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyWriter fails every batch while down
+type flakyWriter struct {
+	locker sync.Mutex
+	down   bool
+	items  []interface{}
+}
+
+func (w *flakyWriter) WriteBatch(batch []interface{}) error {
+	w.locker.Lock()
+	defer w.locker.Unlock()
+	if w.down {
+		return fmt.Errorf("backend down")
+	}
+	w.items = append(w.items, batch...)
+	return nil
+}
+
+func (w *flakyWriter) setDown(down bool) {
+	w.locker.Lock()
+	defer w.locker.Unlock()
+	w.down = down
+}
+
+func (w *flakyWriter) prices() []float64 {
+	w.locker.Lock()
+	defer w.locker.Unlock()
+	var res []float64
+	for _, d := range w.items {
+		res = append(res, d.(crawler.TradeMeasurement).Price)
+	}
+	return res
+}
+
+func tradeBatch(from, to int) []interface{} {
+	var res []interface{}
+	for i := from; i <= to; i++ {
+		res = append(res, crawler.TradeMeasurement{Meta: "trade", Price: float64(i)})
+	}
+	return res
+}
+
+func TestSpoolReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := SpoolConfig{Dir: dir, SegmentBytes: 200}
+	w := &flakyWriter{down: true}
+	s, err := NewSpool("influxdb", w, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		s.Deliver(tradeBatch(i*3+1, i*3+3))
+	}
+	s.Close()
+	if st := s.Stats(); st.Spooled != 15 || st.Delivered != 0 {
+		t.Fatalf("expected 15 spooled items, got %+v", st)
+	}
+	files, _ := ioutil.ReadDir(dir + "/influxdb")
+	if len(files) < 3 {
+		t.Errorf("expected the batches to span several segments, got %d files", len(files))
+	}
+
+	// a restarted writer delivers the spooled batches first
+	w.setDown(false)
+	s, err = NewSpool("influxdb", w, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Deliver(tradeBatch(16, 16))
+	deadline := time.Now().Add(5 * time.Second)
+	for len(w.prices()) < 16 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	prices := w.prices()
+	if len(prices) != 16 {
+		t.Fatalf("expected 16 delivered items, got %v", prices)
+	}
+	for i, p := range prices {
+		if p != float64(i+1) {
+			t.Fatalf("expected items in order, got %v", prices)
+		}
+	}
+	if st := s.Stats(); st.Bytes != 0 {
+		t.Errorf("expected an empty spool, got %+v", st)
+	}
+}
+
+func TestSpoolMaxBytes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	w := &flakyWriter{down: true}
+	s, err := NewSpool("es", w, SpoolConfig{Dir: dir, MaxBytes: 600, SegmentBytes: 150})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		s.Deliver(tradeBatch(i+1, i+1))
+	}
+	s.Close()
+	if st := s.Stats(); st.Bytes > 600 || st.Bytes == 0 {
+		t.Fatalf("expected the spool to be capped, got %+v", st)
+	}
+
+	w.setDown(false)
+	s, err = NewSpool("es", w, SpoolConfig{Dir: dir, MaxBytes: 600, SegmentBytes: 150})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Stats().Bytes > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	prices := w.prices()
+	// the oldest batches were dropped, the newest made it
+	if len(prices) == 0 || len(prices) == 10 || prices[len(prices)-1] != 10 {
+		t.Errorf("expected the newest batches to be delivered, got %v", prices)
+	}
+}
+
+// partialWriter fails the items from price failFrom on, once
+type partialWriter struct {
+	flakyWriter
+	failFrom float64
+}
+
+func (w *partialWriter) WriteBatch(batch []interface{}) error {
+	for i, d := range batch {
+		if d.(crawler.TradeMeasurement).Price == w.failFrom {
+			if err := w.flakyWriter.WriteBatch(batch[:i]); err != nil {
+				return err
+			}
+			w.failFrom = 0
+			return &PartialError{Failed: batch[i:], Err: fmt.Errorf("rejected")}
+		}
+	}
+	return w.flakyWriter.WriteBatch(batch)
+}
+
+func TestSpoolPartialRetryInOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	w := &partialWriter{flakyWriter: flakyWriter{down: true}, failFrom: 2}
+	s, err := NewSpool("partial", w, SpoolConfig{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Deliver(tradeBatch(1, 3))
+	s.Deliver(tradeBatch(4, 5))
+	w.setDown(false)
+	s.signal()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(w.prices()) < 5 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	prices := w.prices()
+	if len(prices) != 5 {
+		t.Fatalf("expected 5 delivered items, got %v", prices)
+	}
+	for i, p := range prices {
+		if p != float64(i+1) {
+			t.Fatalf("expected the failed items to be retried first, got %v", prices)
+		}
+	}
+}
+
+// batchOnlyWriter delivers in batches without batching on its own
+type batchOnlyWriter struct {
+	flakyWriter
+}
+
+func (w *batchOnlyWriter) Write(d interface{}) {
+	w.WriteBatch([]interface{}{d})
+}
+
+func TestAttachSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if _, err := AttachSpool("slice", &sliceWriter{}, SpoolConfig{Dir: dir}); err == nil {
+		t.Error("a writer without batches should not be spooled")
+	}
+	w := &batchOnlyWriter{flakyWriter{down: true}}
+	sw, err := AttachSpool("batches", w, SpoolConfig{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range tradeBatch(1, 3) {
+		sw.Write(d)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for SpoolsStats()["batches"].Spooled < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	w.setDown(false)
+	for len(w.prices()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if prices := w.prices(); len(prices) != 3 {
+		t.Errorf("expected the spooled items to be delivered, got %v", prices)
+	}
+	sw.(Closer).Close()
+}
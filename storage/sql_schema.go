@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"cryptoCrawl/crawler"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// kinds of sql columns, each dialect has its own type for them
+const (
+	textColumn = iota
+	floatColumn
+	timeColumn
+)
+
+type sqlColumn struct {
+	name string
+	kind int
+	// null is allowed
+	null bool
+}
+
+// sqlTable is the table measurements of a meta go to
+type sqlTable struct {
+	name    string
+	columns []sqlColumn
+}
+
+var (
+	orderColumns = []sqlColumn{
+		{"time", timeColumn, false},
+		{"platform", textColumn, false},
+		{"pair", textColumn, false},
+		{"type", textColumn, true},
+		{"amount", floatColumn, true},
+		{"price", floatColumn, true},
+	}
+	sqlTables = map[string]sqlTable{
+		"trade": {"trades", []sqlColumn{
+			{"time", timeColumn, false},
+			{"platform", textColumn, false},
+			{"pair", textColumn, false},
+			{"trade_type", textColumn, true},
+			{"type", textColumn, true},
+			{"amount", floatColumn, true},
+			{"price", floatColumn, true},
+			{"trade_id", textColumn, true},
+		}},
+		"order":  {"orders", orderColumns},
+		"cancel": {"cancels", orderColumns},
+	}
+)
+
+func (t sqlTable) columnNames() []string {
+	res := make([]string, len(t.columns))
+	for i, c := range t.columns {
+		res[i] = c.name
+	}
+	return res
+}
+
+// create is the statement creating the table, types maps column kinds to
+// the types of the dialect
+func (t sqlTable) create(types map[int]string) string {
+	cols := make([]string, len(t.columns))
+	for i, c := range t.columns {
+		cols[i] = c.name + " " + types[c.kind]
+		if !c.null {
+			cols[i] += " NOT NULL"
+		}
+	}
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", t.name, strings.Join(cols, ", "))
+}
+
+// index is the statement creating the index most queries go through
+func (t sqlTable) index() string {
+	return fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_platform_pair_time ON %s (platform, pair, time)", t.name, t.name)
+}
+
+// sqlRow returns the table of a measurement and its values in column
+// order, projections are written whole
+func sqlRow(d interface{}) (sqlTable, []interface{}, bool) {
+	if p, ok := d.(Projection); ok {
+		d = p.Measurement
+	}
+	var row []interface{}
+	var meta string
+	switch v := d.(type) {
+	case crawler.TradeMeasurement:
+		meta = v.Meta
+		row = []interface{}{sqlTime(v.Timestamp), v.Platform, v.Pair, v.TradeType, v.TransactionType, v.Amount, v.Price, sqlNull(v.TradeID)}
+	case crawler.OrderMeasurement:
+		meta = v.Meta
+		row = []interface{}{sqlTime(v.Timestamp), v.Platform, v.Pair, v.Type, v.Amount, v.Price}
+	case crawler.CancelMeasurement:
+		meta = v.Meta
+		row = []interface{}{sqlTime(v.TimeStamp), v.Platform, v.Pair, v.Type, nil, v.Price}
+	}
+	t, ok := sqlTables[meta]
+	if !ok || len(row) != len(t.columns) {
+		return t, nil, false
+	}
+	return t, row, true
+}
+
+func sqlTime(ms int64) time.Time {
+	return crawler.FromMillis(ms).UTC()
+}
+
+// sqlNull stores empty strings as null
+func sqlNull(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// groupRows splits a batch by table, in the order tables first show up
+func groupRows(data []interface{}) ([]sqlTable, map[string][][]interface{}, int) {
+	var tables []sqlTable
+	rows := map[string][][]interface{}{}
+	skipped := 0
+	for _, d := range data {
+		t, row, ok := sqlRow(d)
+		if !ok {
+			skipped++
+			continue
+		}
+		if _, ok := rows[t.name]; !ok {
+			tables = append(tables, t)
+		}
+		rows[t.name] = append(rows[t.name], row)
+	}
+	return tables, rows, skipped
+}
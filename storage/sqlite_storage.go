@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	_ "github.com/mattn/go-sqlite3"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+var sqliteTypes = map[int]string{
+	textColumn:  "TEXT",
+	floatColumn: "REAL",
+	// milliseconds like everywhere else
+	timeColumn: "INTEGER",
+}
+
+// sqliteMigrations bring the schema from one version to the next, the
+// version of a database is kept in its user_version, append only
+var sqliteMigrations = []func() []string{
+	func() []string {
+		var res []string
+		for _, t := range sortedTables() {
+			res = append(res, t.create(sqliteTypes), t.index())
+		}
+		return res
+	},
+}
+
+// SqliteStorage inserts measurements in a local database, a transaction per
+// batch
+type SqliteStorage struct {
+	db          *sql.DB
+	batch       batchConfig
+	dataChannel chan interface{}
+	closeChan   chan bool
+	done        chan struct{}
+	spool       *Spool
+}
+
+func NewSqliteStorage(params map[string]string) (DataWriter, error) {
+	path, ok := params["path"]
+	if !ok {
+		return nil, fmt.Errorf("parameter 'path' should be present")
+	}
+	db, err := sql.Open("sqlite3", "file:"+path+"?_journal_mode=WAL&_synchronous=NORMAL&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	// sqlite has a single writer anyway
+	db.SetMaxOpenConns(1)
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	res := &SqliteStorage{
+		db:          db,
+		batch:       parseBatchConfig(params),
+		dataChannel: make(chan interface{}, 10000),
+		closeChan:   make(chan bool),
+		done:        make(chan struct{}),
+	}
+	res.batch.maxInFlight = 1
+	log.Infof("successfully created new sqlite writer with path %s", path)
+	go res.Ingest()
+	return res, nil
+}
+
+// migrate runs the migrations the database has not seen yet
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	for version < len(sqliteMigrations) {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		for _, s := range sqliteMigrations[version]() {
+			if _, err := tx.Exec(s); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("error migrating to version %d: %s", version+1, err)
+			}
+		}
+		version++
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		log.Infof("migrated sqlite database to version %d", version)
+	}
+	return nil
+}
+
+func (s *SqliteStorage) Write(d interface{}) {
+	s.dataChannel <- d
+}
+
+func (s *SqliteStorage) Ingest() {
+	defer close(s.done)
+	b := newBatcher(s.batch, docSize, s.process)
+	for {
+		select {
+		case d := <-s.dataChannel:
+			b.add(d)
+		case <-b.expired():
+			b.send()
+		case <-s.closeChan:
+			for len(s.dataChannel) > 0 {
+				b.add(<-s.dataChannel)
+			}
+			b.close()
+			if s.spool != nil {
+				s.spool.Close()
+			}
+			s.db.Close()
+			return
+		}
+	}
+}
+
+// Close writes what is left, the database is closed once it returns
+func (s *SqliteStorage) Close() {
+	s.closeChan <- true
+	<-s.done
+}
+
+func (s *SqliteStorage) setSpool(sp *Spool) {
+	s.spool = sp
+}
+
+func (s *SqliteStorage) process(data []interface{}) {
+	if len(data) == 0 {
+		return
+	}
+	if s.spool != nil {
+		s.spool.Deliver(data)
+	} else if err := writeBatch("sqlite", s, data); err != nil {
+		log.Error(err)
+	}
+}
+
+// WriteBatch inserts the batch in a single transaction
+func (s *SqliteStorage) WriteBatch(data []interface{}) error {
+	tables, rows, skipped := groupRows(data)
+	if skipped > 0 {
+		log.Errorf("sqlite writer skipped %d measurements without a table", skipped)
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, t := range tables {
+		if err := insertRows(tx, t, rows[t.name]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("error inserting %d rows in %s: %s", len(rows[t.name]), t.name, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing %d rows: %s", len(data)-skipped, err)
+	}
+	log.Infof("successfully inserted %d rows in sqlite", len(data)-skipped)
+	return nil
+}
+
+func insertRows(tx *sql.Tx, t sqlTable, rows [][]interface{}) error {
+	marks := strings.TrimSuffix(strings.Repeat("?, ", len(t.columns)), ", ")
+	stmt, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", t.name, strings.Join(t.columnNames(), ", "), marks))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, r := range rows {
+		for i, v := range r {
+			if tm, ok := v.(time.Time); ok {
+				r[i] = tm.UnixNano() / int64(time.Millisecond)
+			}
+		}
+		if _, err := stmt.Exec(r...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"cryptoCrawl/crawler"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSqliteStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "crawl.db")
+	for run := 0; run < 2; run++ {
+		// the second run finds the schema migrated already
+		w, err := NewSqliteStorage(map[string]string{"path": path, "batch_size": "2"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(crawler.TradeMeasurement{Meta: "trade", Platform: "binance", Pair: "BTCUSD", Price: 1, Timestamp: 1517443200000, TradeID: "1"})
+		w.Write(crawler.OrderMeasurement{Meta: "order", Platform: "binance", Pair: "BTCUSD", Amount: 2, Timestamp: 1517443200001})
+		w.Write(crawler.CancelMeasurement{Meta: "cancel", Platform: "poloniex", Pair: "ETHBTC", Price: 3, TimeStamp: 1517443200002})
+		w.(Closer).Close()
+	}
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var mode string
+	var version int
+	db.QueryRow("PRAGMA journal_mode").Scan(&mode)
+	db.QueryRow("PRAGMA user_version").Scan(&version)
+	if mode != "wal" || version != len(sqliteMigrations) {
+		t.Errorf("expected a migrated database in wal mode, got %s %d", mode, version)
+	}
+	for table, expected := range map[string]int{"trades": 2, "orders": 2, "cancels": 2} {
+		var n int
+		if err := db.QueryRow("SELECT count(*) FROM " + table).Scan(&n); err != nil || n != expected {
+			t.Errorf("expected %d rows in %s, got %d %v", expected, table, n, err)
+		}
+	}
+	var ms int64
+	var amount sql.NullFloat64
+	db.QueryRow("SELECT time, amount FROM cancels").Scan(&ms, &amount)
+	if ms != 1517443200002 || amount.Valid {
+		t.Errorf("unexpected cancel row %d %v", ms, amount)
+	}
+	var plan string
+	rows, _ := db.Query("EXPLAIN QUERY PLAN SELECT * FROM trades WHERE platform = 'binance' AND pair = 'BTCUSD' AND time > 0")
+	for rows.Next() {
+		var id, parent, unused int
+		rows.Scan(&id, &parent, &unused, &plan)
+	}
+	rows.Close()
+	// older sqlite versions print SEARCH TABLE trades
+	if !strings.Contains(plan, "USING INDEX trades_platform_pair_time") {
+		t.Errorf("expected the index to be used, got %s", plan)
+	}
+}
@@ -6,11 +6,22 @@ type DataWriter interface {
 	Write(interface{})
 }
 
+// BatchWriter is implemented by the writers that deliver measurements in
+// batches, a failed batch can be spooled and retried
+type BatchWriter interface {
+	WriteBatch([]interface{}) error
+}
+
 type WriterFactory = func(params map[string]string) (DataWriter, error)
 
 type WriterConfig struct {
 	Name   string            `json:"name"`
 	Params map[string]string `json:"params"`
+	// routing rules, by default a writer gets every measurement as is
+	Filter Filter      `json:"filter"`
+	Fields []string    `json:"fields"`
+	Queue  QueueConfig `json:"queue"`
+	Spool  SpoolConfig `json:"spool"`
 }
 
 func parsePeriod(params map[string]string) (tickPeriod time.Duration) {
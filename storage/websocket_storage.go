@@ -0,0 +1,332 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultClientBuffer = 256
+	wsWriteTimeout      = 10 * time.Second
+	wsPingPeriod        = 30 * time.Second
+)
+
+// channel kinds clients subscribe to, by meta
+var channelKinds = map[string]string{
+	"trade":  "trades",
+	"order":  "quotes",
+	"cancel": "cancels",
+}
+
+// wsRequest is sent by clients to change their subscriptions
+type wsRequest struct {
+	Op       string   `json:"op"`
+	Channels []string `json:"channels"`
+}
+
+// wsReply acknowledges a request or carries a measurement of a channel
+type wsReply struct {
+	Op       string          `json:"op,omitempty"`
+	Channels []string        `json:"channels,omitempty"`
+	Error    string          `json:"error,omitempty"`
+	Channel  string          `json:"channel,omitempty"`
+	Data     json.RawMessage `json:"data,omitempty"`
+}
+
+// channelOf names the channel of a measurement e.g. trades.binance.BTCUSD
+func channelOf(d interface{}) (string, bool) {
+	m, ok := attributes(d)
+	if !ok {
+		return "", false
+	}
+	kind, ok := channelKinds[m.meta]
+	if !ok {
+		return "", false
+	}
+	return kind + "." + m.platform + "." + m.pair, true
+}
+
+// checkChannel checks a subscription, any of its three parts can be *
+func checkChannel(pattern string) error {
+	parts := strings.Split(pattern, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("invalid channel %q, use kind.platform.pair", pattern)
+	}
+	if parts[0] == "*" {
+		return nil
+	}
+	for _, kind := range channelKinds {
+		if parts[0] == kind {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid channel %q, kind should be trades, quotes or cancels", pattern)
+}
+
+func matchChannel(pattern, channel string) bool {
+	p, c := strings.Split(pattern, "."), strings.Split(channel, ".")
+	if len(p) != len(c) {
+		return false
+	}
+	for i := range p {
+		if p[i] != "*" && p[i] != c[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// wsClient is a subscriber, what it can't keep up with piles up in send
+// until it is disconnected
+type wsClient struct {
+	conn *websocket.Conn
+	send chan []byte
+	// closed once the client is gone
+	done      chan struct{}
+	closeOnce sync.Once
+	mu        sync.Mutex
+	patterns  map[string]bool
+}
+
+func (c *wsClient) subscribed(channel string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for p := range c.patterns {
+		if matchChannel(p, channel) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *wsClient) close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		msg := websocket.FormatCloseMessage(code, reason)
+		c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsWriteTimeout))
+		c.conn.Close()
+		close(c.done)
+	})
+}
+
+// WebsocketStorage rebroadcasts the measurements to the websocket clients
+// subscribed to their channel
+type WebsocketStorage struct {
+	listener  net.Listener
+	server    *http.Server
+	upgrader  websocket.Upgrader
+	buffer    int
+	mu        sync.Mutex
+	clients   map[*wsClient]bool
+	dataChan  chan interface{}
+	closeChan chan bool
+	done      chan struct{}
+}
+
+func NewWebsocketStorage(params map[string]string) (DataWriter, error) {
+	address, ok := params["address"]
+	if !ok {
+		return nil, fmt.Errorf("parameter 'address' should be present")
+	}
+	path := "/"
+	if p, ok := params["path"]; ok {
+		path = p
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("successfully created new websocket writer on %s%s", listener.Addr(), path)
+	return newWebsocketStorage(listener, path, parseInt(params, "buffer", defaultClientBuffer)), nil
+}
+
+func newWebsocketStorage(listener net.Listener, path string, buffer int) *WebsocketStorage {
+	res := &WebsocketStorage{
+		listener: listener,
+		// the feed is meant for browsers of other origins too
+		upgrader:  websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }},
+		buffer:    buffer,
+		clients:   map[*wsClient]bool{},
+		dataChan:  make(chan interface{}, 10000),
+		closeChan: make(chan bool),
+		done:      make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, res.serve)
+	res.server = &http.Server{Handler: mux}
+	go func() {
+		if err := res.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Errorf("websocket server stopped: %s", err)
+		}
+	}()
+	go res.Ingest()
+	return res
+}
+
+func (w *WebsocketStorage) Write(d interface{}) {
+	w.dataChan <- d
+}
+
+func (w *WebsocketStorage) Ingest() {
+	defer close(w.done)
+	for {
+		select {
+		case d := <-w.dataChan:
+			w.broadcast(d)
+		case <-w.closeChan:
+			w.server.Close()
+			w.mu.Lock()
+			for c := range w.clients {
+				c.close(websocket.CloseGoingAway, "shutting down")
+			}
+			w.mu.Unlock()
+			return
+		}
+	}
+}
+
+// Close disconnects every client, what is still queued is dropped
+func (w *WebsocketStorage) Close() {
+	w.closeChan <- true
+	<-w.done
+}
+
+// broadcast hands the measurement to its subscribers, it is encoded once
+// and only if someone listens
+func (w *WebsocketStorage) broadcast(d interface{}) {
+	channel, ok := channelOf(d)
+	if !ok {
+		return
+	}
+	var msg []byte
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for c := range w.clients {
+		if !c.subscribed(channel) {
+			continue
+		}
+		if msg == nil {
+			data, err := json.Marshal(d)
+			if err != nil {
+				log.Errorf("dropping measurement: %s", err)
+				return
+			}
+			if msg, err = json.Marshal(wsReply{Channel: channel, Data: data}); err != nil {
+				log.Errorf("dropping measurement: %s", err)
+				return
+			}
+		}
+		select {
+		case c.send <- msg:
+		default:
+			log.Warnf("disconnecting slow websocket client %s", c.conn.RemoteAddr())
+			delete(w.clients, c)
+			go c.close(websocket.ClosePolicyViolation, "slow consumer")
+		}
+	}
+}
+
+func (w *WebsocketStorage) serve(rw http.ResponseWriter, r *http.Request) {
+	conn, err := w.upgrader.Upgrade(rw, r, nil)
+	if err != nil {
+		return
+	}
+	c := &wsClient{
+		conn:     conn,
+		send:     make(chan []byte, w.buffer),
+		done:     make(chan struct{}),
+		patterns: map[string]bool{},
+	}
+	// channels can be subscribed to right away with ?channels=a,b
+	if channels := splitList(r.URL.Query().Get("channels")); len(channels) > 0 {
+		c.send <- w.subscribe(c, wsRequest{Op: "subscribe", Channels: channels})
+	}
+	w.mu.Lock()
+	w.clients[c] = true
+	w.mu.Unlock()
+	log.Infof("websocket client %s connected", conn.RemoteAddr())
+	go w.writeLoop(c)
+	w.readLoop(c)
+	w.mu.Lock()
+	delete(w.clients, c)
+	w.mu.Unlock()
+	c.close(websocket.CloseNormalClosure, "")
+	log.Infof("websocket client %s disconnected", conn.RemoteAddr())
+}
+
+// readLoop handles the subscription requests of the client until it goes away
+func (w *WebsocketStorage) readLoop(c *wsClient) {
+	c.conn.SetReadLimit(64 * 1024)
+	c.conn.SetReadDeadline(time.Now().Add(2 * wsPingPeriod))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(2 * wsPingPeriod))
+	})
+	for {
+		var req wsRequest
+		if err := c.conn.ReadJSON(&req); err != nil {
+			if _, ok := err.(*json.SyntaxError); !ok {
+				return
+			}
+			req = wsRequest{}
+		}
+		select {
+		case c.send <- w.subscribe(c, req):
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (w *WebsocketStorage) writeLoop(c *wsClient) {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				c.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				c.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// subscribe applies a request of the client and returns the reply to it
+func (w *WebsocketStorage) subscribe(c *wsClient, req wsRequest) []byte {
+	reply := wsReply{Op: req.Op, Channels: req.Channels}
+	if req.Op != "subscribe" && req.Op != "unsubscribe" {
+		reply = wsReply{Error: "op should be subscribe or unsubscribe"}
+	}
+	for _, ch := range req.Channels {
+		if err := checkChannel(ch); err != nil && reply.Error == "" {
+			reply = wsReply{Op: req.Op, Error: err.Error()}
+		}
+	}
+	if reply.Error == "" {
+		c.mu.Lock()
+		for _, ch := range req.Channels {
+			if req.Op == "subscribe" {
+				c.patterns[ch] = true
+			} else {
+				delete(c.patterns, ch)
+			}
+		}
+		c.mu.Unlock()
+	}
+	res, _ := json.Marshal(reply)
+	return res
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"cryptoCrawl/crawler"
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatchChannel(t *testing.T) {
+	cases := []struct {
+		pattern, channel string
+		match            bool
+	}{
+		{"trades.binance.BTCUSD", "trades.binance.BTCUSD", true},
+		{"quotes.*.ETHUSD", "quotes.kraken.ETHUSD", true},
+		{"quotes.*.ETHUSD", "trades.kraken.ETHUSD", false},
+		{"*.*.*", "cancels.poloniex.BTCETH", true},
+		{"trades.binance", "trades.binance.BTCUSD", false},
+	}
+	for _, c := range cases {
+		if got := matchChannel(c.pattern, c.channel); got != c.match {
+			t.Errorf("matchChannel(%s, %s) = %v", c.pattern, c.channel, got)
+		}
+	}
+	if err := checkChannel("books.*.ETHUSD"); err == nil {
+		t.Error("unknown kind should be refused")
+	}
+}
+
+func dialWebsocket(t *testing.T, w *WebsocketStorage, query string) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+w.listener.Addr().String()+"/"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+func readReply(t *testing.T, conn *websocket.Conn) wsReply {
+	var r wsReply
+	if err := conn.ReadJSON(&r); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestWebsocketStorage(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := newWebsocketStorage(listener, "/", 16)
+	defer w.Close()
+
+	trades := dialWebsocket(t, w, "?channels=trades.binance.BTCUSD")
+	defer trades.Close()
+	if r := readReply(t, trades); r.Op != "subscribe" || r.Error != "" {
+		t.Fatalf("unexpected reply %+v", r)
+	}
+	quotes := dialWebsocket(t, w, "")
+	defer quotes.Close()
+	quotes.WriteJSON(wsRequest{Op: "subscribe", Channels: []string{"books.*.ETHUSD"}})
+	if r := readReply(t, quotes); r.Error == "" {
+		t.Fatalf("invalid channel should be refused, got %+v", r)
+	}
+	quotes.WriteJSON(wsRequest{Op: "subscribe", Channels: []string{"quotes.*.ETHUSD"}})
+	if r := readReply(t, quotes); r.Error != "" {
+		t.Fatal(r.Error)
+	}
+
+	w.Write(crawler.OrderMeasurement{Meta: "order", Platform: "kraken", Pair: "BTCUSD", Price: 1})
+	w.Write(crawler.TradeMeasurement{Meta: "trade", Platform: "binance", Pair: "BTCUSD", Price: 2})
+	w.Write(crawler.OrderMeasurement{Meta: "order", Platform: "kraken", Pair: "ETHUSD", Price: 3})
+
+	r := readReply(t, trades)
+	var trade crawler.TradeMeasurement
+	json.Unmarshal(r.Data, &trade)
+	if r.Channel != "trades.binance.BTCUSD" || trade.Price != 2 {
+		t.Errorf("unexpected trade %s %s", r.Channel, r.Data)
+	}
+	r = readReply(t, quotes)
+	var order crawler.OrderMeasurement
+	json.Unmarshal(r.Data, &order)
+	if r.Channel != "quotes.kraken.ETHUSD" || order.Price != 3 {
+		t.Errorf("unexpected quote %s %s", r.Channel, r.Data)
+	}
+}
+
+func TestWebsocketSlowConsumer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := newWebsocketStorage(listener, "/", 1)
+	defer w.Close()
+
+	// a client nobody writes to, so that its buffer fills up
+	clients := make(chan *wsClient, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		conn, err := w.upgrader.Upgrade(rw, r, nil)
+		if err != nil {
+			return
+		}
+		clients <- &wsClient{conn: conn, send: make(chan []byte, 1), done: make(chan struct{}),
+			patterns: map[string]bool{"trades.*.*": true}}
+	}))
+	defer srv.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := <-clients
+	w.mu.Lock()
+	w.clients[c] = true
+	w.mu.Unlock()
+
+	trade := crawler.TradeMeasurement{Meta: "trade", Platform: "binance", Pair: "BTCUSD"}
+	w.broadcast(trade)
+	w.broadcast(trade)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Fatalf("slow consumer should be disconnected, got %v", err)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.clients[c] {
+		t.Error("slow consumer should be forgotten")
+	}
+}